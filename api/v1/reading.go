@@ -0,0 +1,39 @@
+package v1
+
+import "time"
+
+// Raw timestamps below this are taken to be Unix seconds, anything above
+// Unix milliseconds. 1e11 seconds is roughly the year 5138.
+const secondsCutoff = 100_000_000_000
+
+// ReadingTime returns when the reading was taken.
+// Readings from publishers that predate observed_at only carry the
+// deprecated timestamp field, which is decoded as Unix seconds or
+// milliseconds depending on its magnitude.
+func (x *SensorReading) ReadingTime() time.Time {
+	if x.GetObservedAt() != nil {
+		return x.ObservedAt.AsTime()
+	}
+
+	ts := x.GetTimestamp()
+	if ts < secondsCutoff {
+		return time.Unix(ts, 0).UTC()
+	}
+	return time.UnixMilli(ts).UTC()
+}
+
+// MetricValue returns the value of the named metric. A nil reading only
+// checks the name.
+func MetricValue(reading *SensorReading, metric string) (float64, bool) {
+	switch metric {
+	case "temperature":
+		return reading.GetTemperature(), true
+	case "humidity":
+		return reading.GetHumidity(), true
+	case "co_level":
+		return reading.GetCoLevel(), true
+	case "battery_level":
+		return reading.GetBatteryLevel(), true
+	}
+	return 0, false
+}
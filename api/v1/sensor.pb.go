@@ -9,6 +9,7 @@ package v1
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -22,14 +23,22 @@ const (
 )
 
 type SensorReading struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	SensorId      string                 `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
-	SensorZone    string                 `protobuf:"bytes,2,opt,name=sensor_zone,json=sensorZone,proto3" json:"sensor_zone,omitempty"`
-	Timestamp     int64                  `protobuf:"varint,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
-	Temperature   float64                `protobuf:"fixed64,4,opt,name=temperature,proto3" json:"temperature,omitempty"`
-	Humidity      float64                `protobuf:"fixed64,5,opt,name=humidity,proto3" json:"humidity,omitempty"`
-	CoLevel       float64                `protobuf:"fixed64,6,opt,name=co_level,json=coLevel,proto3" json:"co_level,omitempty"`
-	BatteryLevel  float64                `protobuf:"fixed64,7,opt,name=battery_level,json=batteryLevel,proto3" json:"battery_level,omitempty"`
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	SensorId   string                 `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
+	SensorZone string                 `protobuf:"bytes,2,opt,name=sensor_zone,json=sensorZone,proto3" json:"sensor_zone,omitempty"`
+	// Unix milliseconds. Superseded by observed_at but still populated for
+	// consumers that predate it.
+	//
+	// Deprecated: Marked as deprecated in api/v1/sensor.proto.
+	Timestamp    int64                  `protobuf:"varint,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Temperature  float64                `protobuf:"fixed64,4,opt,name=temperature,proto3" json:"temperature,omitempty"`
+	Humidity     float64                `protobuf:"fixed64,5,opt,name=humidity,proto3" json:"humidity,omitempty"`
+	CoLevel      float64                `protobuf:"fixed64,6,opt,name=co_level,json=coLevel,proto3" json:"co_level,omitempty"`
+	BatteryLevel float64                `protobuf:"fixed64,7,opt,name=battery_level,json=batteryLevel,proto3" json:"battery_level,omitempty"`
+	ObservedAt   *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=observed_at,json=observedAt,proto3" json:"observed_at,omitempty"`
+	// Values as reported by the sensor. Set by the processor when a
+	// calibration was applied, in which case the fields above are calibrated.
+	Raw           *RawValues `protobuf:"bytes,9,opt,name=raw,proto3" json:"raw,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -78,6 +87,7 @@ func (x *SensorReading) GetSensorZone() string {
 	return ""
 }
 
+// Deprecated: Marked as deprecated in api/v1/sensor.proto.
 func (x *SensorReading) GetTimestamp() int64 {
 	if x != nil {
 		return x.Timestamp
@@ -113,20 +123,419 @@ func (x *SensorReading) GetBatteryLevel() float64 {
 	return 0
 }
 
+func (x *SensorReading) GetObservedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ObservedAt
+	}
+	return nil
+}
+
+func (x *SensorReading) GetRaw() *RawValues {
+	if x != nil {
+		return x.Raw
+	}
+	return nil
+}
+
+type RawValues struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Temperature   float64                `protobuf:"fixed64,1,opt,name=temperature,proto3" json:"temperature,omitempty"`
+	Humidity      float64                `protobuf:"fixed64,2,opt,name=humidity,proto3" json:"humidity,omitempty"`
+	CoLevel       float64                `protobuf:"fixed64,3,opt,name=co_level,json=coLevel,proto3" json:"co_level,omitempty"`
+	BatteryLevel  float64                `protobuf:"fixed64,4,opt,name=battery_level,json=batteryLevel,proto3" json:"battery_level,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RawValues) Reset() {
+	*x = RawValues{}
+	mi := &file_api_v1_sensor_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RawValues) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RawValues) ProtoMessage() {}
+
+func (x *RawValues) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v1_sensor_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RawValues.ProtoReflect.Descriptor instead.
+func (*RawValues) Descriptor() ([]byte, []int) {
+	return file_api_v1_sensor_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RawValues) GetTemperature() float64 {
+	if x != nil {
+		return x.Temperature
+	}
+	return 0
+}
+
+func (x *RawValues) GetHumidity() float64 {
+	if x != nil {
+		return x.Humidity
+	}
+	return 0
+}
+
+func (x *RawValues) GetCoLevel() float64 {
+	if x != nil {
+		return x.CoLevel
+	}
+	return 0
+}
+
+func (x *RawValues) GetBatteryLevel() float64 {
+	if x != nil {
+		return x.BatteryLevel
+	}
+	return 0
+}
+
+type GetLatestRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SensorId      string                 `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
+	Zone          string                 `protobuf:"bytes,2,opt,name=zone,proto3" json:"zone,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetLatestRequest) Reset() {
+	*x = GetLatestRequest{}
+	mi := &file_api_v1_sensor_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetLatestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetLatestRequest) ProtoMessage() {}
+
+func (x *GetLatestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v1_sensor_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetLatestRequest.ProtoReflect.Descriptor instead.
+func (*GetLatestRequest) Descriptor() ([]byte, []int) {
+	return file_api_v1_sensor_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetLatestRequest) GetSensorId() string {
+	if x != nil {
+		return x.SensorId
+	}
+	return ""
+}
+
+func (x *GetLatestRequest) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+type GetLatestResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Readings      []*SensorReading       `protobuf:"bytes,1,rep,name=readings,proto3" json:"readings,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetLatestResponse) Reset() {
+	*x = GetLatestResponse{}
+	mi := &file_api_v1_sensor_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetLatestResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetLatestResponse) ProtoMessage() {}
+
+func (x *GetLatestResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v1_sensor_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetLatestResponse.ProtoReflect.Descriptor instead.
+func (*GetLatestResponse) Descriptor() ([]byte, []int) {
+	return file_api_v1_sensor_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetLatestResponse) GetReadings() []*SensorReading {
+	if x != nil {
+		return x.Readings
+	}
+	return nil
+}
+
+type QueryRangeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// One of sensor_id or zone is required
+	SensorId      string                 `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
+	Zone          string                 `protobuf:"bytes,2,opt,name=zone,proto3" json:"zone,omitempty"`
+	From          *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=from,proto3" json:"from,omitempty"`
+	To            *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=to,proto3" json:"to,omitempty"`
+	Limit         int32                  `protobuf:"varint,5,opt,name=limit,proto3" json:"limit,omitempty"`
+	Cursor        string                 `protobuf:"bytes,6,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryRangeRequest) Reset() {
+	*x = QueryRangeRequest{}
+	mi := &file_api_v1_sensor_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryRangeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryRangeRequest) ProtoMessage() {}
+
+func (x *QueryRangeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v1_sensor_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryRangeRequest.ProtoReflect.Descriptor instead.
+func (*QueryRangeRequest) Descriptor() ([]byte, []int) {
+	return file_api_v1_sensor_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *QueryRangeRequest) GetSensorId() string {
+	if x != nil {
+		return x.SensorId
+	}
+	return ""
+}
+
+func (x *QueryRangeRequest) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *QueryRangeRequest) GetFrom() *timestamppb.Timestamp {
+	if x != nil {
+		return x.From
+	}
+	return nil
+}
+
+func (x *QueryRangeRequest) GetTo() *timestamppb.Timestamp {
+	if x != nil {
+		return x.To
+	}
+	return nil
+}
+
+func (x *QueryRangeRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *QueryRangeRequest) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+type QueryRangeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Readings      []*SensorReading       `protobuf:"bytes,1,rep,name=readings,proto3" json:"readings,omitempty"`
+	NextCursor    string                 `protobuf:"bytes,2,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryRangeResponse) Reset() {
+	*x = QueryRangeResponse{}
+	mi := &file_api_v1_sensor_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryRangeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryRangeResponse) ProtoMessage() {}
+
+func (x *QueryRangeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v1_sensor_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryRangeResponse.ProtoReflect.Descriptor instead.
+func (*QueryRangeResponse) Descriptor() ([]byte, []int) {
+	return file_api_v1_sensor_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *QueryRangeResponse) GetReadings() []*SensorReading {
+	if x != nil {
+		return x.Readings
+	}
+	return nil
+}
+
+func (x *QueryRangeResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+type SubscribeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Zone          string                 `protobuf:"bytes,1,opt,name=zone,proto3" json:"zone,omitempty"`
+	SensorId      string                 `protobuf:"bytes,2,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	mi := &file_api_v1_sensor_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v1_sensor_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_api_v1_sensor_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SubscribeRequest) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetSensorId() string {
+	if x != nil {
+		return x.SensorId
+	}
+	return ""
+}
+
 var File_api_v1_sensor_proto protoreflect.FileDescriptor
 
 const file_api_v1_sensor_proto_rawDesc = "" +
 	"\n" +
-	"\x13api/v1/sensor.proto\x12\tphylax.v1\"\xe9\x01\n" +
+	"\x13api/v1/sensor.proto\x12\tphylax.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xd2\x02\n" +
 	"\rSensorReading\x12\x1b\n" +
 	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\x12\x1f\n" +
 	"\vsensor_zone\x18\x02 \x01(\tR\n" +
-	"sensorZone\x12\x1c\n" +
-	"\ttimestamp\x18\x03 \x01(\x03R\ttimestamp\x12 \n" +
+	"sensorZone\x12 \n" +
+	"\ttimestamp\x18\x03 \x01(\x03B\x02\x18\x01R\ttimestamp\x12 \n" +
 	"\vtemperature\x18\x04 \x01(\x01R\vtemperature\x12\x1a\n" +
 	"\bhumidity\x18\x05 \x01(\x01R\bhumidity\x12\x19\n" +
 	"\bco_level\x18\x06 \x01(\x01R\acoLevel\x12#\n" +
-	"\rbattery_level\x18\a \x01(\x01R\fbatteryLevelB*Z(github.com/knightfall22/Phylax/api/v1;v1b\x06proto3"
+	"\rbattery_level\x18\a \x01(\x01R\fbatteryLevel\x12;\n" +
+	"\vobserved_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"observedAt\x12&\n" +
+	"\x03raw\x18\t \x01(\v2\x14.phylax.v1.RawValuesR\x03raw\"\x89\x01\n" +
+	"\tRawValues\x12 \n" +
+	"\vtemperature\x18\x01 \x01(\x01R\vtemperature\x12\x1a\n" +
+	"\bhumidity\x18\x02 \x01(\x01R\bhumidity\x12\x19\n" +
+	"\bco_level\x18\x03 \x01(\x01R\acoLevel\x12#\n" +
+	"\rbattery_level\x18\x04 \x01(\x01R\fbatteryLevel\"C\n" +
+	"\x10GetLatestRequest\x12\x1b\n" +
+	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\x12\x12\n" +
+	"\x04zone\x18\x02 \x01(\tR\x04zone\"I\n" +
+	"\x11GetLatestResponse\x124\n" +
+	"\breadings\x18\x01 \x03(\v2\x18.phylax.v1.SensorReadingR\breadings\"\xce\x01\n" +
+	"\x11QueryRangeRequest\x12\x1b\n" +
+	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\x12\x12\n" +
+	"\x04zone\x18\x02 \x01(\tR\x04zone\x12.\n" +
+	"\x04from\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x04from\x12*\n" +
+	"\x02to\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x02to\x12\x14\n" +
+	"\x05limit\x18\x05 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06cursor\x18\x06 \x01(\tR\x06cursor\"k\n" +
+	"\x12QueryRangeResponse\x124\n" +
+	"\breadings\x18\x01 \x03(\v2\x18.phylax.v1.SensorReadingR\breadings\x12\x1f\n" +
+	"\vnext_cursor\x18\x02 \x01(\tR\n" +
+	"nextCursor\"C\n" +
+	"\x10SubscribeRequest\x12\x12\n" +
+	"\x04zone\x18\x01 \x01(\tR\x04zone\x12\x1b\n" +
+	"\tsensor_id\x18\x02 \x01(\tR\bsensorId2\xe9\x01\n" +
+	"\x0eReadingService\x12F\n" +
+	"\tGetLatest\x12\x1b.phylax.v1.GetLatestRequest\x1a\x1c.phylax.v1.GetLatestResponse\x12I\n" +
+	"\n" +
+	"QueryRange\x12\x1c.phylax.v1.QueryRangeRequest\x1a\x1d.phylax.v1.QueryRangeResponse\x12D\n" +
+	"\tSubscribe\x12\x1b.phylax.v1.SubscribeRequest\x1a\x18.phylax.v1.SensorReading0\x01B*Z(github.com/knightfall22/Phylax/api/v1;v1b\x06proto3"
 
 var (
 	file_api_v1_sensor_proto_rawDescOnce sync.Once
@@ -140,16 +549,35 @@ func file_api_v1_sensor_proto_rawDescGZIP() []byte {
 	return file_api_v1_sensor_proto_rawDescData
 }
 
-var file_api_v1_sensor_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_api_v1_sensor_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
 var file_api_v1_sensor_proto_goTypes = []any{
-	(*SensorReading)(nil), // 0: phylax.v1.SensorReading
+	(*SensorReading)(nil),         // 0: phylax.v1.SensorReading
+	(*RawValues)(nil),             // 1: phylax.v1.RawValues
+	(*GetLatestRequest)(nil),      // 2: phylax.v1.GetLatestRequest
+	(*GetLatestResponse)(nil),     // 3: phylax.v1.GetLatestResponse
+	(*QueryRangeRequest)(nil),     // 4: phylax.v1.QueryRangeRequest
+	(*QueryRangeResponse)(nil),    // 5: phylax.v1.QueryRangeResponse
+	(*SubscribeRequest)(nil),      // 6: phylax.v1.SubscribeRequest
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
 }
 var file_api_v1_sensor_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	7, // 0: phylax.v1.SensorReading.observed_at:type_name -> google.protobuf.Timestamp
+	1, // 1: phylax.v1.SensorReading.raw:type_name -> phylax.v1.RawValues
+	0, // 2: phylax.v1.GetLatestResponse.readings:type_name -> phylax.v1.SensorReading
+	7, // 3: phylax.v1.QueryRangeRequest.from:type_name -> google.protobuf.Timestamp
+	7, // 4: phylax.v1.QueryRangeRequest.to:type_name -> google.protobuf.Timestamp
+	0, // 5: phylax.v1.QueryRangeResponse.readings:type_name -> phylax.v1.SensorReading
+	2, // 6: phylax.v1.ReadingService.GetLatest:input_type -> phylax.v1.GetLatestRequest
+	4, // 7: phylax.v1.ReadingService.QueryRange:input_type -> phylax.v1.QueryRangeRequest
+	6, // 8: phylax.v1.ReadingService.Subscribe:input_type -> phylax.v1.SubscribeRequest
+	3, // 9: phylax.v1.ReadingService.GetLatest:output_type -> phylax.v1.GetLatestResponse
+	5, // 10: phylax.v1.ReadingService.QueryRange:output_type -> phylax.v1.QueryRangeResponse
+	0, // 11: phylax.v1.ReadingService.Subscribe:output_type -> phylax.v1.SensorReading
+	9, // [9:12] is the sub-list for method output_type
+	6, // [6:9] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
 }
 
 func init() { file_api_v1_sensor_proto_init() }
@@ -163,9 +591,9 @@ func file_api_v1_sensor_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_v1_sensor_proto_rawDesc), len(file_api_v1_sensor_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   7,
 			NumExtensions: 0,
-			NumServices:   0,
+			NumServices:   1,
 		},
 		GoTypes:           file_api_v1_sensor_proto_goTypes,
 		DependencyIndexes: file_api_v1_sensor_proto_depIdxs,
@@ -2,18 +2,40 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"database/sql"
 	"embed"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 
+	"github.com/jackc/pgx/v5/pgxpool"
+	pb "github.com/knightfall22/Phylax/api/v1"
 	"github.com/knightfall22/Phylax/config"
+	"github.com/knightfall22/Phylax/internals/alerting"
+	"github.com/knightfall22/Phylax/internals/anomaly"
+	"github.com/knightfall22/Phylax/internals/battery"
+	"github.com/knightfall22/Phylax/internals/calibration"
+	"github.com/knightfall22/Phylax/internals/deadletter"
+	"github.com/knightfall22/Phylax/internals/grpcapi"
+	"github.com/knightfall22/Phylax/internals/health"
+	"github.com/knightfall22/Phylax/internals/heartbeat"
+	"github.com/knightfall22/Phylax/internals/livefeed"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/notifier"
 	"github.com/knightfall22/Phylax/internals/processor"
+	"github.com/knightfall22/Phylax/internals/quarantine"
+	"github.com/knightfall22/Phylax/internals/query"
+	"github.com/knightfall22/Phylax/internals/registry"
+	"github.com/knightfall22/Phylax/internals/sink"
+	"github.com/knightfall22/Phylax/internals/spill"
+	"github.com/knightfall22/Phylax/internals/tracing"
+	"github.com/knightfall22/Phylax/internals/validation"
 	"github.com/knightfall22/Phylax/publisher"
-	"github.com/nats-io/nats.go/jetstream"
 	"github.com/pressly/goose/v3"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/knightfall22/Phylax/internals/metrics"
@@ -23,35 +45,54 @@ import (
 var embedMigrations embed.FS
 
 type App struct {
-	Processor   *processor.Processor
-	Publisher   *publisher.NatsPublisher
-	consumerCtx jetstream.ConsumeContext
+	Processor  *processor.Processor
+	DBPool     *pgxpool.Pool
+	Publisher  *publisher.NatsPublisher
+	Alerts     *alerting.Engine
+	Notifier   *notifier.Notifier
+	Heartbeats *heartbeat.Tracker
+	Anomalies  *anomaly.Detector
+	Battery    *battery.Forecaster
+	Registry   *registry.Registry
+	Calibrator *calibration.Calibrator
+	GRPCServer *grpc.Server
+
+	log             *slog.Logger
+	shutdownTracing func(context.Context) error
 }
 
 func Run(ctx context.Context, conf *config.Config) *App {
+	logger := logging.Component("app")
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Options{
+		ServiceName: config.ServiceName,
+		Exporter:    conf.TracingExporter,
+		File:        conf.TracingFile,
+		SampleRatio: conf.TracingSampleRatio,
+	})
+	if err != nil {
+		logging.Fatal(logger, "Failed to set up tracing", logging.Err(err))
+	}
 
 	connectionStream := fmt.Sprintf("postgres://%s:%s@%s:%s/%s",
 		conf.DBUser, conf.DBPassword, conf.DBHost, conf.DBPort, conf.DBName)
 
 	db, err := sql.Open("pgx", connectionStream)
 	if err != nil {
-		log.Fatalf("Failed to open DB for migrations: %v", err)
+		logging.Fatal(logger, "Failed to open DB for migrations", logging.Err(err))
 	}
 	defer db.Close()
 
 	goose.SetBaseFS(embedMigrations)
 
 	if err := goose.SetDialect("postgres"); err != nil {
-		log.Fatalf("Failed to set goose dialect: %v", err)
+		logging.Fatal(logger, "Failed to set goose dialect", logging.Err(err))
 	}
 
 	if err := goose.Up(db, "db/migration"); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+		logging.Fatal(logger, "Failed to run migrations", logging.Err(err))
 	}
 
-	processor := processor.NewProcessor(ctx, connectionStream)
-	processor.Start(ctx)
-
 	nc, err := publisher.NATSConnect(ctx, publisher.NATSConnectionOptions{
 		TLSEnabled: conf.TLSEnabled,
 		ClientCert: conf.ClientCert,
@@ -60,34 +101,384 @@ func Run(ctx context.Context, conf *config.Config) *App {
 		URL:        conf.NATSURL,
 	})
 	if err != nil {
-		log.Panicf("[Error] cannot connect NATS server %v\n", err)
+		logging.Fatal(logger, "Cannot connect to NATS server", logging.Err(err))
+	}
+
+	dlq, err := deadletter.New(ctx, nc.JetStream())
+	if err != nil {
+		logging.Fatal(logger, "Cannot create dead-letter stream", logging.Err(err))
+	}
+
+	tuning := conf.Tuning.Current()
+	pool, err := openPool(ctx, connectionStream, tuning.PoolSize)
+	if err != nil {
+		logging.Fatal(logger, "Unable to connect to DB", logging.Err(err))
+	}
+
+	sinks, err := buildSinks(conf, pool, dlq)
+	if err != nil {
+		logging.Fatal(logger, "Unable to create sinks", logging.Err(err))
+	}
+
+	strictAction := processor.Quarantine
+	if conf.SensorStrictAction == "reject" {
+		strictAction = processor.Reject
+	}
+	sensors, err := registry.New(ctx, pool, registry.Options{
+		Strict:       conf.SensorStrict,
+		StrictAction: strictAction,
+	})
+	if err != nil {
+		logging.Fatal(logger, "Failed to load sensor registry", logging.Err(err))
+	}
+	sensors.Start(ctx)
+
+	calibrator, err := calibration.New(ctx, pool)
+	if err != nil {
+		logging.Fatal(logger, "Failed to load calibrations", logging.Err(err))
+	}
+	calibrator.Start(ctx)
+
+	bounds := map[string]validation.Bounds{}
+	for metric, b := range validation.DefaultBounds {
+		bounds[metric] = b
+	}
+	for metric, b := range conf.ValidationBounds {
+		bounds[metric] = validation.Bounds{Min: b.Min, Max: b.Max}
 	}
+	validator := validation.New(validation.Options{
+		Bounds:       bounds,
+		MaxClockSkew: conf.ValidationMaxClockSkew,
+	})
+	quarantined := quarantine.NewStore(pool)
+
+	observers := []processor.Observer{}
 
-	consumerCtx, err := nc.Consume(ctx, func(m jetstream.Msg) {
-		processor.Submit(m)
+	var alerts *alerting.Engine
+	if conf.AlertRulesPath != "" {
+		rules, err := alerting.LoadRules(conf.AlertRulesPath)
+		if err != nil {
+			logging.Fatal(logger, "Failed to load alert rules", logging.Err(err))
+		}
 
+		alerts, err = alerting.NewEngine(ctx, nc.JetStream(), rules)
+		if err != nil {
+			logging.Fatal(logger, "Cannot create alert stream", logging.Err(err))
+		}
+		alerts.Start(ctx)
+		observers = append(observers, alerts)
+		logger.Info("Loaded alert rules", "rules", len(rules), "path", conf.AlertRulesPath)
+	}
+
+	heartbeats, err := heartbeat.NewTracker(ctx, nc.JetStream(), heartbeat.Options{
+		MissedIntervals: conf.HeartbeatMissedIntervals,
+		ZoneIntervals:   conf.HeartbeatZoneIntervals,
+		CheckInterval:   conf.HeartbeatCheckInterval,
+		MinInterval:     conf.HeartbeatMinInterval,
 	})
 	if err != nil {
-		log.Panicf("[Error] cannot connect NATS server %v\n", err)
+		logging.Fatal(logger, "Cannot create heartbeat tracker", logging.Err(err))
+	}
+	observers = append(observers, heartbeats)
+
+	var anomalies *anomaly.Detector
+	if conf.AnomalyEnabled {
+		anomalies, err = anomaly.NewDetector(ctx, nc.JetStream(), pool, anomaly.Options{
+			Metrics:            conf.AnomalyMetrics,
+			ZLimit:             conf.AnomalyZLimit,
+			Alpha:              conf.AnomalyAlpha,
+			Warmup:             conf.AnomalyWarmup,
+			CheckpointInterval: conf.AnomalyCheckpointInterval,
+		})
+		if err != nil {
+			logging.Fatal(logger, "Cannot create anomaly detector", logging.Err(err))
+		}
+		anomalies.Start(ctx)
+		observers = append(observers, anomalies)
+	}
+
+	var notify *notifier.Notifier
+	if conf.NotifierConfigPath != "" {
+		channels, err := notifier.LoadConfig(conf.NotifierConfigPath)
+		if err != nil {
+			logging.Fatal(logger, "Failed to load notifier config", logging.Err(err))
+		}
+
+		notify, err = notifier.New(nc.JetStream(), channels)
+		if err != nil {
+			logging.Fatal(logger, "Failed to create notifier", logging.Err(err))
+		}
+		if err := notify.Start(ctx); err != nil {
+			logging.Fatal(logger, "Cannot start notifier", logging.Err(err))
+		}
+		logger.Info("Delivering alerts", "channels", len(channels))
+	}
+
+	consumerOpts := publisher.ConsumerOptions{
+		MaxDeliver:    conf.MaxDeliver,
+		BackOff:       conf.AckBackOff,
+		AckWait:       tuning.AckWait,
+		MaxAckPending: tuning.MaxAckPending,
+	}
+	consumer, err := nc.ProcessorConsumer(ctx, consumerOpts)
+	if err != nil {
+		logging.Fatal(logger, "Cannot create consumer", logging.Err(err))
+	}
+
+	gates := []processor.Gate{validator, sensors}
+	transforms := []processor.Transformer{calibrator}
+	// gRPC Subscribe and the live feed send what would be stored, but only
+	// the processor registers the sensors it sees
+	previewGates := []processor.Gate{validator, sensors.Lookup()}
+
+	proc := processor.NewProcessor(ctx, processor.Options{
+		Source:     consumer,
+		Sink:       sinks,
+		DeadLetter: dlq,
+		Quarantine: quarantined,
+		Retry: processor.RetryPolicy{
+			MaxDeliver: conf.MaxDeliver,
+			BaseDelay:  conf.NakBaseDelay,
+			MaxDelay:   conf.NakMaxDelay,
+		},
+		Breaker: processor.BreakerOptions{
+			Threshold:   conf.BreakerThreshold,
+			Cooldown:    conf.BreakerCooldown,
+			MaxCooldown: conf.BreakerMaxCooldown,
+		},
+		Observers:  observers,
+		Gates:      gates,
+		Transforms: transforms,
+
+		BatchSize:     tuning.BatchSize,
+		FlushInterval: tuning.FlushInterval,
+		Workers:       tuning.Workers,
+		StallTimeout:  conf.HealthStallTimeout,
+
+		ConsumerPollInterval: conf.ConsumerPollInterval,
+		LagThreshold:         conf.ConsumerLagThreshold,
+	})
+	proc.Start(ctx)
+
+	// Missed heartbeats are not counted while readings are held up
+	heartbeats.PauseWhen(proc.Stalled)
+	heartbeats.Start(ctx)
+
+	conf.Tuning.OnChange(func(t config.Tuning) {
+		proc.Tune(t.BatchSize, t.FlushInterval)
+
+		consumerOpts.AckWait, consumerOpts.MaxAckPending = t.AckWait, t.MaxAckPending
+		if err := nc.UpdateConsumer(ctx, consumerOpts); err != nil {
+			logger.Error("Failed to update consumer", logging.Err(err))
+		}
+	})
+
+	forecaster := battery.NewForecaster(pool, battery.Options{
+		Interval:   conf.BatteryForecastInterval,
+		Window:     conf.BatteryForecastWindow,
+		MinSamples: conf.BatteryForecastMinSamples,
+		Timeout:    conf.BatteryForecastTimeout,
+	})
+	forecaster.Start(ctx)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	conf.RegisterRoutes(mux)
+	store := query.NewStore(pool)
+	dlq.RegisterRoutes(mux)
+	store.RegisterRoutes(mux)
+	forecaster.RegisterRoutes(mux)
+	sensors.RegisterRoutes(mux)
+	calibrator.RegisterRoutes(mux)
+	quarantined.RegisterRoutes(mux)
+	proc.RegisterRoutes(mux)
+	livefeed.New(nc, livefeed.Options{Transforms: transforms, Gates: previewGates}).RegisterRoutes(mux)
+
+	checker := health.New(conf.HealthCheckTimeout)
+	checker.Live("nats_closed", nc.CheckClosed)
+	checker.Live("workers", proc.CheckWorkers)
+	checker.Ready("nats", nc.CheckConnection)
+	checker.Ready("jetstream", nc.CheckConsumer)
+	checker.Ready("postgres", pool.Ping)
+	checker.Ready("sink_breaker", proc.CheckBreaker)
+	checker.Ready("backlog", proc.CheckBacklog)
+	checker.RegisterRoutes(mux)
+
+	go func() {
+		logger.Info("HTTP server listening", "addr", ":2112", "metrics", "/metrics", "api", "/api/v1", "probes", "/healthz /readyz")
+		if err := http.ListenAndServe(":2112", mux); err != nil {
+			logger.Error("Metrics server failed", logging.Err(err))
+		}
+	}()
+
+	// Endpoints that change state are kept off the metrics port, which is
+	// exposed to the cluster without authentication
+	if conf.AdminToken == "" {
+		logger.Warn("Admin API disabled, set ADMIN_TOKEN to provision sensors, calibrate and redrive dead letters")
+	} else {
+		admin := http.NewServeMux()
+		dlq.RegisterAdminRoutes(admin)
+		sensors.RegisterAdminRoutes(admin)
+		calibrator.RegisterAdminRoutes(admin)
+
+		go func() {
+			logger.Info("Admin API listening", "addr", conf.AdminAddr)
+			if err := http.ListenAndServe(conf.AdminAddr, requireToken(conf.AdminToken, admin)); err != nil {
+				logger.Error("Admin server failed", logging.Err(err))
+			}
+		}()
+	}
+
+	grpcServer, err := grpcapi.NewServer(grpcapi.Config{
+		CertFile:   conf.ServerCert,
+		KeyFile:    conf.ServerKey,
+		CAFile:     conf.RootCA,
+		Insecure:   conf.GRPCInsecure,
+		Transforms: transforms,
+		Gates:      previewGates,
+	}, store, nc)
+	if err != nil {
+		logging.Fatal(logger, "Failed to create gRPC server", logging.Err(err))
+	}
+
+	lis, err := net.Listen("tcp", conf.GRPCAddr)
+	if err != nil {
+		logging.Fatal(logger, "Failed to listen", "addr", conf.GRPCAddr, logging.Err(err))
 	}
 
 	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		log.Println("Prometheus metrics available at :2112/metrics")
-		if err := http.ListenAndServe(":2112", nil); err != nil {
-			log.Printf("Metrics server failed: %v", err)
+		logger.Info("gRPC ReadingService available", "addr", conf.GRPCAddr, "mtls", !conf.GRPCInsecure)
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Error("gRPC server failed", logging.Err(err))
 		}
 	}()
 
 	return &App{
-		Processor:   processor,
-		Publisher:   nc,
-		consumerCtx: consumerCtx,
+		Processor:  proc,
+		DBPool:     pool,
+		Publisher:  nc,
+		Alerts:     alerts,
+		Notifier:   notify,
+		Heartbeats: heartbeats,
+		Anomalies:  anomalies,
+		Battery:    forecaster,
+		Registry:   sensors,
+		Calibrator: calibrator,
+		GRPCServer: grpcServer,
+
+		log:             logger,
+		shutdownTracing: shutdownTracing,
 	}
 }
 
-func (a *App) Close() {
+// Close shuts the pipeline down in dependency order so that no reading that
+// was already pulled from NATS is lost:
+//  1. stop fetching and flush every worker's partial batch
+//  2. close the DB pool
+//  3. close NATS, flushing pending acks
+//  4. export buffered trace spans
+func (a *App) Close(ctx context.Context) {
+	// Live subscriptions never end on their own
+	a.GRPCServer.Stop()
+
+	result, err := a.Processor.Stop(ctx)
+	if err != nil {
+		a.log.Error("Processor did not stop cleanly", logging.Err(err))
+	}
+	a.log.Info("Processor stopped", "flushed", result.Flushed, "unacked", result.Unacked)
+
+	if a.Alerts != nil {
+		a.Alerts.Stop()
+	}
+	a.Heartbeats.Stop()
+	if a.Anomalies != nil {
+		a.Anomalies.Stop()
+	}
+	a.Battery.Stop()
+	a.Registry.Stop()
+	a.Calibrator.Stop()
+	if a.Notifier != nil {
+		if err := a.Notifier.Stop(ctx); err != nil {
+			a.log.Error("Notifier did not deliver every queued alert", logging.Err(err))
+		}
+	}
+
+	if err := a.Processor.Close(); err != nil {
+		a.log.Error("Failed to close sinks", logging.Err(err))
+	}
+	a.DBPool.Close()
 	a.Publisher.Close()
-	a.consumerCtx.Drain()
-	a.consumerCtx.Stop()
+
+	if err := a.shutdownTracing(ctx); err != nil {
+		a.log.Error("Failed to flush traces", logging.Err(err))
+	}
+}
+
+// Only lets requests through that carry "Authorization: Bearer <token>"
+func requireToken(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="phylax-admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func openPool(ctx context.Context, connectionStream string, size int) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(connectionStream)
+	if err != nil {
+		return nil, err
+	}
+
+	config.MaxConns = int32(size)
+
+	return pgxpool.NewWithConfig(ctx, config)
+}
+
+// Builds the sinks listed in the SINKS configuration
+func buildSinks(conf *config.Config, pool *pgxpool.Pool, dlq *deadletter.Queue) (*sink.Fanout, error) {
+	targets := []sink.Target{}
+	for _, sc := range conf.Sinks {
+		var (
+			s   sink.Sink
+			err error
+		)
+
+		switch sc.Name {
+		case "postgres":
+			s = sink.NewPostgres(pool)
+			if conf.SpillDir != "" {
+				s, err = spill.New(s, spill.Options{
+					Dir:              conf.SpillDir,
+					FailureThreshold: conf.SpillFailureThreshold,
+					SegmentSize:      int64(conf.SpillSegmentBytes),
+					MaxBytes:         int64(conf.SpillMaxBytes),
+					Sync:             spill.SyncPolicy(conf.SpillFsync),
+					SyncInterval:     conf.SpillFsyncInterval,
+					Permanent:        processor.IsPermanent,
+					Reject: func(ctx context.Context, reading *pb.SensorReading, err error) error {
+						return dlq.PublishReading(ctx, reading, fmt.Sprintf("persist: %v", err))
+					},
+				})
+			}
+		case "file":
+			s, err = sink.NewFile(conf.SinkFilePath)
+		case "parquet":
+			s, err = sink.NewParquet(conf.SinkParquetDir)
+		case "memory":
+			s = sink.NewMemory()
+		default:
+			err = fmt.Errorf("unknown sink %q", sc.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, sink.Target{Name: sc.Name, Sink: s, Required: sc.Required})
+	}
+
+	return sink.NewFanout(targets...), nil
 }
@@ -2,11 +2,15 @@ package config
 
 import (
 	"log"
+	"log/slog"
+	"math"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/knightfall22/Phylax/internals/logging"
 )
 
 var ServiceName = "phylax"
@@ -23,6 +27,122 @@ type Config struct {
 	DBHost     string
 	DBName     string
 	DBPort     string
+
+	// Log output: text or json, the minimum level, and how hot-path messages
+	// such as per-flush lines are sampled
+	LogFormat           string
+	LogLevel            slog.Level
+	LogSampleFirst      int
+	LogSampleThereafter int
+
+	// Trace export: none, otlp (endpoint from OTEL_EXPORTER_OTLP_*), stdout
+	// or file. TracingSampleRatio applies to traces not started by a
+	// publisher.
+	TracingExporter    string
+	TracingFile        string
+	TracingSampleRatio float64
+
+	// Optional YAML file holding the processor section. Watched for changes.
+	ConfigFile string
+	// Batch, worker, consumer and pool sizing
+	Tuning *LiveTuning
+
+	// Redelivery policy of the PROCESSOR_WORKERS consumer
+	MaxDeliver   int
+	AckBackOff   []time.Duration
+	NakBaseDelay time.Duration
+	NakMaxDelay  time.Duration
+
+	// Circuit breaker around the sinks. Opens after BreakerThreshold
+	// consecutive failed flushes and pauses consumption for BreakerCooldown,
+	// doubling up to BreakerMaxCooldown while trial flushes keep failing.
+	BreakerThreshold   int
+	BreakerCooldown    time.Duration
+	BreakerMaxCooldown time.Duration
+
+	// Kubernetes probes. A worker stuck for HealthStallTimeout fails
+	// /healthz, a check taking longer than HealthCheckTimeout fails.
+	HealthStallTimeout time.Duration
+	HealthCheckTimeout time.Duration
+
+	// How often the processor consumer info is polled for lag metrics, and
+	// the undelivered count above which the consumer counts as lagging
+	ConsumerPollInterval time.Duration
+	ConsumerLagThreshold int
+
+	// Storage sinks the processor writes batches to
+	Sinks          []SinkConfig
+	SinkFilePath   string
+	SinkParquetDir string
+
+	// Local write-ahead log for the postgres sink. Disabled when SpillDir is
+	// empty. Spilling starts after SpillFailureThreshold failed writes, which
+	// must be below BreakerThreshold; the breaker then only opens once
+	// spilling fails too, e.g. when the log is full.
+	SpillDir              string
+	SpillFailureThreshold int
+	SpillSegmentBytes     int
+	SpillMaxBytes         int
+	SpillFsync            string
+	SpillFsyncInterval    time.Duration
+
+	// Alert rules file. Alerting is disabled when empty.
+	AlertRulesPath string
+	// Notification channels file. Notifications are disabled when empty.
+	NotifierConfigPath string
+
+	// Offline sensor detection
+	HeartbeatMissedIntervals int
+	HeartbeatZoneIntervals   map[string]time.Duration
+	HeartbeatCheckInterval   time.Duration
+	HeartbeatMinInterval     time.Duration
+
+	// Per-sensor anomaly detection
+	AnomalyEnabled            bool
+	AnomalyMetrics            []string
+	AnomalyZLimit             float64
+	AnomalyAlpha              float64
+	AnomalyWarmup             int
+	AnomalyCheckpointInterval time.Duration
+
+	// Sensor registry. In strict mode readings from unknown or
+	// decommissioned sensors are refused with SensorStrictAction (reject or
+	// quarantine) instead of auto-registering them.
+	SensorStrict       bool
+	SensorStrictAction string
+
+	// Plausibility checks. Bounds override the built-in limits per metric.
+	ValidationBounds       map[string]Bounds
+	ValidationMaxClockSkew time.Duration
+
+	// Battery depletion forecasting
+	BatteryForecastInterval   time.Duration
+	BatteryForecastWindow     time.Duration
+	BatteryForecastMinSamples int
+	BatteryForecastTimeout    time.Duration
+
+	// gRPC ReadingService. Requires mTLS with ServerCert and ServerKey,
+	// verifying clients against RootCA, unless GRPCInsecure is set.
+	GRPCAddr     string
+	ServerCert   string
+	ServerKey    string
+	GRPCInsecure bool
+
+	// Listener for endpoints that change state, such as provisioning and
+	// DLQ redrive. Requests must carry "Authorization: Bearer AdminToken".
+	// The listener is not started without a token.
+	AdminAddr  string
+	AdminToken string
+}
+
+type Bounds struct {
+	Min float64
+	Max float64
+}
+
+type SinkConfig struct {
+	Name     string
+	Required bool
 }
 
 func LoadConfigurations() *Config {
@@ -78,6 +198,94 @@ func LoadConfigurations() *Config {
 		}
 	}
 
+	maxDeliver := envInt("MAX_DELIVER", 10)
+	ackBackOff := envDurations("ACK_BACKOFF", []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 5 * time.Minute})
+	nakBaseDelay := envDuration("NAK_BASE_DELAY", time.Second)
+	nakMaxDelay := envDuration("NAK_MAX_DELAY", time.Minute)
+
+	if nakBaseDelay > nakMaxDelay {
+		log.Fatalf("NAK_BASE_DELAY (%s) must not exceed NAK_MAX_DELAY (%s)", nakBaseDelay, nakMaxDelay)
+	}
+
+	breakerThreshold := envInt("BREAKER_THRESHOLD", 5)
+	breakerCooldown := envDuration("BREAKER_COOLDOWN", 5*time.Second)
+	breakerMaxCooldown := envDuration("BREAKER_MAX_COOLDOWN", time.Minute)
+	if breakerCooldown > breakerMaxCooldown {
+		log.Fatalf("BREAKER_COOLDOWN (%s) must not exceed BREAKER_MAX_COOLDOWN (%s)", breakerCooldown, breakerMaxCooldown)
+	}
+
+	logFormat := envString("LOG_FORMAT", "text")
+	if logFormat != "text" && logFormat != "json" {
+		log.Fatalf("LOG_FORMAT must be text or json, got %q", logFormat)
+	}
+	logLevel, err := logging.ParseLevel(envString("LOG_LEVEL", "info"))
+	if err != nil {
+		log.Fatalf("Invalid LOG_LEVEL: %v", err)
+	}
+
+	tracingExporter := envString("TRACING_EXPORTER", "none")
+	switch tracingExporter {
+	case "none", "otlp", "stdout", "file":
+	default:
+		log.Fatalf("TRACING_EXPORTER must be none, otlp, stdout or file, got %q", tracingExporter)
+	}
+	tracingSampleRatio := envFloat("TRACING_SAMPLE_RATIO", 0.1)
+	if tracingSampleRatio < 0 || tracingSampleRatio > 1 {
+		log.Fatalf("TRACING_SAMPLE_RATIO (%g) must be between 0 and 1", tracingSampleRatio)
+	}
+
+	configFile := envString("CONFIG_FILE", "phylax.yaml")
+	tuning := loadTuning(configFile, Redelivery{MaxDeliver: maxDeliver, BackOff: ackBackOff})
+
+	sinks := parseSinks(os.Getenv("SINKS"))
+
+	spillFsync := envString("SPILL_FSYNC", "always")
+	switch spillFsync {
+	case "always", "interval", "never":
+	default:
+		log.Fatalf("SPILL_FSYNC must be always, interval or never, got %q", spillFsync)
+	}
+	// The spill log reports spilled batches as written, so the breaker only
+	// sees failures before spilling starts or once the log is full. Were the
+	// breaker to open first, batches would be held back instead of spilled.
+	spillDir := os.Getenv("SPILL_DIR")
+	spillFailureThreshold := envInt("SPILL_FAILURE_THRESHOLD", 3)
+	if spillDir != "" && spillFailureThreshold >= breakerThreshold {
+		log.Fatalf("SPILL_FAILURE_THRESHOLD (%d) must be less than BREAKER_THRESHOLD (%d)", spillFailureThreshold, breakerThreshold)
+	}
+
+	sensorStrictAction := envString("SENSOR_STRICT_ACTION", "quarantine")
+	if sensorStrictAction != "reject" && sensorStrictAction != "quarantine" {
+		log.Fatalf("SENSOR_STRICT_ACTION must be reject or quarantine, got %q", sensorStrictAction)
+	}
+
+	anomalyAlpha := envFloat("ANOMALY_ALPHA", 0.05)
+	if anomalyAlpha <= 0 || anomalyAlpha >= 1 {
+		log.Fatalf("ANOMALY_ALPHA (%g) must be between 0 and 1", anomalyAlpha)
+	}
+	anomalyZLimit := envFloat("ANOMALY_Z_LIMIT", 4)
+	if anomalyZLimit == 0 {
+		log.Fatalf("ANOMALY_Z_LIMIT must be greater than 0")
+	}
+	anomalyMetrics := envList("ANOMALY_METRICS", []string{"temperature", "humidity", "co_level"})
+	for _, m := range anomalyMetrics {
+		switch m {
+		case "temperature", "humidity", "co_level", "battery_level":
+		default:
+			log.Fatalf("Unknown metric %q in ANOMALY_METRICS", m)
+		}
+	}
+
+	serverCert := os.Getenv("ServerCert")
+	serverKey := os.Getenv("ServerKey")
+	grpcInsecure := envBool("GRPC_INSECURE", false)
+	if grpcInsecure && (serverCert != "" || serverKey != "") {
+		log.Fatalf("GRPC_INSECURE is set together with ServerCert or ServerKey, unset one of them")
+	}
+	if !grpcInsecure && (serverCert == "" || serverKey == "" || rootCA == "") {
+		log.Fatalf("ServerCert, ServerKey and RootCA must all be set for gRPC mTLS, or GRPC_INSECURE=true to serve plaintext")
+	}
+
 	return &Config{
 		TLSEnabled: tlsEnabled,
 		ClientCert: clientCert,
@@ -89,5 +297,246 @@ func LoadConfigurations() *Config {
 		DBHost:     os.Getenv("DB_HOST"),
 		DBName:     os.Getenv("DB_NAME"),
 		DBPort:     os.Getenv("DB_PORT"),
+
+		LogFormat:           logFormat,
+		LogLevel:            logLevel,
+		LogSampleFirst:      envInt("LOG_SAMPLE_FIRST", 10),
+		LogSampleThereafter: envInt("LOG_SAMPLE_THEREAFTER", 100),
+
+		TracingExporter:    tracingExporter,
+		TracingFile:        envString("TRACING_FILE", "traces.jsonl"),
+		TracingSampleRatio: tracingSampleRatio,
+
+		ConfigFile: configFile,
+		Tuning:     tuning,
+
+		MaxDeliver:   maxDeliver,
+		AckBackOff:   ackBackOff,
+		NakBaseDelay: nakBaseDelay,
+		NakMaxDelay:  nakMaxDelay,
+
+		BreakerThreshold:   breakerThreshold,
+		BreakerCooldown:    breakerCooldown,
+		BreakerMaxCooldown: breakerMaxCooldown,
+
+		HealthStallTimeout: envDuration("HEALTH_STALL_TIMEOUT", 2*time.Minute),
+		HealthCheckTimeout: envDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+
+		ConsumerPollInterval: envDuration("CONSUMER_POLL_INTERVAL", 15*time.Second),
+		ConsumerLagThreshold: envInt("CONSUMER_LAG_THRESHOLD", 50000),
+
+		Sinks:          sinks,
+		SinkFilePath:   envString("SINK_FILE_PATH", "readings.ndjson"),
+		SinkParquetDir: envString("SINK_PARQUET_DIR", "parquet"),
+
+		SpillDir:              spillDir,
+		SpillFailureThreshold: spillFailureThreshold,
+		SpillSegmentBytes:     envInt("SPILL_SEGMENT_BYTES", 64<<20),
+		SpillMaxBytes:         envInt("SPILL_MAX_BYTES", 1<<30),
+		SpillFsync:            spillFsync,
+		SpillFsyncInterval:    envDuration("SPILL_FSYNC_INTERVAL", time.Second),
+
+		AlertRulesPath:     os.Getenv("ALERT_RULES_PATH"),
+		NotifierConfigPath: os.Getenv("NOTIFIER_CONFIG_PATH"),
+
+		HeartbeatMissedIntervals: envInt("HEARTBEAT_MISSED_INTERVALS", 3),
+		HeartbeatZoneIntervals:   envZoneDurations("HEARTBEAT_ZONE_INTERVALS"),
+		HeartbeatCheckInterval:   envDuration("HEARTBEAT_CHECK_INTERVAL", 5*time.Second),
+		HeartbeatMinInterval:     envDuration("HEARTBEAT_MIN_INTERVAL", 500*time.Millisecond),
+
+		AnomalyEnabled:            envBool("ANOMALY_DETECTION", true),
+		AnomalyMetrics:            anomalyMetrics,
+		AnomalyZLimit:             anomalyZLimit,
+		AnomalyAlpha:              anomalyAlpha,
+		AnomalyWarmup:             envInt("ANOMALY_WARMUP", 30),
+		AnomalyCheckpointInterval: envDuration("ANOMALY_CHECKPOINT_INTERVAL", 30*time.Second),
+
+		SensorStrict:       envBool("SENSOR_STRICT", false),
+		SensorStrictAction: sensorStrictAction,
+
+		ValidationBounds:       envBounds("VALIDATION_BOUNDS"),
+		ValidationMaxClockSkew: envDuration("VALIDATION_MAX_CLOCK_SKEW", 5*time.Minute),
+
+		BatteryForecastInterval:   envDuration("BATTERY_FORECAST_INTERVAL", 15*time.Minute),
+		BatteryForecastWindow:     envDuration("BATTERY_FORECAST_WINDOW", 72*time.Hour),
+		BatteryForecastMinSamples: envInt("BATTERY_FORECAST_MIN_SAMPLES", 10),
+		BatteryForecastTimeout:    envDuration("BATTERY_FORECAST_TIMEOUT", 2*time.Minute),
+
+		GRPCAddr:     envString("GRPC_ADDR", ":50051"),
+		ServerCert:   serverCert,
+		ServerKey:    serverKey,
+		GRPCInsecure: grpcInsecure,
+
+		AdminAddr:  envString("ADMIN_ADDR", ":2113"),
+		AdminToken: os.Getenv("ADMIN_TOKEN"),
+	}
+}
+
+// Parses a comma separated list of zone=duration pairs e.g. "kitchen=2s,office=1s"
+func envZoneDurations(name string) map[string]time.Duration {
+	out := map[string]time.Duration{}
+
+	raw := os.Getenv(name)
+	if raw == "" {
+		return out
+	}
+
+	for _, part := range strings.Split(raw, ",") {
+		zone, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		d, err := time.ParseDuration(value)
+		if !ok || zone == "" || err != nil || d <= 0 {
+			log.Fatalf("Invalid value for environment variable '%s': %q", name, raw)
+		}
+		out[zone] = d
+	}
+	return out
+}
+
+// Parses a comma separated list of metric=min:max ranges
+// e.g. "temperature=-20:60,co_level=0:500"
+func envBounds(name string) map[string]Bounds {
+	out := map[string]Bounds{}
+
+	raw := os.Getenv(name)
+	if raw == "" {
+		return out
+	}
+
+	for _, part := range strings.Split(raw, ",") {
+		metric, rng, ok := strings.Cut(strings.TrimSpace(part), "=")
+		minRaw, maxRaw, ok2 := strings.Cut(rng, ":")
+		lo, err := strconv.ParseFloat(minRaw, 64)
+		hi, err2 := strconv.ParseFloat(maxRaw, 64)
+		if !ok || !ok2 || metric == "" || err != nil || err2 != nil || lo > hi {
+			log.Fatalf("Invalid value for environment variable '%s': %q", name, raw)
+		}
+
+		switch metric {
+		case "temperature", "humidity", "co_level", "battery_level":
+		default:
+			log.Fatalf("Unknown metric %q in %s", metric, name)
+		}
+		out[metric] = Bounds{Min: lo, Max: hi}
+	}
+	return out
+}
+
+// Parses the SINKS variable, a comma separated list of sink names.
+// Sinks are required unless suffixed with ":optional", e.g.
+// "postgres,parquet:optional". Defaults to postgres only.
+func parseSinks(raw string) []SinkConfig {
+	if raw == "" {
+		return []SinkConfig{{Name: "postgres", Required: true}}
+	}
+
+	sinks := []SinkConfig{}
+	for _, part := range strings.Split(raw, ",") {
+		name, modifier, _ := strings.Cut(strings.TrimSpace(part), ":")
+		switch name {
+		case "postgres", "file", "parquet", "memory":
+		default:
+			log.Fatalf("Unknown sink %q in SINKS", name)
+		}
+
+		if modifier != "" && modifier != "optional" && modifier != "required" {
+			log.Fatalf("Unknown sink modifier %q in SINKS, expected optional or required", modifier)
+		}
+
+		sinks = append(sinks, SinkConfig{Name: name, Required: modifier != "optional"})
+	}
+	return sinks
+}
+
+func envString(name string, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envInt(name string, fallback int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+
+	v, err := strconv.Atoi(raw)
+	if err != nil || v <= 0 {
+		log.Fatalf("Invalid value for environment variable '%s': %q", name, raw)
+	}
+	return v
+}
+
+// Zero is accepted, callers that need a positive value check for it
+func envFloat(name string, fallback float64) float64 {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		log.Fatalf("Invalid value for environment variable '%s': %q", name, raw)
+	}
+	return v
+}
+
+func envBool(name string, fallback bool) bool {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Fatalf("Invalid value for environment variable '%s': %q", name, raw)
+	}
+	return v
+}
+
+// Parses a comma separated list of names e.g. "temperature,co_level"
+func envList(name string, fallback []string) []string {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+
+	out := []string{}
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+
+	v, err := time.ParseDuration(raw)
+	if err != nil || v <= 0 {
+		log.Fatalf("Invalid value for environment variable '%s': %q", name, raw)
+	}
+	return v
+}
+
+// Parses a comma separated list of durations e.g. "30s,1m,5m"
+func envDurations(name string, fallback []time.Duration) []time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+
+	durations := []time.Duration{}
+	for _, part := range strings.Split(raw, ",") {
+		v, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || v <= 0 {
+			log.Fatalf("Invalid value for environment variable '%s': %q", name, raw)
+		}
+		durations = append(durations, v)
 	}
+	return durations
 }
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/knightfall22/Phylax/internals/logging"
+)
+
+const redacted = "[redacted]"
+
+// Redacted returns a copy of the configuration that is safe to expose.
+// Secrets and the paths of private keys are replaced, as is the password
+// in the NATS URL.
+func (c *Config) Redacted() Config {
+	out := *c
+	for _, secret := range []*string{&out.DBPassword, &out.ClientKey, &out.ServerKey, &out.AdminToken} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	if u, err := url.Parse(out.NATSURL); err == nil {
+		out.NATSURL = u.Redacted()
+	}
+	return out
+}
+
+// The tuning in effect and the redelivery schedule it results in
+func (lt *LiveTuning) MarshalJSON() ([]byte, error) {
+	t := lt.Current()
+	fields := t.fields()
+	fields["redelivery"] = lt.redelivery.fields(t.AckWait)
+	return json.Marshal(fields)
+}
+
+// RegisterRoutes exposes the effective configuration:
+//
+//	GET /debug/config   configuration with secrets redacted and the tuning in effect
+func (c *Config) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /debug/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(c.Redacted()); err != nil {
+			logging.Component("config").Error("Failed to encode config", logging.Err(err))
+		}
+	})
+}
@@ -12,7 +12,8 @@ type TLSConfig struct {
 	KeyFile       string
 	CAFile        string
 	ServerAddress string
-	// Server        bool
+	// Server configures the CA to verify clients (mTLS) rather than servers
+	Server bool
 }
 
 func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
@@ -46,7 +47,12 @@ func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
 			)
 		}
 
-		tlsConf.RootCAs = ca
+		if cfg.Server {
+			tlsConf.ClientCAs = ca
+			tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConf.RootCAs = ca
+		}
 
 		tlsConf.ServerName = cfg.ServerAddress
 	}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/spf13/viper"
+)
+
+// Tuning holds the throughput knobs of the processor and its consumer.
+// Values come from the processor section of the config file and can be
+// overridden with PROCESSOR_* environment variables, e.g.
+// PROCESSOR_BATCH_SIZE=2000.
+type Tuning struct {
+	// Readings per worker batch, also the most a worker fetches at once
+	BatchSize int
+	// Partial batches are flushed after this long
+	FlushInterval time.Duration
+	// Processor workers. Requires a restart.
+	Workers int
+	// Unacknowledged messages the server delivers before pausing
+	MaxAckPending int
+	// How long the server waits for an ack before redelivering. Ignored by
+	// the server while ACK_BACKOFF is set, see Redelivery.
+	AckWait time.Duration
+	// Postgres connections. Requires a restart.
+	PoolSize int
+}
+
+// Defaults used for settings missing from both the file and the environment
+func DefaultTuning() Tuning {
+	workers := runtime.NumCPU()
+	return Tuning{
+		BatchSize:     1500,
+		FlushInterval: time.Second,
+		Workers:       workers,
+		MaxAckPending: max(32000, workers*1500),
+		AckWait:       30 * time.Second,
+		// One connection per worker plus a few for the APIs and jobs
+		PoolSize: workers + 4,
+	}
+}
+
+// Validate checks each setting and how they relate to each other
+func (t Tuning) Validate() error {
+	var errs []error
+	if t.BatchSize <= 0 {
+		errs = append(errs, fmt.Errorf("batch_size must be positive, got %d", t.BatchSize))
+	}
+	if t.FlushInterval < 10*time.Millisecond {
+		errs = append(errs, fmt.Errorf("flush_interval must be at least 10ms, got %s", t.FlushInterval))
+	}
+	if t.Workers <= 0 {
+		errs = append(errs, fmt.Errorf("workers must be positive, got %d", t.Workers))
+	}
+	// Below this the server stops delivering before every worker can fill
+	// a batch, so batches only ever flush on the timer
+	if t.MaxAckPending < t.Workers*t.BatchSize {
+		errs = append(errs, fmt.Errorf("max_ack_pending (%d) must be at least workers × batch_size (%d)",
+			t.MaxAckPending, t.Workers*t.BatchSize))
+	}
+	if t.PoolSize <= t.Workers {
+		errs = append(errs, fmt.Errorf("pool_size (%d) must be greater than workers (%d)", t.PoolSize, t.Workers))
+	}
+	return errors.Join(errs...)
+}
+
+// Durations are shown as strings, e.g. "1s", on /debug/config
+func (t Tuning) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.fields())
+}
+
+func (t Tuning) fields() map[string]any {
+	return map[string]any{
+		"batch_size":      t.BatchSize,
+		"flush_interval":  t.FlushInterval.String(),
+		"workers":         t.Workers,
+		"max_ack_pending": t.MaxAckPending,
+		"ack_wait":        t.AckWait.String(),
+		"pool_size":       t.PoolSize,
+	}
+}
+
+// Redelivery is how the server redelivers readings that were not acked in
+// time. It comes from MAX_DELIVER and ACK_BACKOFF.
+type Redelivery struct {
+	MaxDeliver int
+	// When set, the server waits BackOff[i] for an ack after delivery i+1
+	// instead of AckWait, and the last interval for any later delivery
+	BackOff []time.Duration
+}
+
+// AckDeadlines returns how long the server waits for an ack after each
+// delivery. The last deadline applies to every later delivery.
+func (r Redelivery) AckDeadlines(ackWait time.Duration) []time.Duration {
+	if len(r.BackOff) > 0 {
+		return r.BackOff
+	}
+	return []time.Duration{ackWait}
+}
+
+// Checks the schedule against the server's limits and the tuning. A batch
+// must be flushed and acked well before the server redelivers it, which is
+// decided by BackOff rather than AckWait when BackOff is set.
+func (r Redelivery) validate(t Tuning) error {
+	var errs []error
+	// Zero or less is unlimited
+	if r.MaxDeliver > 0 && r.MaxDeliver < len(r.BackOff) {
+		errs = append(errs, fmt.Errorf("MAX_DELIVER (%d) must be greater than or equal to the number of ACK_BACKOFF intervals (%d)",
+			r.MaxDeliver, len(r.BackOff)))
+	}
+
+	first := r.AckDeadlines(t.AckWait)[0]
+	switch {
+	case first >= 2*t.FlushInterval:
+	case len(r.BackOff) > 0:
+		errs = append(errs, fmt.Errorf("the first ACK_BACKOFF interval (%s) must be at least twice flush_interval (%s)",
+			first, t.FlushInterval))
+	default:
+		errs = append(errs, fmt.Errorf("ack_wait (%s) must be at least twice flush_interval (%s)", first, t.FlushInterval))
+	}
+	return errors.Join(errs...)
+}
+
+// The schedule in effect, shown on /debug/config
+func (r Redelivery) fields(ackWait time.Duration) map[string]any {
+	source := "ack_wait"
+	if len(r.BackOff) > 0 {
+		source = "ack_backoff"
+	}
+
+	var deadlines []string
+	for _, d := range r.AckDeadlines(ackWait) {
+		deadlines = append(deadlines, d.String())
+	}
+	return map[string]any{
+		"max_deliver":   r.MaxDeliver,
+		"source":        source,
+		"ack_deadlines": deadlines,
+	}
+}
+
+// LiveTuning is the current Tuning. When loaded from a file, the file is
+// watched and changes to settings that are safe to apply at runtime are
+// passed to the OnChange callbacks.
+type LiveTuning struct {
+	v *viper.Viper
+
+	// Fixed at startup, checked against every tuning change
+	redelivery Redelivery
+
+	mu        sync.RWMutex
+	current   Tuning
+	callbacks []func(Tuning)
+}
+
+// Reads the processor section of path, if it exists, and PROCESSOR_*
+// environment variables
+func loadTuning(path string, redelivery Redelivery) *LiveTuning {
+	v := viper.New()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	defaults := DefaultTuning()
+	v.SetDefault("processor.batch_size", defaults.BatchSize)
+	v.SetDefault("processor.flush_interval", defaults.FlushInterval)
+	v.SetDefault("processor.workers", defaults.Workers)
+	v.SetDefault("processor.max_ack_pending", defaults.MaxAckPending)
+	v.SetDefault("processor.ack_wait", defaults.AckWait)
+	v.SetDefault("processor.pool_size", defaults.PoolSize)
+
+	fromFile := false
+	if _, err := os.Stat(path); err == nil {
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			log.Fatalf("Failed to read config file %s: %v", path, err)
+		}
+		fromFile = true
+	}
+
+	// Derived defaults follow the configured workers unless set explicitly
+	workers := v.GetInt("processor.workers")
+	v.SetDefault("processor.pool_size", workers+4)
+	v.SetDefault("processor.max_ack_pending", max(32000, workers*v.GetInt("processor.batch_size")))
+
+	lt := &LiveTuning{v: v, redelivery: redelivery}
+	t, err := lt.read()
+	if err != nil {
+		log.Fatalf("Invalid processor tuning: %v", err)
+	}
+	lt.current = t
+
+	if fromFile {
+		v.OnConfigChange(func(e fsnotify.Event) {
+			lt.reload(e.Name)
+		})
+		v.WatchConfig()
+	}
+	return lt
+}
+
+func (lt *LiveTuning) read() (Tuning, error) {
+	var t Tuning
+	t.BatchSize = lt.v.GetInt("processor.batch_size")
+	t.FlushInterval = lt.v.GetDuration("processor.flush_interval")
+	t.Workers = lt.v.GetInt("processor.workers")
+	t.MaxAckPending = lt.v.GetInt("processor.max_ack_pending")
+	t.AckWait = lt.v.GetDuration("processor.ack_wait")
+	t.PoolSize = lt.v.GetInt("processor.pool_size")
+
+	return t, errors.Join(t.Validate(), lt.redelivery.validate(t))
+}
+
+// Runs on config file changes, long after logging was set up
+func (lt *LiveTuning) reload(name string) {
+	logger := logging.Component("config").With("file", name)
+
+	next, err := lt.read()
+	if err != nil {
+		logger.Error("Ignoring change, invalid processor tuning", logging.Err(err))
+		return
+	}
+
+	lt.mu.Lock()
+	prev := lt.current
+
+	// Sizes of goroutine pools and buffers are fixed at startup
+	if next.Workers != prev.Workers || next.PoolSize != prev.PoolSize {
+		logger.Warn("workers and pool_size changes take effect after a restart")
+		next.Workers, next.PoolSize = prev.Workers, prev.PoolSize
+	}
+	if next == prev {
+		lt.mu.Unlock()
+		return
+	}
+
+	lt.current = next
+	callbacks := append([]func(Tuning){}, lt.callbacks...)
+	lt.mu.Unlock()
+
+	logger.Info("Applied processor tuning", "tuning", fmt.Sprintf("%+v", next))
+	for _, fn := range callbacks {
+		fn(next)
+	}
+}
+
+// Current returns the tuning in effect
+func (lt *LiveTuning) Current() Tuning {
+	lt.mu.RLock()
+	defer lt.mu.RUnlock()
+	return lt.current
+}
+
+// OnChange registers fn to be called with the new tuning whenever runtime
+// adjustable settings change
+func (lt *LiveTuning) OnChange(fn func(Tuning)) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	lt.callbacks = append(lt.callbacks, fn)
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTuningValidate(t *testing.T) {
+	valid := Tuning{
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		Workers:       4,
+		MaxAckPending: 400,
+		AckWait:       30 * time.Second,
+		PoolSize:      5,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(t *Tuning)
+		// Substring of the error, empty when valid
+		want string
+	}{
+		{"valid", func(t *Tuning) {}, ""},
+		{"defaults", func(t *Tuning) { *t = DefaultTuning() }, ""},
+		{"zero batch size", func(t *Tuning) { t.BatchSize = 0 }, "batch_size must be positive"},
+		{"zero flush interval", func(t *Tuning) { t.FlushInterval = 0 }, "flush_interval must be at least 10ms"},
+		{"negative flush interval", func(t *Tuning) { t.FlushInterval = -time.Second }, "flush_interval must be at least 10ms"},
+		{"flush interval under the floor", func(t *Tuning) { t.FlushInterval = time.Millisecond }, "flush_interval must be at least 10ms"},
+		{"zero workers", func(t *Tuning) { t.Workers = 0 }, "workers must be positive"},
+		{"ack pending below a batch per worker", func(t *Tuning) { t.MaxAckPending = 399 }, "max_ack_pending (399)"},
+		{"pool no larger than workers", func(t *Tuning) { t.PoolSize = 4 }, "pool_size (4) must be greater than workers (4)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tuning := valid
+			tt.modify(&tuning)
+			checkError(t, tuning.Validate(), tt.want)
+		})
+	}
+}
+
+func TestRedeliveryValidate(t *testing.T) {
+	tuning := Tuning{FlushInterval: time.Second, AckWait: 30 * time.Second}
+	backOff := []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute}
+
+	tests := []struct {
+		name       string
+		redelivery Redelivery
+		tuning     Tuning
+		want       string
+	}{
+		{"ack wait only", Redelivery{MaxDeliver: 10}, tuning, ""},
+		{"back-off", Redelivery{MaxDeliver: 10, BackOff: backOff}, tuning, ""},
+		{"one delivery per interval", Redelivery{MaxDeliver: 3, BackOff: backOff}, tuning, ""},
+		{"fewer deliveries than intervals", Redelivery{MaxDeliver: 2, BackOff: backOff}, tuning,
+			"MAX_DELIVER (2) must be greater than or equal to the number of ACK_BACKOFF intervals (3)"},
+		{"unlimited deliveries", Redelivery{MaxDeliver: -1, BackOff: backOff}, tuning, ""},
+		{"ack wait too short", Redelivery{MaxDeliver: 10}, Tuning{FlushInterval: time.Second, AckWait: 1500 * time.Millisecond},
+			"ack_wait (1.5s) must be at least twice flush_interval (1s)"},
+		// The server ignores AckWait while BackOff is set
+		{"first interval too short", Redelivery{MaxDeliver: 10, BackOff: []time.Duration{time.Second, time.Minute}}, tuning,
+			"the first ACK_BACKOFF interval (1s) must be at least twice flush_interval (1s)"},
+		{"back-off covers a short ack wait", Redelivery{MaxDeliver: 10, BackOff: backOff},
+			Tuning{FlushInterval: time.Second, AckWait: time.Second}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkError(t, tt.redelivery.validate(tt.tuning), tt.want)
+		})
+	}
+}
+
+func checkError(t *testing.T, err error, want string) {
+	t.Helper()
+
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case want != "" && err == nil:
+		t.Fatalf("no error, want %q", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Fatalf("error %q, want %q", err, want)
+	}
+}
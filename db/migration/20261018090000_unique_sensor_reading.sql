@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Remove copies left behind by redelivered batches before enforcing uniqueness
+DELETE FROM sensor_readings a
+    USING sensor_readings b
+    WHERE a.ctid < b.ctid
+      AND a.sensor_id = b.sensor_id
+      AND a.time = b.time;
+
+-- Replaces the plain (sensor_id, time DESC) index
+DROP INDEX IF EXISTS sensor_readings_sensor_id_time_idx;
+
+CREATE UNIQUE INDEX IF NOT EXISTS sensor_readings_sensor_id_time_key
+    ON sensor_readings (sensor_id, time DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS sensor_readings_sensor_id_time_key;
+
+CREATE INDEX IF NOT EXISTS sensor_readings_sensor_id_time_idx
+    ON sensor_readings (sensor_id, time DESC);
+-- +goose StatementEnd
@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE sensor_readings ADD COLUMN observed_at TIMESTAMPTZ;
+
+-- The previous migration stored epoch seconds while the processor has been
+-- writing epoch milliseconds. Values below 1e11 are seconds, the rest millis.
+UPDATE sensor_readings SET observed_at = CASE
+    WHEN time < 100000000000 THEN TO_TIMESTAMP(time)
+    ELSE TO_TIMESTAMP(time / 1000.0)
+END;
+
+-- The same reading may have been stored in both units
+DELETE FROM sensor_readings a
+    USING sensor_readings b
+    WHERE a.ctid < b.ctid
+      AND a.sensor_id = b.sensor_id
+      AND a.observed_at = b.observed_at;
+
+DROP INDEX IF EXISTS sensor_readings_sensor_id_time_key;
+ALTER TABLE sensor_readings DROP COLUMN time;
+ALTER TABLE sensor_readings RENAME COLUMN observed_at TO time;
+ALTER TABLE sensor_readings ALTER COLUMN time SET NOT NULL;
+
+CREATE UNIQUE INDEX sensor_readings_sensor_id_time_key
+    ON sensor_readings (sensor_id, time DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS sensor_readings_sensor_id_time_key;
+
+-- Epoch seconds, as 20260211162310_change_timestamp_type stored them
+ALTER TABLE sensor_readings
+    ALTER COLUMN time TYPE BIGINT
+    USING EXTRACT(EPOCH FROM time)::BIGINT;
+
+-- Readings less than a second apart now share a time
+DELETE FROM sensor_readings a
+    USING sensor_readings b
+    WHERE a.ctid < b.ctid
+      AND a.sensor_id = b.sensor_id
+      AND a.time = b.time;
+
+CREATE UNIQUE INDEX sensor_readings_sensor_id_time_key
+    ON sensor_readings (sensor_id, time DESC);
+-- +goose StatementEnd
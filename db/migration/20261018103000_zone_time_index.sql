@@ -0,0 +1,12 @@
+-- +goose NO TRANSACTION
+-- Built concurrently so ingest keeps writing to sensor_readings meanwhile,
+-- which cannot happen inside a transaction
+
+-- +goose Up
+-- Zone filters on /api/v1/readings, /api/v1/readings/series and the gRPC API
+-- otherwise scan the readings of every sensor in the time range
+CREATE INDEX CONCURRENTLY IF NOT EXISTS sensor_readings_zone_time_idx
+    ON sensor_readings (zone, time DESC);
+
+-- +goose Down
+DROP INDEX CONCURRENTLY IF EXISTS sensor_readings_zone_time_idx;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Rolling per-sensor statistics used by anomaly detection, checkpointed so
+-- baselines survive restarts
+CREATE TABLE IF NOT EXISTS sensor_baselines (
+    sensor_id   TEXT             NOT NULL,
+    metric      TEXT             NOT NULL,
+    zone        TEXT             NOT NULL,
+    mean        DOUBLE PRECISION NOT NULL,
+    variance    DOUBLE PRECISION NOT NULL,
+    samples     BIGINT           NOT NULL,
+    updated_at  TIMESTAMPTZ      NOT NULL,
+    PRIMARY KEY (sensor_id, metric)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS sensor_baselines;
+-- +goose StatementEnd
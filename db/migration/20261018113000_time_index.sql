@@ -0,0 +1,11 @@
+-- +goose NO TRANSACTION
+-- CREATE INDEX CONCURRENTLY cannot run in a transaction
+
+-- +goose Up
+-- The battery forecast reads every sensor over a recent window, which no
+-- index leading on sensor_id or zone can serve
+CREATE INDEX CONCURRENTLY IF NOT EXISTS sensor_readings_time_idx
+    ON sensor_readings (time DESC);
+
+-- +goose Down
+DROP INDEX CONCURRENTLY IF EXISTS sensor_readings_time_idx;
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS sensors (
+    id               TEXT        PRIMARY KEY,
+    zone             TEXT        NOT NULL,
+    location         TEXT        NOT NULL DEFAULT '',
+    model            TEXT        NOT NULL DEFAULT '',
+    installed_at     DATE,
+    status           TEXT        NOT NULL DEFAULT 'active'
+                                 CHECK (status IN ('active', 'decommissioned')),
+    auto_registered  BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS sensors_zone_idx ON sensors (zone);
+
+-- Register every sensor that has already reported, in its latest zone
+INSERT INTO sensors (id, zone, auto_registered)
+SELECT DISTINCT ON (sensor_id) sensor_id, zone, TRUE
+FROM sensor_readings
+ORDER BY sensor_id, time DESC
+ON CONFLICT (id) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS sensors;
+-- +goose StatementEnd
@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+-- calibrated = raw * gain + offset, using the latest profile whose
+-- effective_from is not after the reading's time
+CREATE TABLE IF NOT EXISTS sensor_calibrations (
+    sensor_id       TEXT             NOT NULL,
+    metric          TEXT             NOT NULL
+                                     CHECK (metric IN ('temperature', 'humidity', 'co_level', 'battery_level')),
+    gain            DOUBLE PRECISION NOT NULL DEFAULT 1,
+    "offset"        DOUBLE PRECISION NOT NULL DEFAULT 0,
+    effective_from  TIMESTAMPTZ      NOT NULL,
+    created_at      TIMESTAMPTZ      NOT NULL DEFAULT now(),
+    PRIMARY KEY (sensor_id, metric, effective_from)
+);
+
+-- Lets every processor reload calibrations as soon as they change
+CREATE OR REPLACE FUNCTION notify_sensor_calibrations() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('sensor_calibrations', coalesce(NEW.sensor_id, OLD.sensor_id));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER sensor_calibrations_changed
+    AFTER INSERT OR UPDATE OR DELETE ON sensor_calibrations
+    FOR EACH ROW EXECUTE FUNCTION notify_sensor_calibrations();
+
+-- Values as reported by the sensor. NULL when no calibration was applied.
+ALTER TABLE sensor_readings
+    ADD COLUMN IF NOT EXISTS raw_temperature   DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS raw_humidity      DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS raw_co_level      DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS raw_battery_level DOUBLE PRECISION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE sensor_readings
+    DROP COLUMN IF EXISTS raw_temperature,
+    DROP COLUMN IF EXISTS raw_humidity,
+    DROP COLUMN IF EXISTS raw_co_level,
+    DROP COLUMN IF EXISTS raw_battery_level;
+
+DROP TABLE IF EXISTS sensor_calibrations;
+DROP FUNCTION IF EXISTS notify_sensor_calibrations();
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Readings refused by the processor's validation or registry checks. The
+-- original message is kept so a reading can be corrected and replayed.
+CREATE TABLE IF NOT EXISTS quarantined_readings (
+    id              BIGSERIAL        PRIMARY KEY,
+    quarantined_at  TIMESTAMPTZ      NOT NULL DEFAULT now(),
+    reason          TEXT             NOT NULL,
+    subject         TEXT             NOT NULL,
+    sensor_id       TEXT             NOT NULL,
+    zone            TEXT             NOT NULL,
+    time            TIMESTAMPTZ,
+    temperature     DOUBLE PRECISION,
+    humidity        DOUBLE PRECISION,
+    co_level        DOUBLE PRECISION,
+    battery_level   DOUBLE PRECISION,
+    payload         BYTEA            NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS quarantined_readings_reason_idx
+    ON quarantined_readings (reason, quarantined_at DESC);
+CREATE INDEX IF NOT EXISTS quarantined_readings_sensor_id_idx
+    ON quarantined_readings (sensor_id, quarantined_at DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS quarantined_readings;
+-- +goose StatementEnd
@@ -0,0 +1,420 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/metrics"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	StreamName = "ALERTS"
+
+	// Events are published to alerts.<zone>.<rule>
+	SubjectPrefix = "alerts."
+)
+
+const (
+	// How often idle alert state is evicted
+	pruneInterval = time.Minute
+	// Resolved state is kept, and a silent sensor keeps counting towards its
+	// zone, at least this long after its last reading
+	minIdle = 5 * time.Minute
+)
+
+// Engine evaluates alert rules against every reading the processor decodes
+// and publishes an event whenever an alert starts firing or resolves.
+// It is safe for concurrent use by the processor workers.
+type Engine struct {
+	js    jetstream.JetStream
+	rules []Rule
+	log   *slog.Logger
+	// Sampled, for messages logged per reading
+	hot *slog.Logger
+
+	mu      sync.Mutex
+	sensors map[stateKey]*sensorState
+	zones   map[stateKey]*zoneState
+
+	events chan Event
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+type stateKey struct {
+	rule int
+	key  string
+}
+
+type sensorState struct {
+	status
+	samples []sample // Recent values for rate_of_rise rules
+}
+
+type zoneState struct {
+	status
+	breaching map[string]breach // Sensors meeting the condition
+	cleared   breach            // Sensor that last stopped meeting it
+}
+
+// A sensor's part in a zone alert
+type breach struct {
+	sensorID string
+	value    float64
+	seen     time.Time // When it last reported
+}
+
+type sample struct {
+	at    time.Time
+	value float64
+}
+
+// Firing/resolved state machine shared by both scopes
+type status struct {
+	active bool // Condition currently holds
+	since  time.Time
+	firing bool
+	seen   time.Time // Time of the last reading evaluated
+}
+
+// Creates the ALERTS stream that events are published to
+func CreateStream(ctx context.Context, js jetstream.JetStream) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      StreamName,
+		Retention: jetstream.LimitsPolicy,
+		Subjects:  []string{SubjectPrefix + ">"},
+		MaxAge:    7 * 24 * time.Hour,
+	})
+	return err
+}
+
+func NewEngine(ctx context.Context, js jetstream.JetStream, rules []Rule) (*Engine, error) {
+	if err := CreateStream(ctx, js); err != nil {
+		return nil, err
+	}
+
+	logger := logging.Component("alerting")
+	return &Engine{
+		js:      js,
+		rules:   rules,
+		log:     logger,
+		hot:     logging.Sampled(logger),
+		sensors: make(map[stateKey]*sensorState),
+		zones:   make(map[stateKey]*zoneState),
+		events:  make(chan Event, 1024),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Start publishes events in the background so evaluation never blocks
+// the processor workers on NATS.
+func (e *Engine) Start(ctx context.Context) {
+	e.wg.Add(2)
+
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				e.prune(now)
+			case <-e.done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer e.wg.Done()
+		for {
+			select {
+			case event := <-e.events:
+				e.publish(ctx, event)
+			case <-e.done:
+				// Drain what is already queued before exiting
+				for {
+					select {
+					case event := <-e.events:
+						e.publish(ctx, event)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stop publishes queued events and stops the engine. Call after the
+// processor has stopped.
+func (e *Engine) Stop() {
+	close(e.done)
+	e.wg.Wait()
+}
+
+// Observe evaluates every matching rule against the reading
+func (e *Engine) Observe(reading *pb.SensorReading) {
+	now := reading.ReadingTime()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.rules {
+		rule := &e.rules[i]
+		if !rule.matches(reading) {
+			continue
+		}
+
+		value, _ := pb.MetricValue(reading, rule.Metric)
+
+		sensor := e.sensors[stateKey{i, reading.SensorId}]
+		if sensor == nil {
+			sensor = &sensorState{}
+			e.sensors[stateKey{i, reading.SensorId}] = sensor
+		}
+		sensor.seen = now
+
+		observed := value
+		if rule.Type == RuleRateOfRise {
+			observed = sensor.rise(now, value, rule.Window)
+		}
+		holds := rule.compare(observed)
+
+		switch rule.Scope {
+		case ScopeSensor:
+			if transition := sensor.update(holds, now, rule.For); transition != "" {
+				e.emit(rule, transition, reading.SensorZone, reading.SensorId, observed, &sensor.status, reading, now)
+			}
+
+		case ScopeZone:
+			zone := e.zones[stateKey{i, reading.SensorZone}]
+			if zone == nil {
+				zone = &zoneState{breaching: make(map[string]breach)}
+				e.zones[stateKey{i, reading.SensorZone}] = zone
+			}
+
+			current := breach{sensorID: reading.SensorId, value: observed, seen: now}
+			if holds {
+				zone.breaching[reading.SensorId] = current
+			} else if _, ok := zone.breaching[reading.SensorId]; ok {
+				delete(zone.breaching, reading.SensorId)
+				zone.cleared = current
+			}
+			zone.expire(now, e.staleAfter(i))
+
+			if transition := zone.update(len(zone.breaching) > 0, now, rule.For); transition != "" {
+				// Firing is attributed to a breaching sensor and resolving to
+				// the one that cleared last, which need not be this reading's
+				b := zone.cleared
+				if transition == StateFiring {
+					b = zone.culprit(reading.SensorId)
+				}
+				var cause *pb.SensorReading
+				if b.sensorID == reading.SensorId {
+					cause = reading
+				}
+				e.emit(rule, transition, reading.SensorZone, b.sensorID, b.value, &zone.status, cause, now)
+			}
+		}
+	}
+}
+
+// Stops counting sensors that have not reported for longer than limit, so a
+// breaching sensor that went silent does not keep its zone firing
+func (z *zoneState) expire(now time.Time, limit time.Duration) {
+	for id, b := range z.breaching {
+		if now.Sub(b.seen) > limit {
+			delete(z.breaching, id)
+			if b.seen.After(z.cleared.seen) {
+				z.cleared = b
+			}
+		}
+	}
+}
+
+// Returns the breaching sensor a zone alert that starts firing is attributed
+// to: sensorID if it is breaching, otherwise the one that reported last
+func (z *zoneState) culprit(sensorID string) breach {
+	if b, ok := z.breaching[sensorID]; ok {
+		return b
+	}
+
+	var last breach
+	for _, b := range z.breaching {
+		if last.sensorID == "" || b.seen.After(last.seen) || b.seen.Equal(last.seen) && b.sensorID < last.sensorID {
+			last = b
+		}
+	}
+	return last
+}
+
+// Records value and returns how much it rose compared to the oldest value
+// still inside the window
+func (s *sensorState) rise(now time.Time, value float64, window time.Duration) float64 {
+	s.samples = append(s.samples, sample{at: now, value: value})
+
+	cutoff := now.Add(-window)
+	drop := 0
+	for drop < len(s.samples)-1 && s.samples[drop].at.Before(cutoff) {
+		drop++
+	}
+	s.samples = s.samples[drop:]
+
+	return value - s.samples[0].value
+}
+
+// Advances the state machine and returns the transition, if any.
+// The condition has to hold for at least `hold` before the alert fires.
+func (s *status) update(holds bool, now time.Time, hold time.Duration) State {
+	s.seen = now
+
+	if !holds {
+		wasFiring := s.firing
+		s.active, s.firing = false, false
+		if wasFiring {
+			return StateResolved
+		}
+		return ""
+	}
+
+	if !s.active {
+		s.active = true
+		s.since = now
+	}
+
+	if !s.firing && now.Sub(s.since) >= hold {
+		s.firing = true
+		return StateFiring
+	}
+	return ""
+}
+
+// Queues the event for a transition update just made. If the queue is full
+// the transition is undone so the next reading retries it, keeping the
+// firing gauge and published events in step. reading is nil when no reading
+// of sensorID caused the transition.
+func (e *Engine) emit(rule *Rule, state State, zone, sensorID string, value float64, s *status, reading *pb.SensorReading, now time.Time) {
+	event := Event{
+		Kind:      KindRule,
+		Rule:      rule.Name,
+		Severity:  rule.Severity,
+		State:     state,
+		Scope:     rule.Scope,
+		Zone:      zone,
+		SensorID:  sensorID,
+		Metric:    rule.Metric,
+		Value:     value,
+		Threshold: rule.Value,
+		StartedAt: s.since,
+		At:        now,
+	}
+	if reading != nil {
+		event.Reading = ReadingFrom(reading)
+	}
+
+	select {
+	case e.events <- event:
+	default:
+		e.hot.Warn("Alert event queue full, deferring transition", "rule", event.Rule, "state", event.State, logging.KeyZone, event.Zone)
+		if state == StateFiring {
+			s.firing = false
+		} else {
+			s.active, s.firing = true, true
+		}
+		return
+	}
+
+	if state == StateFiring {
+		metrics.AlertsFiring.WithLabelValues(event.Rule, event.Zone).Inc()
+	} else {
+		metrics.AlertsFiring.WithLabelValues(event.Rule, event.Zone).Dec()
+	}
+}
+
+// How long state outlives the last reading of its rule
+func (e *Engine) staleAfter(rule int) time.Duration {
+	r := &e.rules[rule]
+	return max(r.Window, r.For, minIdle)
+}
+
+// Evicts state of sensors and zones that are resolved and have not been
+// seen for longer than their rule's window, so that state for sensors that
+// went away does not accumulate. Zones whose breaching sensors all went
+// silent are resolved first.
+func (e *Engine) prune(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	idle := func(rule int, s *status) bool {
+		return !s.active && !s.firing && now.Sub(s.seen) > e.staleAfter(rule)
+	}
+
+	for k, s := range e.sensors {
+		if idle(k.rule, &s.status) {
+			delete(e.sensors, k)
+		}
+	}
+	for k, z := range e.zones {
+		z.expire(now, e.staleAfter(k.rule))
+		if len(z.breaching) == 0 && z.active {
+			rule := &e.rules[k.rule]
+			if transition := z.update(false, now, rule.For); transition != "" {
+				e.emit(rule, transition, k.key, z.cleared.sensorID, z.cleared.value, &z.status, nil, now)
+			}
+		}
+		if len(z.breaching) == 0 && idle(k.rule, &z.status) {
+			delete(e.zones, k)
+		}
+	}
+}
+
+func (e *Engine) publish(ctx context.Context, event Event) {
+	if err := Publish(ctx, e.js, event); err != nil {
+		e.log.Error("Failed to publish alert", "rule", event.Rule, "state", event.State, logging.KeyZone, event.Zone, logging.Err(err))
+		return
+	}
+	metrics.AlertEvents.WithLabelValues(event.Rule, string(event.State)).Inc()
+}
+
+// Publish sends an event to alerts.<zone>.<rule>. The message id makes
+// retried publishes of the same transition idempotent.
+func Publish(ctx context.Context, js jetstream.JetStream, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	msgID := fmt.Sprintf("%s-%s-%s-%d", event.Rule, event.Zone, event.SensorID, event.At.UnixNano())
+	_, err = js.Publish(ctx, event.Subject(), data, jetstream.WithMsgID(msgID))
+	return err
+}
+
+// Replaces characters that are not allowed in a subject token
+func subjectToken(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,189 @@
+package alerting
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/natstest"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func newTestEngine(t *testing.T, js jetstream.JetStream, rules ...Rule) *Engine {
+	t.Helper()
+
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, err := NewEngine(context.Background(), js, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func testReading(start time.Time, sensorID string, at time.Duration, co float64) *pb.SensorReading {
+	return &pb.SensorReading{
+		SensorId:   sensorID,
+		SensorZone: "office",
+		Timestamp:  start.Add(at).UnixMilli(),
+		CoLevel:    co,
+	}
+}
+
+// The parts of a queued event the tests check
+type transition struct {
+	state  State
+	sensor string
+	value  float64
+}
+
+// Returns the events queued so far
+func drain(e *Engine) []transition {
+	var events []transition
+	for {
+		select {
+		case event := <-e.events:
+			events = append(events, transition{event.State, event.SensorID, event.Value})
+		default:
+			return events
+		}
+	}
+}
+
+type step struct {
+	sensor string
+	at     time.Duration
+	co     float64
+	want   transition // Event the reading causes, if any
+}
+
+func TestRuleStateMachine(t *testing.T) {
+	threshold := Rule{Name: "co-high", Metric: "co_level", Op: ">=", Value: 30}
+	held := threshold
+	held.For = 10 * time.Second
+	rise := Rule{Name: "co-rise", Metric: "co_level", Type: RuleRateOfRise, Op: ">", Value: 10, Window: time.Minute}
+	zone := threshold
+	zone.Scope = ScopeZone
+	heldZone := held
+	heldZone.Scope = ScopeZone
+
+	tests := []struct {
+		name  string
+		rule  Rule
+		steps []step
+	}{
+		{"fires and resolves", threshold, []step{
+			{"s1", 0, 10, transition{}},
+			{"s1", time.Second, 30, transition{StateFiring, "s1", 30}},
+			{"s1", 2 * time.Second, 40, transition{}},
+			{"s1", 3 * time.Second, 29, transition{StateResolved, "s1", 29}},
+			{"s1", 4 * time.Second, 29, transition{}},
+		}},
+		{"sensors tracked apart", threshold, []step{
+			{"s1", 0, 40, transition{StateFiring, "s1", 40}},
+			{"s2", time.Second, 45, transition{StateFiring, "s2", 45}},
+			{"s1", 2 * time.Second, 0, transition{StateResolved, "s1", 0}},
+		}},
+		{"fires once held for long enough", held, []step{
+			{"s1", 0, 40, transition{}},
+			{"s1", 5 * time.Second, 40, transition{}},
+			{"s1", 10 * time.Second, 41, transition{StateFiring, "s1", 41}},
+			{"s1", 15 * time.Second, 40, transition{}},
+		}},
+		{"hold restarts when the condition breaks", held, []step{
+			{"s1", 0, 40, transition{}},
+			{"s1", 5 * time.Second, 0, transition{}},
+			{"s1", 10 * time.Second, 40, transition{}},
+			{"s1", 15 * time.Second, 40, transition{}},
+			{"s1", 20 * time.Second, 40, transition{StateFiring, "s1", 40}},
+		}},
+		{"rate of rise within the window", rise, []step{
+			{"s1", 0, 10, transition{}},
+			{"s1", 30 * time.Second, 15, transition{}},
+			{"s1", 50 * time.Second, 21, transition{StateFiring, "s1", 11}},
+			// 10 at 0s left the window, the rise from 15 is 7
+			{"s1", 90 * time.Second, 22, transition{StateResolved, "s1", 7}},
+		}},
+		{"zone fires while any sensor breaches", zone, []step{
+			{"s1", 0, 40, transition{StateFiring, "s1", 40}},
+			{"s2", time.Second, 45, transition{}},
+			{"s1", 2 * time.Second, 0, transition{}},
+			{"s2", 3 * time.Second, 5, transition{StateResolved, "s2", 5}},
+		}},
+		{"zone fires on a breaching sensor", heldZone, []step{
+			{"s1", 0, 40, transition{}},
+			{"s2", 5 * time.Second, 10, transition{}},
+			{"s2", 10 * time.Second, 10, transition{StateFiring, "s1", 40}},
+			{"s1", 11 * time.Second, 20, transition{StateResolved, "s1", 20}},
+		}},
+		{"zone forgets a silent breaching sensor", zone, []step{
+			{"s1", 0, 40, transition{StateFiring, "s1", 40}},
+			{"s2", time.Minute, 0, transition{}},
+			{"s2", minIdle + time.Second, 0, transition{StateResolved, "s1", 40}},
+		}},
+	}
+
+	js := natstest.Run(t).JetStream()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEngine(t, js, tt.rule)
+			start := time.Now()
+			for i, s := range tt.steps {
+				e.Observe(testReading(start, s.sensor, s.at, s.co))
+
+				var want []transition
+				if s.want.state != "" {
+					want = []transition{s.want}
+				}
+				if got := drain(e); !slices.Equal(got, want) {
+					t.Fatalf("step %d (%s at %s = %g): events %v, want %v", i, s.sensor, s.at, s.co, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPruneResolvesSilentZone(t *testing.T) {
+	e := newTestEngine(t, natstest.Run(t).JetStream(), Rule{Name: "co-high", Metric: "co_level", Value: 30, Scope: ScopeZone})
+	start := time.Now()
+
+	e.Observe(testReading(start, "s1", 0, 40))
+	if got, want := drain(e), []transition{{StateFiring, "s1", 40}}; !slices.Equal(got, want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+
+	e.prune(start.Add(time.Minute))
+	if got := drain(e); len(got) != 0 {
+		t.Fatalf("pruned inside the staleness window: events %v", got)
+	}
+
+	e.prune(start.Add(minIdle + time.Second))
+	if got, want := drain(e), []transition{{StateResolved, "s1", 40}}; !slices.Equal(got, want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+
+	e.prune(start.Add(2*minIdle + 2*time.Second))
+	if len(e.zones) != 0 || len(e.sensors) != 0 {
+		t.Fatalf("%d zones and %d sensors left after going idle", len(e.zones), len(e.sensors))
+	}
+}
+
+func TestFullQueueDefersTransition(t *testing.T) {
+	e := newTestEngine(t, natstest.Run(t).JetStream(), Rule{Name: "co-high", Metric: "co_level", Value: 30})
+	start := time.Now()
+
+	// Nothing can be queued until the queue is swapped for one with room
+	e.events = make(chan Event)
+	e.Observe(testReading(start, "s1", 0, 40))
+	e.events = make(chan Event, 1)
+	e.Observe(testReading(start, "s1", time.Second, 45))
+
+	if got, want := drain(e), []transition{{StateFiring, "s1", 45}}; !slices.Equal(got, want) {
+		t.Fatalf("events %v, want the deferred %v", got, want)
+	}
+}
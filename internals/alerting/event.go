@@ -0,0 +1,69 @@
+package alerting
+
+import (
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+)
+
+type State string
+
+const (
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// What raised the event
+const (
+	KindRule    = "rule"
+	KindOffline = "sensor_offline"
+	KindAnomaly = "anomaly"
+)
+
+// Event is published to NATS whenever an alert changes state
+type Event struct {
+	Kind      string  `json:"kind"`
+	Rule      string  `json:"rule"`
+	Severity  string  `json:"severity"`
+	State     State   `json:"state"`
+	Scope     string  `json:"scope"`
+	Zone      string  `json:"zone"`
+	SensorID  string  `json:"sensor_id,omitempty"`
+	Metric    string  `json:"metric,omitempty"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	// Set on anomaly events: how far Value is from the sensor's baseline
+	ZScore    float64   `json:"z_score,omitempty"`
+	Baseline  float64   `json:"baseline,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	At        time.Time `json:"at"`
+
+	// The reading that caused the transition
+	Reading *Reading `json:"reading,omitempty"`
+}
+
+type Reading struct {
+	SensorID     string    `json:"sensor_id"`
+	Zone         string    `json:"zone"`
+	Time         time.Time `json:"time"`
+	Temperature  float64   `json:"temperature"`
+	Humidity     float64   `json:"humidity"`
+	CoLevel      float64   `json:"co_level"`
+	BatteryLevel float64   `json:"battery_level"`
+}
+
+func ReadingFrom(r *pb.SensorReading) *Reading {
+	return &Reading{
+		SensorID:     r.SensorId,
+		Zone:         r.SensorZone,
+		Time:         r.ReadingTime(),
+		Temperature:  r.Temperature,
+		Humidity:     r.Humidity,
+		CoLevel:      r.CoLevel,
+		BatteryLevel: r.BatteryLevel,
+	}
+}
+
+func (e Event) Subject() string {
+	return SubjectPrefix + subjectToken(e.Zone) + "." + subjectToken(e.Rule)
+}
@@ -0,0 +1,141 @@
+package alerting
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/spf13/viper"
+)
+
+const (
+	RuleThreshold  = "threshold"
+	RuleRateOfRise = "rate_of_rise"
+
+	ScopeSensor = "sensor"
+	ScopeZone   = "zone"
+)
+
+// Rule is a declarative alert condition loaded from the rules file.
+//
+//	rules:
+//	  - name: co_danger
+//	    metric: co_level
+//	    type: threshold
+//	    op: ">="
+//	    value: 50
+//	    for: 10s
+//	    scope: zone
+type Rule struct {
+	Name     string `mapstructure:"name"`
+	Severity string `mapstructure:"severity"`
+
+	Metric string `mapstructure:"metric"` // temperature, humidity, co_level or battery_level
+	// threshold compares the reading against Value.
+	// rate_of_rise compares the change over Window against Value.
+	Type   string        `mapstructure:"type"`
+	Op     string        `mapstructure:"op"` // >, >=, < or <=
+	Value  float64       `mapstructure:"value"`
+	Window time.Duration `mapstructure:"window"`
+
+	// How long the condition must hold before the alert fires
+	For time.Duration `mapstructure:"for"`
+
+	// sensor tracks state per sensor. zone fires once for the whole zone
+	// while any of its sensors meets the condition.
+	Scope   string   `mapstructure:"scope"`
+	Zones   []string `mapstructure:"zones"`   // Only evaluate these zones, all when empty
+	Sensors []string `mapstructure:"sensors"` // Only evaluate these sensors, all when empty
+}
+
+// Loads alert rules from a YAML file
+func LoadRules(path string) ([]Rule, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := v.UnmarshalKey("rules", &rules); err != nil {
+		return nil, err
+	}
+
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("rule %d (%q): %w", i, rules[i].Name, err)
+		}
+	}
+
+	return rules, nil
+}
+
+func (r *Rule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	if _, ok := pb.MetricValue(nil, r.Metric); !ok {
+		return fmt.Errorf("unknown metric %q", r.Metric)
+	}
+
+	switch r.Type {
+	case "":
+		r.Type = RuleThreshold
+	case RuleThreshold:
+	case RuleRateOfRise:
+		if r.Window <= 0 {
+			return fmt.Errorf("rate_of_rise requires a window")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", r.Type)
+	}
+
+	switch r.Op {
+	case ">", ">=", "<", "<=":
+	case "":
+		r.Op = ">="
+	default:
+		return fmt.Errorf("unknown op %q", r.Op)
+	}
+
+	switch r.Scope {
+	case "":
+		r.Scope = ScopeSensor
+	case ScopeSensor, ScopeZone:
+	default:
+		return fmt.Errorf("unknown scope %q", r.Scope)
+	}
+
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+
+	return nil
+}
+
+func (r *Rule) matches(reading *pb.SensorReading) bool {
+	if len(r.Zones) > 0 && !slices.Contains(r.Zones, reading.SensorZone) {
+		return false
+	}
+	if len(r.Sensors) > 0 && !slices.Contains(r.Sensors, reading.SensorId) {
+		return false
+	}
+	return true
+}
+
+func (r *Rule) compare(v float64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.Value
+	case ">=":
+		return v >= r.Value
+	case "<":
+		return v < r.Value
+	case "<=":
+		return v <= r.Value
+	}
+	return false
+}
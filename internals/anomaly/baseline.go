@@ -0,0 +1,35 @@
+package anomaly
+
+import "math"
+
+// Baseline is an exponentially weighted mean and variance of one metric of
+// one sensor
+type Baseline struct {
+	Mean     float64
+	Variance float64
+	Samples  int64
+}
+
+// Returns how many standard deviations v is from the mean. A flat baseline
+// has no spread to compare against and always scores 0.
+func (b *Baseline) ZScore(v float64) float64 {
+	if b.Variance <= 0 {
+		return 0
+	}
+	return (v - b.Mean) / math.Sqrt(b.Variance)
+}
+
+// Folds v into the baseline with weight alpha
+func (b *Baseline) Update(v, alpha float64) {
+	b.Samples++
+	if b.Samples == 1 {
+		b.Mean = v
+		b.Variance = 0
+		return
+	}
+
+	diff := v - b.Mean
+	incr := alpha * diff
+	b.Mean += incr
+	b.Variance = (1 - alpha) * (b.Variance + diff*incr)
+}
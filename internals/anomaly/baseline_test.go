@@ -0,0 +1,64 @@
+package anomaly
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBaselineUpdate(t *testing.T) {
+	tests := []struct {
+		name         string
+		alpha        float64
+		values       []float64
+		wantMean     float64
+		wantVariance float64
+	}{
+		{"first value seeds the mean", 0.5, []float64{10}, 10, 0},
+		// diff 10, incr 5: mean 15, variance 0.5 * (0 + 10*5)
+		{"second value", 0.5, []float64{10, 20}, 15, 25},
+		// diff 0: only the variance decays, 0.5 * 25
+		{"value at the mean", 0.5, []float64{10, 20, 15}, 15, 12.5},
+		// diff -10, incr -5: mean 10, variance 0.5 * (12.5 + 50)
+		{"value below the mean", 0.5, []float64{10, 20, 15, 5}, 10, 31.25},
+		// diff 10, incr 1: mean 11, variance 0.9 * (0 + 10*1)
+		{"small alpha", 0.1, []float64{10, 20}, 11, 9},
+		{"constant values stay flat", 0.1, []float64{7, 7, 7, 7}, 7, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Baseline
+			for _, v := range tt.values {
+				b.Update(v, tt.alpha)
+			}
+			if math.Abs(b.Mean-tt.wantMean) > 1e-9 || math.Abs(b.Variance-tt.wantVariance) > 1e-9 {
+				t.Fatalf("mean %g, variance %g, want %g, %g", b.Mean, b.Variance, tt.wantMean, tt.wantVariance)
+			}
+			if b.Samples != int64(len(tt.values)) {
+				t.Fatalf("samples %d, want %d", b.Samples, len(tt.values))
+			}
+		})
+	}
+}
+
+func TestBaselineZScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseline Baseline
+		value    float64
+		want     float64
+	}{
+		{"at the mean", Baseline{Mean: 15, Variance: 25}, 15, 0},
+		{"two deviations above", Baseline{Mean: 15, Variance: 25}, 25, 2},
+		{"two deviations below", Baseline{Mean: 15, Variance: 25}, 5, -2},
+		{"flat baseline", Baseline{Mean: 15}, 100, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.baseline.ZScore(tt.value); math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("ZScore(%g) = %g, want %g", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package anomaly
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/alerting"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/metrics"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type Options struct {
+	// Metrics to track, by their alert rule names e.g. co_level
+	Metrics []string
+	// A reading is anomalous once |z| exceeds this
+	ZLimit float64
+	// Weight of the newest reading in the EWMA mean and variance
+	Alpha float64
+	// Readings a baseline needs before it is used to flag anomalies
+	Warmup int
+	// How often changed baselines are written to Postgres
+	CheckpointInterval time.Duration
+}
+
+// Detector keeps a rolling baseline for each metric of each sensor and
+// raises an anomaly event when a reading strays too far from it. Since zones
+// differ in their base temperature and humidity, every sensor is judged
+// against its own history rather than a fixed threshold.
+// It is safe for concurrent use by the processor workers.
+type Detector struct {
+	js   jetstream.JetStream
+	pool *pgxpool.Pool
+	opts Options
+	log  *slog.Logger
+	// Sampled, for messages logged per reading
+	hot *slog.Logger
+
+	mu     sync.Mutex
+	series map[key]*series
+
+	events chan alerting.Event
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+type key struct {
+	sensorID string
+	metric   string
+}
+
+type series struct {
+	Baseline
+	zone      string
+	anomalous bool
+	since     time.Time
+	dirty     bool // Changed since the last checkpoint
+}
+
+// Creates a detector, restoring baselines checkpointed by a previous run
+func NewDetector(ctx context.Context, js jetstream.JetStream, pool *pgxpool.Pool, opts Options) (*Detector, error) {
+	if err := alerting.CreateStream(ctx, js); err != nil {
+		return nil, err
+	}
+
+	restored, err := load(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	return newDetector(js, pool, opts, restored), nil
+}
+
+func newDetector(js jetstream.JetStream, pool *pgxpool.Pool, opts Options, restored map[key]*series) *Detector {
+	if opts.ZLimit <= 0 {
+		opts.ZLimit = 4
+	}
+	if opts.Alpha <= 0 || opts.Alpha >= 1 {
+		opts.Alpha = 0.05
+	}
+	if opts.CheckpointInterval <= 0 {
+		opts.CheckpointInterval = 30 * time.Second
+	}
+
+	logger := logging.Component("anomaly")
+	return &Detector{
+		js:     js,
+		pool:   pool,
+		opts:   opts,
+		log:    logger,
+		hot:    logging.Sampled(logger),
+		series: restored,
+		events: make(chan alerting.Event, 1024),
+		done:   make(chan struct{}),
+	}
+}
+
+// Observe scores the reading against each metric's baseline before folding
+// it in. Events carry the time the reading was taken.
+func (d *Detector) Observe(reading *pb.SensorReading) {
+	now := reading.ReadingTime()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, metric := range d.opts.Metrics {
+		value, ok := pb.MetricValue(reading, metric)
+		if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+
+		k := key{sensorID: reading.SensorId, metric: metric}
+		s, ok := d.series[k]
+		if !ok {
+			s = &series{}
+			d.series[k] = s
+		}
+		s.zone = reading.SensorZone
+
+		if s.Samples >= int64(d.opts.Warmup) {
+			d.score(k, s, reading, value, now)
+		}
+
+		s.Update(value, d.opts.Alpha)
+		s.dirty = true
+	}
+}
+
+// Raises or resolves the anomaly for one metric. Must be called with the
+// lock held, before value is folded into the baseline.
+//
+// The transition is only applied once its event is queued. If the queue is
+// full the series keeps its state, so the next reading retries and what is
+// published always matches the state held here.
+func (d *Detector) score(k key, s *series, reading *pb.SensorReading, value float64, now time.Time) {
+	z := s.ZScore(value)
+	anomalous := math.Abs(z) > d.opts.ZLimit
+
+	if anomalous {
+		metrics.Anomalies.WithLabelValues(s.zone, k.metric).Inc()
+	}
+	if anomalous == s.anomalous {
+		return
+	}
+
+	state, since := alerting.StateResolved, s.since
+	if anomalous {
+		state, since = alerting.StateFiring, now
+	}
+
+	event := alerting.Event{
+		Kind:      alerting.KindAnomaly,
+		Rule:      alerting.KindAnomaly + "_" + k.metric,
+		Severity:  "warning",
+		State:     state,
+		Scope:     alerting.ScopeSensor,
+		Zone:      s.zone,
+		SensorID:  k.sensorID,
+		Metric:    k.metric,
+		Value:     value,
+		Threshold: d.opts.ZLimit,
+		ZScore:    z,
+		Baseline:  s.Mean,
+		StartedAt: since,
+		At:        now,
+		Reading:   alerting.ReadingFrom(reading),
+	}
+
+	select {
+	case d.events <- event:
+		s.anomalous, s.since = anomalous, since
+	default:
+		d.hot.Warn("Anomaly event queue full, transition retried on the next reading", "state", state, logging.KeySensor, k.sensorID)
+	}
+}
+
+// Start publishes anomaly events and periodically checkpoints baselines
+func (d *Detector) Start(ctx context.Context) {
+	d.wg.Add(2)
+
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(d.opts.CheckpointInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.checkpoint(ctx)
+			case <-d.done:
+				// Final checkpoint must not be cut short by the app context
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+				d.checkpoint(ctx)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer d.wg.Done()
+		for {
+			select {
+			case event := <-d.events:
+				d.publish(ctx, event)
+			case <-d.done:
+				for {
+					select {
+					case event := <-d.events:
+						d.publish(ctx, event)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stop publishes pending events and writes a final checkpoint.
+// The pool must still be open.
+func (d *Detector) Stop() {
+	close(d.done)
+	d.wg.Wait()
+}
+
+func (d *Detector) checkpoint(ctx context.Context) {
+	d.mu.Lock()
+	pending := []checkpoint{}
+	for k, s := range d.series {
+		if s.dirty {
+			pending = append(pending, checkpoint{key: k, zone: s.zone, Baseline: s.Baseline})
+			s.dirty = false
+		}
+	}
+	d.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	if err := save(ctx, d.pool, pending, time.Now()); err != nil {
+		d.log.Error("Failed to checkpoint anomaly baselines", "baselines", len(pending), logging.Err(err))
+
+		// Retry on the next tick
+		d.mu.Lock()
+		for _, c := range pending {
+			if s, ok := d.series[c.key]; ok {
+				s.dirty = true
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *Detector) publish(ctx context.Context, event alerting.Event) {
+	if err := alerting.Publish(ctx, d.js, event); err != nil {
+		d.log.Error("Failed to publish anomaly event", "rule", event.Rule, "state", event.State, logging.KeySensor, event.SensorID, logging.Err(err))
+		return
+	}
+	metrics.AlertEvents.WithLabelValues(event.Rule, string(event.State)).Inc()
+}
@@ -0,0 +1,66 @@
+package anomaly
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/alerting"
+)
+
+func TestDetectorTransitions(t *testing.T) {
+	d := newDetector(nil, nil, Options{Metrics: []string{"co_level"}, ZLimit: 2, Alpha: 0.5, Warmup: 3}, map[key]*series{})
+	start := time.Now()
+
+	type want struct {
+		state    alerting.State
+		zScore   float64
+		baseline float64
+		started  time.Duration
+	}
+	tests := []struct {
+		co   float64
+		want *want
+	}{
+		{10, nil},
+		// Mean 15, variance 25 after this
+		{20, nil},
+		// z would be 3, but the baseline is still warming up. Mean 22.5,
+		// variance 0.5 * (25 + 15*7.5) = 68.75 after this
+		{30, nil},
+		// At the mean. Variance halves to 34.375
+		{22.5, nil},
+		// z = 17.5 / sqrt(34.375). Mean 31.25, variance
+		// 0.5 * (34.375 + 17.5*8.75) = 93.75 after this
+		{40, &want{alerting.StateFiring, 17.5 / math.Sqrt(34.375), 22.5, 4 * time.Second}},
+		// z = 28.75 / sqrt(93.75), still anomalous so no new event. Mean
+		// 31.25 + 0.5*28.75 after this
+		{60, nil},
+		{45.625, &want{state: alerting.StateResolved, started: 4 * time.Second}},
+	}
+
+	for i, tt := range tests {
+		at := start.Add(time.Duration(i) * time.Second)
+		d.Observe(&pb.SensorReading{SensorId: "s1", SensorZone: "office", Timestamp: at.UnixMilli(), CoLevel: tt.co})
+
+		select {
+		case event := <-d.events:
+			if tt.want == nil {
+				t.Fatalf("reading %d (%g): unexpected %s event, z %g", i, tt.co, event.State, event.ZScore)
+			}
+			if event.State != tt.want.state || event.SensorID != "s1" || event.Value != tt.co ||
+				event.StartedAt.UnixMilli() != start.Add(tt.want.started).UnixMilli() {
+				t.Fatalf("reading %d: %s for %q value %g started %s", i, event.State, event.SensorID, event.Value, event.StartedAt)
+			}
+			if tt.want.state == alerting.StateFiring &&
+				(math.Abs(event.ZScore-tt.want.zScore) > 1e-9 || math.Abs(event.Baseline-tt.want.baseline) > 1e-9) {
+				t.Fatalf("reading %d: z %g against %g, want %g against %g", i, event.ZScore, event.Baseline, tt.want.zScore, tt.want.baseline)
+			}
+		default:
+			if tt.want != nil {
+				t.Fatalf("reading %d (%g): no event, want %s", i, tt.co, tt.want.state)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package anomaly
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	loadBaselinesSQL = `SELECT sensor_id, metric, zone, mean, variance, samples FROM sensor_baselines`
+
+	saveBaselineSQL = `INSERT INTO sensor_baselines (sensor_id, metric, zone, mean, variance, samples, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (sensor_id, metric) DO UPDATE SET
+			zone = EXCLUDED.zone,
+			mean = EXCLUDED.mean,
+			variance = EXCLUDED.variance,
+			samples = EXCLUDED.samples,
+			updated_at = EXCLUDED.updated_at`
+)
+
+// Reads every checkpointed baseline
+func load(ctx context.Context, pool *pgxpool.Pool) (map[key]*series, error) {
+	rows, err := pool.Query(ctx, loadBaselinesSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[key]*series)
+	for rows.Next() {
+		var (
+			k key
+			s series
+		)
+		if err := rows.Scan(&k.sensorID, &k.metric, &s.zone, &s.Mean, &s.Variance, &s.Samples); err != nil {
+			return nil, err
+		}
+		out[k] = &s
+	}
+	return out, rows.Err()
+}
+
+type checkpoint struct {
+	key
+	zone string
+	Baseline
+}
+
+// Upserts baselines in a single round trip
+func save(ctx context.Context, pool *pgxpool.Pool, checkpoints []checkpoint, at time.Time) error {
+	batch := &pgx.Batch{}
+	for _, c := range checkpoints {
+		batch.Queue(saveBaselineSQL, c.sensorID, c.metric, c.zone, c.Mean, c.Variance, c.Samples, at)
+	}
+	return pool.SendBatch(ctx, batch).Close()
+}
@@ -0,0 +1,278 @@
+package battery
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/metrics"
+)
+
+const (
+	// A rise of more than this many points between two readings is taken
+	// as a battery replacement. Older readings are not fitted.
+	replacementJump = 5.0
+
+	// Drain rates are fitted with a least squares line through hourly
+	// averages over the window, starting at the most recent battery
+	// replacement. Readings are averaged first so the window functions and
+	// the fit run over a few points per sensor rather than every reading.
+	// The window is read through sensor_readings_time_idx.
+	drainRatesSQL = `
+		WITH hourly AS (
+			SELECT sensor_id,
+				date_bin('1 hour', time, $1) AS bucket,
+				(array_agg(zone ORDER BY time DESC))[1] AS zone,
+				(array_agg(battery_level ORDER BY time DESC))[1] AS last_level,
+				avg(battery_level) AS battery_level,
+				max(time) AS last_seen,
+				count(*) AS readings
+			FROM sensor_readings
+			WHERE time >= $1
+			GROUP BY sensor_id, bucket
+		),
+		r AS (
+			SELECT *,
+				battery_level - lag(battery_level) OVER (PARTITION BY sensor_id ORDER BY bucket) AS delta
+			FROM hourly
+		),
+		swaps AS (
+			SELECT sensor_id, max(bucket) AS swapped_at FROM r
+			WHERE delta > $2
+			GROUP BY sensor_id
+		)
+		SELECT r.sensor_id,
+			(array_agg(r.zone ORDER BY r.bucket DESC))[1],
+			(array_agg(r.last_level ORDER BY r.bucket DESC))[1],
+			max(r.last_seen),
+			sum(r.readings)::bigint,
+			coalesce(regr_slope(r.battery_level, extract(epoch FROM r.bucket)), 0)
+		FROM r
+		LEFT JOIN swaps USING (sensor_id)
+		WHERE swaps.swapped_at IS NULL OR r.bucket >= swaps.swapped_at
+		GROUP BY r.sensor_id
+		HAVING sum(r.readings) >= $3`
+)
+
+type Options struct {
+	// How often drain rates are refitted
+	Interval time.Duration
+	// How much history drain rates are fitted over
+	Window time.Duration
+	// Sensors with fewer readings in the window are not forecast
+	MinSamples int
+	// Statement timeout of the fit, so a slow refresh cannot hold a
+	// connection indefinitely
+	Timeout time.Duration
+}
+
+// Forecast is the estimated time-to-empty of one sensor's battery
+type Forecast struct {
+	SensorID     string    `json:"sensor_id"`
+	Zone         string    `json:"zone"`
+	BatteryLevel float64   `json:"battery_level"`
+	LastSeen     time.Time `json:"last_seen"`
+	Samples      int64     `json:"samples"`
+	// Percentage points lost per hour. Zero or negative when not draining.
+	DrainPerHour float64 `json:"drain_per_hour"`
+	// Unset when the battery is not draining
+	EmptyAt  *time.Time `json:"empty_at,omitempty"`
+	ETAHours *float64   `json:"eta_hours,omitempty"`
+}
+
+// Forecaster periodically fits per-sensor battery drain rates from
+// sensor_readings and keeps the latest forecasts in memory
+type Forecaster struct {
+	pool *pgxpool.Pool
+	opts Options
+	log  *slog.Logger
+
+	mu        sync.RWMutex
+	forecasts map[string]Forecast
+	updatedAt time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewForecaster(pool *pgxpool.Pool, opts Options) *Forecaster {
+	if opts.Interval <= 0 {
+		opts.Interval = 15 * time.Minute
+	}
+	if opts.Window <= 0 {
+		opts.Window = 72 * time.Hour
+	}
+	if opts.MinSamples < 2 {
+		opts.MinSamples = 10
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Minute
+	}
+
+	return &Forecaster{
+		pool:      pool,
+		opts:      opts,
+		log:       logging.Component("battery"),
+		forecasts: make(map[string]Forecast),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start runs the forecast job immediately and then every Interval
+func (f *Forecaster) Start(ctx context.Context) {
+	f.wg.Add(1)
+
+	go func() {
+		defer f.wg.Done()
+		ticker := time.NewTicker(f.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			if err := f.Refresh(ctx); err != nil {
+				f.log.Error("Battery forecast failed", logging.Err(err))
+			}
+
+			select {
+			case <-ticker.C:
+			case <-f.done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (f *Forecaster) Stop() {
+	close(f.done)
+	f.wg.Wait()
+}
+
+// Refresh refits every sensor's drain rate and updates the ETA gauge
+func (f *Forecaster) Refresh(ctx context.Context) error {
+	now := time.Now()
+
+	tx, err := f.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	timeout := strconv.FormatInt(f.opts.Timeout.Milliseconds(), 10)
+	if _, err := tx.Exec(ctx, "SELECT set_config('statement_timeout', $1, true)", timeout); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx, drainRatesSQL, now.Add(-f.opts.Window), replacementJump, f.opts.MinSamples)
+	if err != nil {
+		return err
+	}
+
+	forecasts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Forecast, error) {
+		var (
+			fc          Forecast
+			slopePerSec float64
+		)
+		err := row.Scan(&fc.SensorID, &fc.Zone, &fc.BatteryLevel, &fc.LastSeen, &fc.Samples, &slopePerSec)
+		fc.DrainPerHour = -slopePerSec * 3600
+		return fc, err
+	})
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]Forecast, len(forecasts))
+	for _, fc := range forecasts {
+		if fc.DrainPerHour > 0 {
+			emptyAt := fc.LastSeen.Add(time.Duration(fc.BatteryLevel / fc.DrainPerHour * float64(time.Hour)))
+			eta := max(emptyAt.Sub(now).Hours(), 0)
+			fc.EmptyAt, fc.ETAHours = &emptyAt, &eta
+		}
+		byID[fc.SensorID] = fc
+	}
+
+	// Only replaced once the query succeeded, so a failed refresh keeps the
+	// previous ETAs
+	metrics.BatteryETA.Reset()
+	for _, fc := range byID {
+		if fc.ETAHours != nil {
+			metrics.BatteryETA.WithLabelValues(fc.Zone, fc.SensorID).Set(*fc.ETAHours)
+		}
+	}
+
+	f.mu.Lock()
+	f.forecasts = byID
+	f.updatedAt = now
+	f.mu.Unlock()
+	return nil
+}
+
+// Forecasts returns the latest forecasts, soonest to die first. Sensors that
+// are not draining come last.
+func (f *Forecaster) Forecasts(zone string) []Forecast {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	out := []Forecast{}
+	for _, fc := range f.forecasts {
+		if zone == "" || fc.Zone == zone {
+			out = append(out, fc)
+		}
+	}
+	sortByETA(out)
+	return out
+}
+
+// Forecast returns the latest forecast of one sensor
+func (f *Forecaster) Forecast(sensorID string) (Forecast, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	fc, ok := f.forecasts[sensorID]
+	return fc, ok
+}
+
+// ZoneReplacements lists the sensors of one zone that need a new battery
+type ZoneReplacements struct {
+	Zone    string     `json:"zone"`
+	Sensors []Forecast `json:"sensors"`
+}
+
+// Replacements ranks, per zone, the sensors expected to run empty within
+// the horizon, soonest first
+func (f *Forecaster) Replacements(zone string, within time.Duration) []ZoneReplacements {
+	byZone := map[string][]Forecast{}
+	for _, fc := range f.Forecasts(zone) {
+		if fc.ETAHours != nil && *fc.ETAHours <= within.Hours() {
+			byZone[fc.Zone] = append(byZone[fc.Zone], fc)
+		}
+	}
+
+	out := []ZoneReplacements{}
+	for z, sensors := range byZone {
+		out = append(out, ZoneReplacements{Zone: z, Sensors: sensors})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Zone < out[j].Zone })
+	return out
+}
+
+func sortByETA(forecasts []Forecast) {
+	sort.Slice(forecasts, func(i, j int) bool {
+		a, b := forecasts[i].ETAHours, forecasts[j].ETAHours
+		switch {
+		case a != nil && b != nil && *a != *b:
+			return *a < *b
+		case a != nil && b == nil:
+			return true
+		case a == nil && b != nil:
+			return false
+		}
+		return forecasts[i].SensorID < forecasts[j].SensorID
+	})
+}
@@ -0,0 +1,53 @@
+package battery
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/knightfall22/Phylax/internals/httpx"
+)
+
+// Default horizon of the replacement list
+const defaultHorizon = 7 * 24 * time.Hour
+
+// RegisterRoutes exposes the battery forecasts:
+//
+//	GET /api/v1/battery/forecasts?zone=               every sensor, soonest to die first
+//	GET /api/v1/sensors/{id}/battery                  forecast of one sensor
+//	GET /api/v1/battery/replacements?zone=&within=    sensors to replace per zone
+//
+// within is a duration such as 72h and defaults to a week.
+func (f *Forecaster) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/battery/forecasts", f.handleForecasts)
+	mux.HandleFunc("GET /api/v1/sensors/{id}/battery", f.handleSensor)
+	mux.HandleFunc("GET /api/v1/battery/replacements", f.handleReplacements)
+}
+
+func (f *Forecaster) handleForecasts(w http.ResponseWriter, r *http.Request) {
+	httpx.WriteJSON(w, http.StatusOK, f.Forecasts(r.URL.Query().Get("zone")))
+}
+
+func (f *Forecaster) handleSensor(w http.ResponseWriter, r *http.Request) {
+	fc, ok := f.Forecast(r.PathValue("id"))
+	if !ok {
+		httpx.WriteError(w, http.StatusNotFound, fmt.Errorf("no battery forecast for sensor %q", r.PathValue("id")))
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, fc)
+}
+
+func (f *Forecaster) handleReplacements(w http.ResponseWriter, r *http.Request) {
+	within := defaultHorizon
+	if raw := r.URL.Query().Get("within"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			httpx.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid within %q", raw))
+			return
+		}
+		within = d
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, f.Replacements(r.URL.Query().Get("zone"), within))
+}
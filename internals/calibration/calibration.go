@@ -0,0 +1,288 @@
+package calibration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/logging"
+)
+
+// Postgres channel notified by the sensor_calibrations trigger
+const notifyChannel = "sensor_calibrations"
+
+// Wait before listening again after losing the connection
+const relistenDelay = 5 * time.Second
+
+var (
+	ErrNotFound = errors.New("calibration not found")
+	ErrInvalid  = errors.New("invalid calibration")
+)
+
+const profileColumns = `sensor_id, metric, gain, "offset", effective_from, created_at`
+
+// Profile corrects one metric of one sensor from EffectiveFrom onwards:
+//
+//	calibrated = raw * Gain + Offset
+type Profile struct {
+	SensorID      string    `json:"sensor_id"`
+	Metric        string    `json:"metric"`
+	Gain          float64   `json:"gain"`
+	Offset        float64   `json:"offset"`
+	EffectiveFrom time.Time `json:"effective_from"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Calibrator applies calibration profiles to readings before they are
+// persisted. Profiles are cached in memory and reloaded whenever Postgres
+// notifies that sensor_calibrations changed.
+type Calibrator struct {
+	pool *pgxpool.Pool
+	log  *slog.Logger
+
+	mu sync.RWMutex
+	// Per sensor and metric, ordered by EffectiveFrom
+	profiles map[string]map[string][]Profile
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(ctx context.Context, pool *pgxpool.Pool) (*Calibrator, error) {
+	c := &Calibrator{pool: pool, log: logging.Component("calibration")}
+	if err := c.reload(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Transform implements processor.Transformer. The reported values are kept
+// in reading.Raw when at least one metric is calibrated.
+func (c *Calibrator) Transform(reading *pb.SensorReading) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	metrics, ok := c.profiles[reading.SensorId]
+	if !ok {
+		return
+	}
+
+	at := reading.ReadingTime()
+	raw := &pb.RawValues{
+		Temperature:  reading.Temperature,
+		Humidity:     reading.Humidity,
+		CoLevel:      reading.CoLevel,
+		BatteryLevel: reading.BatteryLevel,
+	}
+
+	applied := false
+	for metric, profiles := range metrics {
+		p, ok := effective(profiles, at)
+		if !ok {
+			continue
+		}
+
+		switch metric {
+		case "temperature":
+			reading.Temperature = p.apply(raw.Temperature)
+		case "humidity":
+			reading.Humidity = p.apply(raw.Humidity)
+		case "co_level":
+			reading.CoLevel = p.apply(raw.CoLevel)
+		case "battery_level":
+			reading.BatteryLevel = p.apply(raw.BatteryLevel)
+		default:
+			continue
+		}
+		applied = true
+	}
+
+	if applied {
+		reading.Raw = raw
+	}
+}
+
+func (p Profile) apply(v float64) float64 {
+	return v*p.Gain + p.Offset
+}
+
+// Latest profile in effect at t
+func effective(profiles []Profile, t time.Time) (Profile, bool) {
+	i := sort.Search(len(profiles), func(i int) bool {
+		return profiles[i].EffectiveFrom.After(t)
+	})
+	if i == 0 {
+		return Profile{}, false
+	}
+	return profiles[i-1], true
+}
+
+// Start listens for calibration changes made by any replica and reloads
+// the cache when one arrives
+func (c *Calibrator) Start(ctx context.Context) {
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.wg.Add(1)
+
+	go func() {
+		defer c.wg.Done()
+		for {
+			err := c.listen(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			c.log.Error("Lost calibration notifications, listening again", "retry_in", relistenDelay, logging.Err(err))
+
+			select {
+			case <-time.After(relistenDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (c *Calibrator) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+}
+
+// Holds a connection for LISTEN until it fails or ctx is cancelled
+func (c *Calibrator) listen(ctx context.Context) error {
+	conn, err := c.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// LISTEN state must not leak back into the pool
+	defer conn.Hijack().Close(context.WithoutCancel(ctx))
+
+	if _, err := conn.Exec(ctx, "LISTEN "+notifyChannel); err != nil {
+		return err
+	}
+
+	// Changes made while not listening were missed
+	if err := c.reload(ctx); err != nil {
+		return err
+	}
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := c.reload(ctx); err != nil {
+			c.log.Error("Failed to reload calibrations", logging.KeySensor, n.Payload, logging.Err(err))
+			continue
+		}
+		c.log.Info("Reloaded calibrations", logging.KeySensor, n.Payload)
+	}
+}
+
+func (c *Calibrator) reload(ctx context.Context) error {
+	profiles, err := c.List(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	bySensor := map[string]map[string][]Profile{}
+	for _, p := range profiles {
+		if bySensor[p.SensorID] == nil {
+			bySensor[p.SensorID] = map[string][]Profile{}
+		}
+		bySensor[p.SensorID][p.Metric] = append(bySensor[p.SensorID][p.Metric], p)
+	}
+
+	c.mu.Lock()
+	c.profiles = bySensor
+	c.mu.Unlock()
+	return nil
+}
+
+// List returns every profile of a sensor, or of all sensors when sensorID is
+// empty, oldest first
+func (c *Calibrator) List(ctx context.Context, sensorID string) ([]Profile, error) {
+	rows, err := c.pool.Query(ctx, `
+		SELECT `+profileColumns+` FROM sensor_calibrations
+		WHERE $1 = '' OR sensor_id = $1
+		ORDER BY sensor_id, metric, effective_from`, sensorID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanProfile)
+}
+
+// Set stores a profile, replacing one for the same metric and effective
+// time. The cache is refreshed right away; other replicas are notified by
+// the trigger.
+func (c *Calibrator) Set(ctx context.Context, p Profile) (*Profile, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.pool.Query(ctx, `
+		INSERT INTO sensor_calibrations (sensor_id, metric, gain, "offset", effective_from)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (sensor_id, metric, effective_from) DO UPDATE SET
+			gain = EXCLUDED.gain, "offset" = EXCLUDED."offset"
+		RETURNING `+profileColumns,
+		p.SensorID, p.Metric, p.Gain, p.Offset, p.EffectiveFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := pgx.CollectExactlyOneRow(rows, scanProfile)
+	if err != nil {
+		return nil, err
+	}
+	return &stored, c.reload(ctx)
+}
+
+// Delete removes a single profile
+func (c *Calibrator) Delete(ctx context.Context, sensorID, metric string, effectiveFrom time.Time) error {
+	tag, err := c.pool.Exec(ctx, `
+		DELETE FROM sensor_calibrations
+		WHERE sensor_id = $1 AND metric = $2 AND effective_from = $3`,
+		sensorID, metric, effectiveFrom)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return c.reload(ctx)
+}
+
+func (p *Profile) validate() error {
+	if p.SensorID == "" {
+		return fmt.Errorf("%w: sensor_id is required", ErrInvalid)
+	}
+	if _, ok := pb.MetricValue(nil, p.Metric); !ok {
+		return fmt.Errorf("%w: unknown metric %q", ErrInvalid, p.Metric)
+	}
+	if p.Gain == 0 || math.IsNaN(p.Gain) || math.IsInf(p.Gain, 0) {
+		return fmt.Errorf("%w: gain must be a non-zero number", ErrInvalid)
+	}
+	if math.IsNaN(p.Offset) || math.IsInf(p.Offset, 0) {
+		return fmt.Errorf("%w: offset must be a number", ErrInvalid)
+	}
+	if p.EffectiveFrom.IsZero() {
+		return fmt.Errorf("%w: effective_from is required", ErrInvalid)
+	}
+	return nil
+}
+
+func scanProfile(row pgx.CollectableRow) (Profile, error) {
+	var p Profile
+	err := row.Scan(&p.SensorID, &p.Metric, &p.Gain, &p.Offset, &p.EffectiveFrom, &p.CreatedAt)
+	return p, err
+}
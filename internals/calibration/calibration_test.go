@@ -0,0 +1,120 @@
+package calibration
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEffective(t *testing.T) {
+	start := time.Now()
+	profiles := []Profile{
+		{Gain: 1, Offset: 1, EffectiveFrom: start},
+		{Gain: 1, Offset: 2, EffectiveFrom: start.Add(time.Hour)},
+		{Gain: 1, Offset: 3, EffectiveFrom: start.Add(2 * time.Hour)},
+	}
+
+	tests := []struct {
+		name       string
+		profiles   []Profile
+		at         time.Time
+		wantOffset float64
+		wantOK     bool
+	}{
+		{"no profiles", nil, start, 0, false},
+		{"before the first", profiles, start.Add(-time.Second), 0, false},
+		{"from its start", profiles, start, 1, true},
+		{"between two", profiles, start.Add(90 * time.Minute), 2, true},
+		{"at a boundary", profiles, start.Add(time.Hour), 2, true},
+		{"after the last", profiles, start.Add(48 * time.Hour), 3, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := effective(tt.profiles, tt.at)
+			if ok != tt.wantOK || p.Offset != tt.wantOffset {
+				t.Fatalf("effective = offset %g, %t, want offset %g, %t", p.Offset, ok, tt.wantOffset, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTransform(t *testing.T) {
+	start := time.Now()
+	c := &Calibrator{profiles: map[string]map[string][]Profile{
+		"s1": {
+			"temperature": {{Gain: 1, Offset: -0.5, EffectiveFrom: start}},
+			"humidity":    {{Gain: 2, Offset: 0, EffectiveFrom: start.Add(time.Hour)}},
+		},
+	}}
+	reported := func(sensorID string, at time.Time) *pb.SensorReading {
+		return &pb.SensorReading{
+			SensorId: sensorID, Timestamp: at.UnixMilli(),
+			Temperature: 21, Humidity: 40, CoLevel: 2, BatteryLevel: 90,
+		}
+	}
+	raw := &pb.RawValues{Temperature: 21, Humidity: 40, CoLevel: 2, BatteryLevel: 90}
+
+	tests := []struct {
+		name    string
+		reading *pb.SensorReading
+		want    *pb.SensorReading
+	}{
+		{"unknown sensor", reported("s2", start), reported("s2", start)},
+		{"before any profile", reported("s1", start.Add(-time.Minute)), reported("s1", start.Add(-time.Minute))},
+		{"one metric in effect", reported("s1", start.Add(time.Minute)), &pb.SensorReading{
+			SensorId: "s1", Timestamp: start.Add(time.Minute).UnixMilli(),
+			Temperature: 20.5, Humidity: 40, CoLevel: 2, BatteryLevel: 90, Raw: raw,
+		}},
+		{"both metrics in effect", reported("s1", start.Add(2*time.Hour)), &pb.SensorReading{
+			SensorId: "s1", Timestamp: start.Add(2 * time.Hour).UnixMilli(),
+			Temperature: 20.5, Humidity: 80, CoLevel: 2, BatteryLevel: 90, Raw: raw,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.Transform(tt.reading)
+			if !proto.Equal(tt.reading, tt.want) {
+				t.Fatalf("calibrated to %v, want %v", tt.reading, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfileValidate(t *testing.T) {
+	valid := Profile{SensorID: "s1", Metric: "temperature", Gain: 1, EffectiveFrom: time.Now()}
+
+	tests := []struct {
+		name   string
+		modify func(p *Profile)
+		valid  bool
+	}{
+		{"valid", func(p *Profile) {}, true},
+		{"negative gain", func(p *Profile) { p.Gain = -1 }, true},
+		{"missing sensor", func(p *Profile) { p.SensorID = "" }, false},
+		{"unknown metric", func(p *Profile) { p.Metric = "pressure" }, false},
+		{"zero gain", func(p *Profile) { p.Gain = 0 }, false},
+		{"NaN gain", func(p *Profile) { p.Gain = math.NaN() }, false},
+		{"infinite offset", func(p *Profile) { p.Offset = math.Inf(1) }, false},
+		{"missing effective_from", func(p *Profile) { p.EffectiveFrom = time.Time{} }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid
+			tt.modify(&p)
+			err := p.validate()
+			if tt.valid && err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalid) {
+				t.Fatalf("validate: %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
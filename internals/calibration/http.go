@@ -0,0 +1,100 @@
+package calibration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/knightfall22/Phylax/internals/httpx"
+)
+
+// RegisterRoutes exposes calibration profiles:
+//
+//	GET    /api/v1/sensors/{id}/calibrations                               profiles of a sensor
+func (c *Calibrator) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/sensors/{id}/calibrations", c.handleList)
+}
+
+// RegisterAdminRoutes exposes changes to calibration profiles, which belong
+// on the authenticated admin listener:
+//
+//	POST   /api/v1/sensors/{id}/calibrations                               add or replace a profile
+//	DELETE /api/v1/sensors/{id}/calibrations?metric=&effective_from=       remove a profile
+//
+// A new profile has gain 1, offset 0 and takes effect now unless specified.
+func (c *Calibrator) RegisterAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/sensors/{id}/calibrations", c.handleSet)
+	mux.HandleFunc("DELETE /api/v1/sensors/{id}/calibrations", c.handleDelete)
+}
+
+func (c *Calibrator) handleList(w http.ResponseWriter, r *http.Request) {
+	profiles, err := c.List(r.Context(), r.PathValue("id"))
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, profiles)
+}
+
+func (c *Calibrator) handleSet(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Metric        string     `json:"metric"`
+		Gain          *float64   `json:"gain"`
+		Offset        float64    `json:"offset"`
+		EffectiveFrom *time.Time `json:"effective_from"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpx.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid calibration: %w", err))
+		return
+	}
+
+	p := Profile{
+		SensorID:      r.PathValue("id"),
+		Metric:        req.Metric,
+		Gain:          1,
+		Offset:        req.Offset,
+		EffectiveFrom: time.Now(),
+	}
+	if req.Gain != nil {
+		p.Gain = *req.Gain
+	}
+	if req.EffectiveFrom != nil {
+		p.EffectiveFrom = *req.EffectiveFrom
+	}
+
+	stored, err := c.Set(r.Context(), p)
+	if errors.Is(err, ErrInvalid) {
+		httpx.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusCreated, stored)
+}
+
+func (c *Calibrator) handleDelete(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	effectiveFrom, err := time.Parse(time.RFC3339Nano, q.Get("effective_from"))
+	if err != nil {
+		httpx.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid effective_from: %w", err))
+		return
+	}
+
+	err = c.Delete(r.Context(), r.PathValue("id"), q.Get("metric"), effectiveFrom)
+	if errors.Is(err, ErrNotFound) {
+		httpx.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,287 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/publisher"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	StreamName = "SENSORS_DLQ"
+
+	// Dead letters are stored under "dlq.<original subject>" so the original
+	// subject can always be recovered, even if the headers are lost.
+	SubjectPrefix = "dlq."
+)
+
+// Headers attached to every dead-lettered message. The original headers are
+// kept alongside them and restored by Redrive.
+const (
+	HeaderPrefix     = "Phylax-Dlq-"
+	HeaderReason     = "Phylax-Dlq-Reason"
+	HeaderSubject    = "Phylax-Dlq-Subject"
+	HeaderWorker     = "Phylax-Dlq-Worker"
+	HeaderDeliveries = "Phylax-Dlq-Deliveries"
+	HeaderFailedAt   = "Phylax-Dlq-Failed-At"
+	// The original Nats-Msg-Id, renamed so the dead-letter stream does not
+	// deduplicate messages that fail more than once
+	HeaderMsgID = "Phylax-Dlq-Msg-Id"
+)
+
+// ErrDuplicate is returned by Redrive when the readings stream still
+// remembers the message id, so the republished message would be dropped as
+// a duplicate. The dead letter is kept and can be re-driven once the
+// stream's duplicate window has passed.
+var ErrDuplicate = errors.New("message id is still inside the stream's duplicate window")
+
+// Queue moves poison messages out of SENSORS_READINGS into a separate
+// stream, where they can be inspected and re-driven once the cause is fixed.
+type Queue struct {
+	js     jetstream.JetStream
+	stream jetstream.Stream
+}
+
+// Entry is a single dead-lettered message
+type Entry struct {
+	Sequence        uint64    `json:"sequence"`
+	OriginalSubject string    `json:"original_subject"`
+	Reason          string    `json:"reason"`
+	Worker          int       `json:"worker"`
+	Deliveries      uint64    `json:"deliveries"`
+	FailedAt        time.Time `json:"failed_at"`
+	StoredAt        time.Time `json:"stored_at"`
+	Data            []byte    `json:"data,omitempty"`
+}
+
+func New(ctx context.Context, js jetstream.JetStream) (*Queue, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      StreamName,
+		Retention: jetstream.LimitsPolicy,
+		Subjects:  []string{SubjectPrefix + ">"},
+		MaxAge:    14 * 24 * time.Hour,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Queue{
+		js:     js,
+		stream: stream,
+	}, nil
+}
+
+// Publish copies msg into the dead-letter stream along with the failure reason.
+// The caller is responsible for acknowledging the original message once
+// Publish succeeds.
+func (q *Queue) Publish(ctx context.Context, msg jetstream.Msg, reason string, worker int) error {
+	var deliveries uint64
+	if meta, err := msg.Metadata(); err == nil {
+		deliveries = meta.NumDelivered
+	}
+
+	dlqMsg := nats.NewMsg(SubjectPrefix + msg.Subject())
+	dlqMsg.Data = msg.Data()
+	for key, values := range msg.Headers() {
+		if key == jetstream.MsgIDHeader {
+			key = HeaderMsgID
+		}
+		dlqMsg.Header[key] = values
+	}
+	dlqMsg.Header.Set(HeaderReason, reason)
+	dlqMsg.Header.Set(HeaderSubject, msg.Subject())
+	dlqMsg.Header.Set(HeaderWorker, strconv.Itoa(worker))
+	dlqMsg.Header.Set(HeaderDeliveries, strconv.FormatUint(deliveries, 10))
+	dlqMsg.Header.Set(HeaderFailedAt, time.Now().UTC().Format(time.RFC3339Nano))
+
+	_, err := q.js.PublishMsg(ctx, dlqMsg)
+	return err
+}
+
+// PublishReading dead-letters a reading that is no longer backed by a
+// message, such as one replayed from the spill log. It is stored under the
+// subject the reading would have been published on.
+func (q *Queue) PublishReading(ctx context.Context, reading *pb.SensorReading, reason string) error {
+	data, err := proto.Marshal(reading)
+	if err != nil {
+		return err
+	}
+
+	subject := readingSubject(reading)
+	dlqMsg := nats.NewMsg(SubjectPrefix + subject)
+	dlqMsg.Data = data
+	dlqMsg.Header.Set(HeaderReason, reason)
+	dlqMsg.Header.Set(HeaderSubject, subject)
+	dlqMsg.Header.Set(HeaderFailedAt, time.Now().UTC().Format(time.RFC3339Nano))
+
+	_, err = q.js.PublishMsg(ctx, dlqMsg)
+	return err
+}
+
+// Subject readings of the sensor are published on. Zones or ids that cannot
+// be part of a subject are replaced, the reading itself keeps them.
+func readingSubject(reading *pb.SensorReading) string {
+	tokens := []string{reading.SensorZone, reading.SensorId}
+	for i, v := range tokens {
+		if v == "" || strings.ContainsAny(v, ".*> \t") {
+			tokens[i] = "unknown"
+		}
+	}
+	return publisher.ReadingSubjectPrefix + strings.Join(tokens, ".")
+}
+
+// List returns up to limit dead letters starting at sequence from.
+// Message payloads are omitted; use Get to inspect a single entry.
+func (q *Queue) List(ctx context.Context, from uint64, limit int) ([]Entry, error) {
+	if from == 0 {
+		from = 1
+	}
+
+	entries := []Entry{}
+	for len(entries) < limit {
+		raw, err := q.stream.GetMsg(ctx, from, jetstream.WithGetMsgSubject(SubjectPrefix+">"))
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry := toEntry(raw)
+		entry.Data = nil
+		entries = append(entries, entry)
+		from = raw.Sequence + 1
+	}
+
+	return entries, nil
+}
+
+// Get returns a single dead letter, including its payload
+func (q *Queue) Get(ctx context.Context, seq uint64) (*Entry, error) {
+	raw, err := q.stream.GetMsg(ctx, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := toEntry(raw)
+	return &entry, nil
+}
+
+// Redrive republishes a dead letter onto its original subject with its
+// original headers and removes it from the dead-letter stream.
+func (q *Queue) Redrive(ctx context.Context, seq uint64) error {
+	raw, err := q.stream.GetMsg(ctx, seq)
+	if err != nil {
+		return err
+	}
+
+	entry := toEntry(raw)
+	if entry.OriginalSubject == "" {
+		return fmt.Errorf("dead letter %d has no original subject", seq)
+	}
+
+	msg := nats.NewMsg(entry.OriginalSubject)
+	msg.Data = raw.Data
+	msg.Header = originalHeaders(raw.Header)
+
+	ack, err := q.js.PublishMsg(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("republish dead letter %d: %w", seq, err)
+	}
+	if ack.Duplicate {
+		return fmt.Errorf("dead letter %d: %w", seq, ErrDuplicate)
+	}
+
+	return q.stream.DeleteMsg(ctx, seq)
+}
+
+// Drops the Phylax-Dlq-* headers and restores the original message id
+func originalHeaders(header nats.Header) nats.Header {
+	original := nats.Header{}
+	for key, values := range header {
+		if !strings.HasPrefix(strings.ToLower(key), strings.ToLower(HeaderPrefix)) {
+			original[key] = values
+		}
+	}
+	if id := header.Get(HeaderMsgID); id != "" {
+		original.Set(jetstream.MsgIDHeader, id)
+	}
+	return original
+}
+
+// RedriveAll re-drives every dead letter in the stream when it is called
+// and returns how many were moved back. Dead letters still inside the
+// duplicate window are skipped. Readings that fail again while it runs are
+// dead-lettered past the starting end of the stream and left for the next
+// call, so a poison reading is not redriven over and over.
+func (q *Queue) RedriveAll(ctx context.Context) (int, error) {
+	info, err := q.stream.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		from  uint64 = 1
+		last         = info.State.LastSeq
+		count int
+	)
+
+	for from <= last {
+		raw, err := q.stream.GetMsg(ctx, from, jetstream.WithGetMsgSubject(SubjectPrefix+">"))
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if raw.Sequence > last {
+			return count, nil
+		}
+
+		from = raw.Sequence + 1
+		err = q.Redrive(ctx, raw.Sequence)
+		if errors.Is(err, ErrDuplicate) {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+
+		count++
+	}
+	return count, nil
+}
+
+func toEntry(raw *jetstream.RawStreamMsg) Entry {
+	entry := Entry{
+		Sequence:        raw.Sequence,
+		OriginalSubject: strings.TrimPrefix(raw.Subject, SubjectPrefix),
+		StoredAt:        raw.Time,
+		Data:            raw.Data,
+	}
+
+	if raw.Header == nil {
+		return entry
+	}
+
+	if subject := raw.Header.Get(HeaderSubject); subject != "" {
+		entry.OriginalSubject = subject
+	}
+	entry.Reason = raw.Header.Get(HeaderReason)
+	entry.Worker, _ = strconv.Atoi(raw.Header.Get(HeaderWorker))
+	entry.Deliveries, _ = strconv.ParseUint(raw.Header.Get(HeaderDeliveries), 10, 64)
+	entry.FailedAt, _ = time.Parse(time.RFC3339Nano, raw.Header.Get(HeaderFailedAt))
+
+	return entry
+}
@@ -0,0 +1,201 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/knightfall22/Phylax/internals/natstest"
+	"github.com/knightfall22/Phylax/publisher"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestOriginalHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		header nats.Header
+		want   nats.Header
+	}{
+		{"none", nats.Header{}, nats.Header{}},
+		{"dead-letter headers dropped", nats.Header{
+			HeaderReason:  {"persist: check violation"},
+			HeaderSubject: {"sensors.office.s1"},
+			HeaderWorker:  {"3"},
+			"Traceparent": {"00-abc-def-01"},
+		}, nats.Header{
+			"Traceparent": {"00-abc-def-01"},
+		}},
+		{"prefix matched regardless of case", nats.Header{
+			"phylax-dlq-reason": {"decode"},
+			"Phylax-Sensor":     {"s1"},
+		}, nats.Header{
+			"Phylax-Sensor": {"s1"},
+		}},
+		{"message id restored", nats.Header{
+			HeaderMsgID: {"s1-1760778000000"},
+		}, nats.Header{
+			jetstream.MsgIDHeader: {"s1-1760778000000"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := originalHeaders(tt.header)
+			if !maps.EqualFunc(got, tt.want, slices.Equal) {
+				t.Fatalf("headers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToEntry(t *testing.T) {
+	failedAt := time.Date(2026, 10, 18, 9, 0, 0, 123000000, time.UTC)
+
+	tests := []struct {
+		name string
+		raw  *jetstream.RawStreamMsg
+		want Entry
+	}{
+		{"all headers", &jetstream.RawStreamMsg{
+			Subject:  "dlq.sensors.office.s1",
+			Sequence: 7,
+			Header: nats.Header{
+				HeaderReason:     {"decode: bad wire type"},
+				HeaderSubject:    {"sensors.office.s1"},
+				HeaderWorker:     {"2"},
+				HeaderDeliveries: {"5"},
+				HeaderFailedAt:   {failedAt.Format(time.RFC3339Nano)},
+			},
+		}, Entry{
+			Sequence:        7,
+			OriginalSubject: "sensors.office.s1",
+			Reason:          "decode: bad wire type",
+			Worker:          2,
+			Deliveries:      5,
+			FailedAt:        failedAt,
+		}},
+		{"headers lost", &jetstream.RawStreamMsg{
+			Subject:  "dlq.sensors.office.s1",
+			Sequence: 8,
+		}, Entry{
+			Sequence:        8,
+			OriginalSubject: "sensors.office.s1",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := toEntry(tt.raw)
+			got.Data, got.StoredAt = nil, time.Time{}
+			if got.Sequence != tt.want.Sequence || got.OriginalSubject != tt.want.OriginalSubject ||
+				got.Reason != tt.want.Reason || got.Worker != tt.want.Worker ||
+				got.Deliveries != tt.want.Deliveries || !got.FailedAt.Equal(tt.want.FailedAt) {
+				t.Fatalf("entry %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Returns the next reading message as the processor receives it
+func fetchOne(t *testing.T, consumer jetstream.Consumer) jetstream.Msg {
+	t.Helper()
+
+	batch, err := consumer.Fetch(1, jetstream.FetchMaxWait(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, ok := <-batch.Messages()
+	if !ok {
+		t.Fatalf("no message: %v", batch.Error())
+	}
+	return msg
+}
+
+func TestPublishAndRedrive(t *testing.T) {
+	ctx := context.Background()
+	nc := natstest.Run(t)
+	js := nc.JetStream()
+
+	q, err := New(ctx, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := nc.ProcessorConsumer(ctx, publisher.ConsumerOptions{MaxDeliver: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		msgID string
+		// ErrDuplicate while the stream still remembers the message id
+		wantErr error
+	}{
+		{"without message id", "", nil},
+		{"inside the duplicate window", "s1-1760778000000", ErrDuplicate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := nats.NewMsg("sensors.office.s1")
+			msg.Data = []byte(tt.name)
+			msg.Header.Set("Firmware", "1.4.2")
+			if tt.msgID != "" {
+				msg.Header.Set(jetstream.MsgIDHeader, tt.msgID)
+			}
+			if _, err := js.PublishMsg(ctx, msg); err != nil {
+				t.Fatal(err)
+			}
+
+			received := fetchOne(t, consumer)
+			if err := q.Publish(ctx, received, "persist: check violation", 3); err != nil {
+				t.Fatal(err)
+			}
+			if err := received.Ack(); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := q.List(ctx, 0, 10)
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("listed %d dead letters, %v", len(entries), err)
+			}
+			entry := entries[0]
+			if entry.OriginalSubject != "sensors.office.s1" || entry.Reason != "persist: check violation" ||
+				entry.Worker != 3 || entry.Deliveries != 1 || entry.FailedAt.IsZero() {
+				t.Fatalf("dead letter %+v", entry)
+			}
+
+			err = q.Redrive(ctx, entry.Sequence)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Redrive: %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				// Kept for a later redrive
+				if _, err := q.Get(ctx, entry.Sequence); err != nil {
+					t.Fatal(err)
+				}
+				if err := q.stream.DeleteMsg(ctx, entry.Sequence); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			redriven := fetchOne(t, consumer)
+			defer redriven.Ack()
+			if string(redriven.Data()) != tt.name || redriven.Subject() != "sensors.office.s1" {
+				t.Fatalf("redriven %q on %s", redriven.Data(), redriven.Subject())
+			}
+			want := nats.Header{"Firmware": {"1.4.2"}}
+			if got := redriven.Headers(); !maps.EqualFunc(got, want, slices.Equal) {
+				t.Fatalf("redriven headers %v, want %v", got, want)
+			}
+			if _, err := q.Get(ctx, entry.Sequence); !errors.Is(err, jetstream.ErrMsgNotFound) {
+				t.Fatalf("dead letter kept after redrive: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package deadletter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/knightfall22/Phylax/internals/httpx"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// RegisterRoutes exposes the dead-letter queue over HTTP:
+//
+//	GET  /dlq?from=<seq>&limit=<n>  list dead letters
+//	GET  /dlq/{seq}                 inspect a single dead letter
+func (q *Queue) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /dlq", q.handleList)
+	mux.HandleFunc("GET /dlq/{seq}", q.handleGet)
+}
+
+// RegisterAdminRoutes exposes redrive, which belongs on the authenticated
+// admin listener:
+//
+//	POST /dlq/{seq}/redrive         move a dead letter back onto sensors.>, 409 while its
+//	                                message id is inside the duplicate window
+//	POST /dlq/redrive               move every dead letter back onto sensors.>
+func (q *Queue) RegisterAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /dlq/{seq}/redrive", q.handleRedrive)
+	mux.HandleFunc("POST /dlq/redrive", q.handleRedriveAll)
+}
+
+func (q *Queue) handleList(w http.ResponseWriter, r *http.Request) {
+	var from uint64
+	if raw := r.URL.Query().Get("from"); raw != "" {
+		v, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			httpx.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid from %q", raw))
+			return
+		}
+		from = v
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	entries, err := q.List(r.Context(), from, limit)
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, entries)
+}
+
+func (q *Queue) handleGet(w http.ResponseWriter, r *http.Request) {
+	seq, err := strconv.ParseUint(r.PathValue("seq"), 10, 64)
+	if err != nil {
+		httpx.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entry, err := q.Get(r.Context(), seq)
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		httpx.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, entry)
+}
+
+func (q *Queue) handleRedrive(w http.ResponseWriter, r *http.Request) {
+	seq, err := strconv.ParseUint(r.PathValue("seq"), 10, 64)
+	if err != nil {
+		httpx.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = q.Redrive(r.Context(), seq)
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		httpx.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if errors.Is(err, ErrDuplicate) {
+		httpx.WriteError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]uint64{"redriven": seq})
+}
+
+func (q *Queue) handleRedriveAll(w http.ResponseWriter, r *http.Request) {
+	count, err := q.RedriveAll(r.Context())
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]int{"redriven": count})
+}
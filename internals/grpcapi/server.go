@@ -0,0 +1,215 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/config"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/processor"
+	"github.com/knightfall22/Phylax/internals/query"
+	"github.com/knightfall22/Phylax/publisher"
+	"github.com/nats-io/nats.go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Messages buffered per Subscribe stream before NATS starts dropping them
+const subscribeBuffer = 1024
+
+type Config struct {
+	// Server certificate and key, with clients verified against CAFile.
+	// All three are required unless Insecure is set.
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	// Serve plaintext without client verification
+	Insecure bool
+
+	// Applied to readings streamed by Subscribe so subscribers see the
+	// readings the processor stores rather than the raw payloads
+	Transforms []processor.Transformer
+	// Must not have side effects such as registering sensors, every
+	// subscriber runs them on readings the processor also sees
+	Gates []processor.Gate
+}
+
+// Server implements phylax.v1.ReadingService on top of the query store for
+// history and core NATS subscriptions for the live feed
+type Server struct {
+	pb.UnimplementedReadingServiceServer
+
+	store      *query.Store
+	nc         *publisher.NatsPublisher
+	transforms []processor.Transformer
+	gates      []processor.Gate
+	log        *slog.Logger
+}
+
+func NewServer(cfg Config, store *query.Store, nc *publisher.NatsPublisher) (*grpc.Server, error) {
+	opts := []grpc.ServerOption{}
+	if !cfg.Insecure {
+		if cfg.CertFile == "" || cfg.KeyFile == "" || cfg.CAFile == "" {
+			return nil, fmt.Errorf("mTLS requires a certificate, key and CA file unless insecure is set")
+		}
+		tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+			CertFile: cfg.CertFile,
+			KeyFile:  cfg.KeyFile,
+			CAFile:   cfg.CAFile,
+			Server:   true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	srv := grpc.NewServer(opts...)
+	pb.RegisterReadingServiceServer(srv, &Server{
+		store:      store,
+		nc:         nc,
+		transforms: cfg.Transforms,
+		gates:      cfg.Gates,
+		log:        logging.Component("grpc"),
+	})
+	return srv, nil
+}
+
+func (s *Server) GetLatest(ctx context.Context, req *pb.GetLatestRequest) (*pb.GetLatestResponse, error) {
+	if req.SensorId != "" {
+		reading, err := s.store.LatestForSensor(ctx, req.SensorId)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "no readings for sensor %q", req.SensorId)
+		}
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return &pb.GetLatestResponse{Readings: []*pb.SensorReading{toProto(*reading)}}, nil
+	}
+
+	readings, err := s.store.Latest(ctx, req.Zone)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.GetLatestResponse{Readings: toProtos(readings)}, nil
+}
+
+func (s *Server) QueryRange(ctx context.Context, req *pb.QueryRangeRequest) (*pb.QueryRangeResponse, error) {
+	if req.SensorId == "" && req.Zone == "" {
+		return nil, status.Error(codes.InvalidArgument, "sensor_id or zone is required")
+	}
+
+	filter := query.Filter{
+		SensorID: req.SensorId,
+		Zone:     req.Zone,
+		To:       time.Now().UTC(),
+	}
+	if req.To != nil {
+		filter.To = req.To.AsTime()
+	}
+	filter.From = filter.To.Add(-time.Hour)
+	if req.From != nil {
+		filter.From = req.From.AsTime()
+	}
+	if !filter.From.Before(filter.To) {
+		return nil, status.Error(codes.InvalidArgument, "from must be before to")
+	}
+
+	limit := int(req.Limit)
+	if limit <= 0 {
+		limit = query.DefaultLimit
+	}
+	if limit > query.MaxLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must not exceed %d", query.MaxLimit)
+	}
+
+	page, err := s.store.Range(ctx, filter, limit, req.Cursor)
+	if errors.Is(err, query.ErrInvalidCursor) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.QueryRangeResponse{
+		Readings:   toProtos(page.Readings),
+		NextCursor: page.NextCursor,
+	}, nil
+}
+
+// Subscribe streams readings as they arrive on NATS, run through the same
+// transforms and gates as the processor. Readings a gate refuses are not
+// sent.
+func (s *Server) Subscribe(req *pb.SubscribeRequest, stream pb.ReadingService_SubscribeServer) error {
+	subject, err := publisher.ReadingSubject(req.Zone, req.SensorId)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ch := make(chan *nats.Msg, subscribeBuffer)
+	sub, err := s.nc.Subscribe(subject, ch)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case msg := <-ch:
+			var reading pb.SensorReading
+			if err := proto.Unmarshal(msg.Data, &reading); err != nil {
+				s.log.Warn("Subscribe skipping invalid reading", "subject", msg.Subject, logging.Err(err))
+				continue
+			}
+			if !processor.Preview(&reading, s.transforms, s.gates) {
+				continue
+			}
+
+			if err := stream.Send(&reading); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func toProto(r query.Reading) *pb.SensorReading {
+	reading := &pb.SensorReading{
+		SensorId:     r.SensorID,
+		SensorZone:   r.Zone,
+		Timestamp:    r.Time.UnixMilli(),
+		ObservedAt:   timestamppb.New(r.Time),
+		Temperature:  r.Temperature,
+		Humidity:     r.Humidity,
+		CoLevel:      r.CoLevel,
+		BatteryLevel: r.BatteryLevel,
+	}
+	if r.Raw != nil {
+		reading.Raw = &pb.RawValues{
+			Temperature:  r.Raw.Temperature,
+			Humidity:     r.Raw.Humidity,
+			CoLevel:      r.Raw.CoLevel,
+			BatteryLevel: r.Raw.BatteryLevel,
+		}
+	}
+	return reading
+}
+
+func toProtos(readings []query.Reading) []*pb.SensorReading {
+	out := make([]*pb.SensorReading, 0, len(readings))
+	for _, r := range readings {
+		out = append(out, toProto(r))
+	}
+	return out
+}
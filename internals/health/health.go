@@ -0,0 +1,155 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/knightfall22/Phylax/internals/httpx"
+)
+
+// Check returns nil when the component it probes is healthy
+type Check func(ctx context.Context) error
+
+// Longest a single check may take before it is reported as failed
+const DefaultTimeout = 2 * time.Second
+
+type namedCheck struct {
+	name  string
+	check Check
+	live  bool
+}
+
+// Checker serves the Kubernetes probes. Liveness checks fail only when the
+// process cannot recover on its own and should be restarted. Readiness
+// runs every check, liveness included.
+type Checker struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+func New(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{timeout: timeout}
+}
+
+// Live adds a check to both /healthz and /readyz
+func (c *Checker) Live(name string, check Check) {
+	c.add(namedCheck{name: name, check: check, live: true})
+}
+
+// Ready adds a check to /readyz only
+func (c *Checker) Ready(name string, check Check) {
+	c.add(namedCheck{name: name, check: check})
+}
+
+func (c *Checker) add(nc namedCheck) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, nc)
+}
+
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+func (r Report) OK() bool { return r.Status == statusOK }
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+// Run executes the liveness checks, or all checks when readiness is true,
+// concurrently.
+func (c *Checker) Run(ctx context.Context, readiness bool) Report {
+	c.mu.RLock()
+	checks := make([]namedCheck, 0, len(c.checks))
+	for _, nc := range c.checks {
+		if readiness || nc.live {
+			checks = append(checks, nc)
+		}
+	}
+	c.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, nc.check)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: statusOK, Checks: make(map[string]Result, len(checks))}
+	for i, nc := range checks {
+		report.Checks[nc.name] = results[i]
+		if results[i].Status != statusOK {
+			report.Status = statusFail
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	// The check runs in its own goroutine so one that ignores ctx cannot
+	// hold up the probe
+	done := make(chan error, 1)
+	started := time.Now()
+	go func() { done <- check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := Result{Status: statusOK, Duration: time.Since(started).String()}
+	if err != nil {
+		res.Status = statusFail
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// RegisterRoutes exposes the probes:
+//
+//	GET /healthz   liveness checks
+//	GET /readyz    all checks
+//
+// Both answer 200 when every check passes and 503 otherwise, with the result
+// of each check in the body.
+func (c *Checker) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", c.handler(false))
+	mux.HandleFunc("GET /readyz", c.handler(true))
+}
+
+func (c *Checker) handler(readiness bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context(), readiness)
+
+		status := http.StatusOK
+		if !report.OK() {
+			status = http.StatusServiceUnavailable
+		}
+		httpx.WriteJSON(w, status, report)
+	}
+}
@@ -0,0 +1,279 @@
+package heartbeat
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/alerting"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/metrics"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// Weight of the newest gap when learning a zone's reporting interval
+	learnRate = 0.05
+	// Gaps longer than this are outages and are not learned from
+	maxLearnedGap = 10 * time.Minute
+	// Zones start with this interval until enough readings arrive
+	defaultInterval = time.Second
+	// Default for Options.MinInterval
+	DefaultMinInterval = 500 * time.Millisecond
+)
+
+type Options struct {
+	// A sensor is offline after missing this many expected intervals
+	MissedIntervals int
+	// Fixed reporting interval per zone. Zones not listed learn their
+	// interval from observed inter-arrival gaps.
+	ZoneIntervals map[string]time.Duration
+	// How often sensors are checked for missed heartbeats
+	CheckInterval time.Duration
+	// Floor for learned intervals, so bursts of readings cannot shrink a
+	// zone's interval to nothing
+	MinInterval time.Duration
+	// Clock readings are processed and checks run by, time.Now by default
+	Now func() time.Time
+}
+
+// Tracker records when each sensor was last heard from and emits
+// offline/online transitions when a sensor stops or resumes reporting.
+type Tracker struct {
+	js   jetstream.JetStream
+	opts Options
+	log  *slog.Logger
+	// Sampled, for messages logged per sensor on every check
+	hot *slog.Logger
+
+	mu      sync.Mutex
+	sensors map[string]*sensor
+	zones   map[string]*zone
+
+	// Reports whether the pipeline is stalled, see PauseWhen
+	paused func() bool
+	// End of the last pause. Missed heartbeats are counted from here at the
+	// earliest.
+	resumed time.Time
+
+	events chan alerting.Event
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+type sensor struct {
+	zone string
+	// When the latest reading was processed
+	lastSeen time.Time
+	// Time the sensor took the latest reading
+	lastReading time.Time
+	offline     bool
+}
+
+type zone struct {
+	interval time.Duration
+	fixed    bool // Configured rather than learned
+}
+
+func NewTracker(ctx context.Context, js jetstream.JetStream, opts Options) (*Tracker, error) {
+	if err := alerting.CreateStream(ctx, js); err != nil {
+		return nil, err
+	}
+
+	if opts.MissedIntervals <= 0 {
+		opts.MissedIntervals = 3
+	}
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = 5 * time.Second
+	}
+	if opts.MinInterval <= 0 {
+		opts.MinInterval = DefaultMinInterval
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	logger := logging.Component("heartbeat")
+	return &Tracker{
+		js:      js,
+		opts:    opts,
+		log:     logger,
+		hot:     logging.Sampled(logger),
+		sensors: make(map[string]*sensor),
+		zones:   make(map[string]*zone),
+		events:  make(chan alerting.Event, 1024),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Observe records a heartbeat for the reading's sensor. Intervals are
+// learned from the time readings were taken, so a backlog processed in a
+// burst does not look like a sensor reporting faster.
+func (t *Tracker) Observe(reading *pb.SensorReading) {
+	now := t.opts.Now()
+	taken := reading.ReadingTime()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	z := t.zone(reading.SensorZone)
+
+	s, ok := t.sensors[reading.SensorId]
+	if !ok {
+		t.sensors[reading.SensorId] = &sensor{zone: reading.SensorZone, lastSeen: now, lastReading: taken}
+		return
+	}
+
+	// Redeliveries and late readings say nothing about the sensor now
+	if !taken.After(s.lastReading) {
+		return
+	}
+
+	gap := taken.Sub(s.lastReading)
+	if !z.fixed && gap < maxLearnedGap {
+		z.interval += time.Duration(learnRate * float64(gap-z.interval))
+		z.interval = max(z.interval, t.opts.MinInterval)
+	}
+
+	// Stays offline if the event cannot be queued, the next reading
+	// retries
+	if s.offline && t.emit(reading.SensorId, s, alerting.StateResolved, now) {
+		s.offline = false
+		metrics.SensorsOffline.WithLabelValues(s.zone).Dec()
+	}
+	s.lastSeen = now
+	s.lastReading = taken
+}
+
+func (t *Tracker) zone(name string) *zone {
+	z, ok := t.zones[name]
+	if !ok {
+		z = &zone{interval: defaultInterval}
+		if interval, ok := t.opts.ZoneIntervals[name]; ok {
+			z.interval = interval
+			z.fixed = true
+		}
+		t.zones[name] = z
+	}
+	return z
+}
+
+// PauseWhen makes the tracker skip checks while stalled returns true, e.g.
+// while the sink breaker is open or the consumer is lagging, since readings
+// are then queued rather than missing. Must be called before Start.
+func (t *Tracker) PauseWhen(stalled func() bool) {
+	t.paused = stalled
+}
+
+// Start periodically checks for sensors that missed their heartbeats and
+// publishes transition events
+func (t *Tracker) Start(ctx context.Context) {
+	t.wg.Add(2)
+
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(t.opts.CheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.check(t.opts.Now())
+			case <-t.done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer t.wg.Done()
+		for {
+			select {
+			case event := <-t.events:
+				t.publish(ctx, event)
+			case <-t.done:
+				for {
+					select {
+					case event := <-t.events:
+						t.publish(ctx, event)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (t *Tracker) Stop() {
+	close(t.done)
+	t.wg.Wait()
+}
+
+func (t *Tracker) check(now time.Time) {
+	paused := t.paused != nil && t.paused()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if paused {
+		t.resumed = now
+		return
+	}
+
+	for id, s := range t.sensors {
+		if s.offline {
+			continue
+		}
+
+		z := t.zones[s.zone]
+		deadline := time.Duration(t.opts.MissedIntervals) * z.interval
+		// Not set offline if the event cannot be queued, the next check
+		// retries
+		if now.Sub(later(s.lastSeen, t.resumed)) > deadline && t.emit(id, s, alerting.StateFiring, now) {
+			s.offline = true
+			metrics.SensorsOffline.WithLabelValues(s.zone).Inc()
+		}
+	}
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// Queues a transition event. Returns false if the queue is full, in which
+// case the caller must not apply the transition.
+func (t *Tracker) emit(id string, s *sensor, state alerting.State, now time.Time) bool {
+	event := alerting.Event{
+		Kind:      alerting.KindOffline,
+		Rule:      alerting.KindOffline,
+		Severity:  "warning",
+		State:     state,
+		Scope:     alerting.ScopeSensor,
+		Zone:      s.zone,
+		SensorID:  id,
+		StartedAt: s.lastSeen,
+		At:        now,
+	}
+
+	select {
+	case t.events <- event:
+		return true
+	default:
+		t.hot.Warn("Heartbeat event queue full, deferring transition", "state", state, logging.KeySensor, id)
+		return false
+	}
+}
+
+func (t *Tracker) publish(ctx context.Context, event alerting.Event) {
+	if err := alerting.Publish(ctx, t.js, event); err != nil {
+		t.log.Error("Failed to publish heartbeat event", "state", event.State, logging.KeySensor, event.SensorID, logging.Err(err))
+		return
+	}
+	metrics.AlertEvents.WithLabelValues(event.Rule, string(event.State)).Inc()
+}
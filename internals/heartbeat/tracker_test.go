@@ -0,0 +1,162 @@
+package heartbeat
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/alerting"
+	"github.com/knightfall22/Phylax/internals/natstest"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func newTestTracker(t *testing.T, js jetstream.JetStream, opts Options) *Tracker {
+	t.Helper()
+
+	tr, err := NewTracker(context.Background(), js, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+// Manually advanced clock for Options.Now
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func testReading(sensorID string, taken time.Time) *pb.SensorReading {
+	return &pb.SensorReading{SensorId: sensorID, SensorZone: "office", Timestamp: taken.UnixMilli()}
+}
+
+// Expected interval after learning from gaps, starting at the default
+func learned(gaps ...time.Duration) time.Duration {
+	interval := defaultInterval
+	for _, gap := range gaps {
+		interval += time.Duration(learnRate * float64(gap-interval))
+		interval = max(interval, DefaultMinInterval)
+	}
+	return interval
+}
+
+func repeat(gap time.Duration, n int) []time.Duration {
+	gaps := make([]time.Duration, n)
+	for i := range gaps {
+		gaps[i] = gap
+	}
+	return gaps
+}
+
+func TestIntervalLearning(t *testing.T) {
+	tests := []struct {
+		name  string
+		fixed map[string]time.Duration
+		gaps  []time.Duration
+		want  time.Duration
+	}{
+		{"first reading learns nothing", nil, nil, defaultInterval},
+		{"moves towards the gap", nil, []time.Duration{3 * time.Second}, learned(3 * time.Second)},
+		{"converges on a steady gap", nil, repeat(5*time.Second, 500), 5 * time.Second},
+		{"bursts stop at the floor", nil, repeat(10*time.Millisecond, 500), DefaultMinInterval},
+		{"outages are not learned", nil, []time.Duration{time.Hour}, defaultInterval},
+		{"configured interval is kept", map[string]time.Duration{"office": 30 * time.Second},
+			repeat(time.Second, 50), 30 * time.Second},
+	}
+
+	js := natstest.Run(t).JetStream()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTracker(t, js, Options{ZoneIntervals: tt.fixed})
+
+			taken := time.Now()
+			tr.Observe(testReading("s1", taken))
+			for _, gap := range tt.gaps {
+				taken = taken.Add(gap)
+				tr.Observe(testReading("s1", taken))
+			}
+
+			got := tr.zones["office"].interval
+			if math.Abs(float64(got-tt.want)) > float64(10*time.Millisecond) {
+				t.Fatalf("interval %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Redelivered and late readings were taken before the latest one
+func TestStaleReadingsAreNotLearned(t *testing.T) {
+	tr := newTestTracker(t, natstest.Run(t).JetStream(), Options{})
+
+	now := time.Now()
+	tr.Observe(testReading("s1", now.Add(10*time.Second)))
+	tr.Observe(testReading("s1", now))
+	tr.Observe(testReading("s1", now.Add(10*time.Second)))
+
+	if got := tr.zones["office"].interval; got != defaultInterval {
+		t.Fatalf("interval %s, want %s", got, defaultInterval)
+	}
+}
+
+func TestOfflineAfterMissedIntervals(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	tr := newTestTracker(t, natstest.Run(t).JetStream(), Options{
+		ZoneIntervals: map[string]time.Duration{"office": time.Second},
+		Now:           clk.Now,
+	})
+
+	seen := clk.now
+	tr.Observe(testReading("s1", seen))
+
+	tr.check(seen.Add(2 * time.Second))
+	if tr.sensors["s1"].offline || len(tr.events) != 0 {
+		t.Fatal("offline before missing three intervals")
+	}
+
+	tr.check(seen.Add(4 * time.Second))
+	if !tr.sensors["s1"].offline {
+		t.Fatal("online after missing three intervals")
+	}
+	if event := <-tr.events; event.State != alerting.StateFiring || event.SensorID != "s1" {
+		t.Fatalf("event %s for %q, want firing for s1", event.State, event.SensorID)
+	}
+
+	clk.now = seen.Add(5 * time.Second)
+	tr.Observe(testReading("s1", clk.now))
+	if tr.sensors["s1"].offline {
+		t.Fatal("still offline after reporting again")
+	}
+	if event := <-tr.events; event.State != alerting.StateResolved || !event.StartedAt.Equal(seen) || !event.At.Equal(clk.now) {
+		t.Fatalf("event %s started %s at %s, want resolved started %s at %s", event.State, event.StartedAt, event.At, seen, clk.now)
+	}
+}
+
+func TestPausedChecksRestartTheDeadline(t *testing.T) {
+	stalled := true
+	clk := &clock{now: time.Now()}
+	tr := newTestTracker(t, natstest.Run(t).JetStream(), Options{
+		ZoneIntervals: map[string]time.Duration{"office": time.Second},
+		Now:           clk.Now,
+	})
+	tr.PauseWhen(func() bool { return stalled })
+
+	seen := clk.now
+	tr.Observe(testReading("s1", seen))
+
+	tr.check(seen.Add(10 * time.Second))
+	stalled = false
+	tr.check(seen.Add(12 * time.Second))
+	if tr.sensors["s1"].offline {
+		t.Fatal("offline counting time the pipeline was stalled")
+	}
+
+	tr.check(seen.Add(14 * time.Second))
+	if !tr.sensors["s1"].offline {
+		t.Fatal("online after missing three intervals since the pause")
+	}
+}
@@ -0,0 +1,23 @@
+// Package httpx holds the JSON response helpers shared by the HTTP APIs
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/knightfall22/Phylax/internals/logging"
+)
+
+// WriteJSON encodes v as the response body with the given status
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Component("http").Error("Failed to encode response", logging.Err(err))
+	}
+}
+
+// WriteError responds with {"error": "..."} and the given status
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,255 @@
+package livefeed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/alerting"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/metrics"
+	"github.com/knightfall22/Phylax/internals/processor"
+	"github.com/knightfall22/Phylax/publisher"
+	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Frames buffered per client. A client that falls this far behind is
+	// disconnected instead of slowing down everyone else.
+	clientBuffer = 256
+	// Messages buffered between NATS and the filter of a single client
+	subscriptionBuffer = 1024
+)
+
+var jsonOpts = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+// Feed pushes live readings and alerts to HTTP clients over Server-Sent
+// Events or WebSocket, straight from NATS rather than polling Postgres
+type Feed struct {
+	nc         *publisher.NatsPublisher
+	transforms []processor.Transformer
+	gates      []processor.Gate
+	log        *slog.Logger
+}
+
+type Options struct {
+	// Applied to readings before they are filtered and sent, so clients see
+	// the readings the processor stores rather than the raw payloads
+	Transforms []processor.Transformer
+	// Must not have side effects such as registering sensors, every
+	// subscriber runs them on readings the processor also sees
+	Gates []processor.Gate
+}
+
+func New(nc *publisher.NatsPublisher, opts Options) *Feed {
+	return &Feed{
+		nc:         nc,
+		transforms: opts.Transforms,
+		gates:      opts.Gates,
+		log:        logging.Component("livefeed"),
+	}
+}
+
+// RegisterRoutes exposes the live feed:
+//
+//	GET /api/v1/live/sse?zone=&sensor_id=&min_co=&alerts=   Server-Sent Events
+//	GET /api/v1/live/ws?zone=&sensor_id=&min_co=&alerts=    WebSocket
+//
+// Readings are calibrated and validated as the processor does, and
+// min_co drops those below the given calibrated CO level. Alerts for the
+// same zone and sensor are included unless alerts=false.
+func (f *Feed) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/live/sse", f.handleSSE)
+	mux.HandleFunc("GET /api/v1/live/ws", f.handleWebSocket)
+}
+
+type filter struct {
+	zone     string
+	sensorID string
+	minCO    float64
+	alerts   bool
+}
+
+func parseFilter(r *http.Request) (filter, error) {
+	q := r.URL.Query()
+	f := filter{
+		zone:     q.Get("zone"),
+		sensorID: q.Get("sensor_id"),
+		alerts:   q.Get("alerts") != "false",
+	}
+
+	if raw := q.Get("min_co"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return f, fmt.Errorf("invalid min_co: %w", err)
+		}
+		f.minCO = v
+	}
+	return f, nil
+}
+
+type frame struct {
+	Type string          `json:"type"` // reading or alert
+	Data json.RawMessage `json:"data"`
+}
+
+// A single connected client. Frames that pass the filter are queued on out;
+// slow is closed if out overflows.
+type client struct {
+	filter     filter
+	transforms []processor.Transformer
+	gates      []processor.Gate
+	out        chan frame
+	slow       chan struct{}
+	subs       []*nats.Subscription
+	done       chan struct{}
+
+	// Zone of the filtered sensor as of its latest reading. Owned by pump.
+	sensorZone string
+}
+
+var errSlowConsumer = errors.New("slow consumer")
+
+// Subscribes to readings (and alerts) matching the filter
+func (f *Feed) connect(flt filter) (*client, error) {
+	readingSubject, err := publisher.ReadingSubject(flt.zone, flt.sensorID)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{
+		filter:     flt,
+		transforms: f.transforms,
+		gates:      f.gates,
+		out:        make(chan frame, clientBuffer),
+		slow:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	readings := make(chan *nats.Msg, subscriptionBuffer)
+	sub, err := f.nc.Subscribe(readingSubject, readings)
+	if err != nil {
+		return nil, err
+	}
+	c.subs = append(c.subs, sub)
+
+	var alerts chan *nats.Msg
+	if flt.alerts {
+		zone := flt.zone
+		if zone == "" {
+			zone = "*"
+		}
+
+		alerts = make(chan *nats.Msg, subscriptionBuffer)
+		sub, err := f.nc.Subscribe(alerting.SubjectPrefix+zone+".>", alerts)
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+		c.subs = append(c.subs, sub)
+	}
+
+	go c.pump(readings, alerts)
+	return c, nil
+}
+
+// Filters and encodes messages from NATS onto the client's queue
+func (c *client) pump(readings, alerts chan *nats.Msg) {
+	for {
+		var (
+			fr  frame
+			ok  bool
+			msg *nats.Msg
+		)
+
+		select {
+		case <-c.done:
+			return
+		case msg = <-readings:
+			fr, ok = c.reading(msg)
+		case msg = <-alerts:
+			fr, ok = c.alert(msg)
+		}
+		if !ok {
+			continue
+		}
+
+		select {
+		case c.out <- fr:
+		default:
+			close(c.slow)
+			return
+		}
+	}
+}
+
+func (c *client) reading(msg *nats.Msg) (frame, bool) {
+	var reading pb.SensorReading
+	if err := proto.Unmarshal(msg.Data, &reading); err != nil {
+		return frame{}, false
+	}
+	if c.filter.sensorID != "" {
+		c.sensorZone = reading.SensorZone
+	}
+	if !processor.Preview(&reading, c.transforms, c.gates) || reading.CoLevel < c.filter.minCO {
+		return frame{}, false
+	}
+
+	data, err := jsonOpts.Marshal(&reading)
+	if err != nil {
+		return frame{}, false
+	}
+	return frame{Type: "reading", Data: data}, true
+}
+
+func (c *client) alert(msg *nats.Msg) (frame, bool) {
+	if c.filter.sensorID != "" {
+		var event alerting.Event
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			return frame{}, false
+		}
+		// Zone-wide alerts are still relevant to a single sensor view, but
+		// only those of the sensor's own zone. Without a zone filter that
+		// is only known once a reading of the sensor came in.
+		if event.Scope == alerting.ScopeSensor && event.SensorID != c.filter.sensorID {
+			return frame{}, false
+		}
+		if event.Scope != alerting.ScopeSensor && c.filter.zone == "" && event.Zone != c.sensorZone {
+			return frame{}, false
+		}
+	}
+	return frame{Type: "alert", Data: msg.Data}, true
+}
+
+func (c *client) close() {
+	select {
+	case <-c.done:
+		return
+	default:
+		close(c.done)
+	}
+
+	for _, sub := range c.subs {
+		sub.Unsubscribe()
+	}
+}
+
+func transportLabel(r *http.Request) string {
+	if strings.HasSuffix(r.URL.Path, "/ws") {
+		return "websocket"
+	}
+	return "sse"
+}
+
+func (f *Feed) track(r *http.Request) func() {
+	label := transportLabel(r)
+	metrics.LiveClients.WithLabelValues(label).Inc()
+	return func() { metrics.LiveClients.WithLabelValues(label).Dec() }
+}
@@ -0,0 +1,236 @@
+package livefeed
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/alerting"
+	"github.com/knightfall22/Phylax/internals/natstest"
+	"github.com/knightfall22/Phylax/internals/processor"
+	"github.com/knightfall22/Phylax/internals/validation"
+	"github.com/knightfall22/Phylax/publisher"
+	"google.golang.org/protobuf/proto"
+)
+
+type event struct {
+	typ  string
+	data string
+}
+
+// Opens an SSE stream and returns its events as they arrive
+func subscribe(t *testing.T, srv *httptest.Server, query string) <-chan event {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/live/sse?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Returns once the handler flushed its headers, by which time the
+	// client is subscribed
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	events := make(chan event, 16)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+
+		var ev event
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			case line == "" && ev.typ != "":
+				events <- ev
+				ev = event{}
+			}
+		}
+	}()
+	return events
+}
+
+func next(t *testing.T, events <-chan event) event {
+	t.Helper()
+
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return event{}
+}
+
+func publishReading(t *testing.T, nc *publisher.NatsPublisher, reading *pb.SensorReading) {
+	t.Helper()
+
+	subject, err := publisher.ReadingSubject(reading.SensorZone, reading.SensorId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := proto.Marshal(reading)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Publish(context.Background(), subject, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func publishAlert(t *testing.T, nc *publisher.NatsPublisher, e alerting.Event) {
+	t.Helper()
+
+	e.Kind, e.Rule, e.State, e.At = alerting.KindRule, "co-high", alerting.StateFiring, time.Now()
+	if err := alerting.Publish(context.Background(), nc.JetStream(), e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testReading(zone, sensorID string, co float64) *pb.SensorReading {
+	return &pb.SensorReading{
+		SensorId: sensorID, SensorZone: zone, Timestamp: time.Now().UnixMilli(),
+		Temperature: 21, Humidity: 45, CoLevel: co, BatteryLevel: 90,
+	}
+}
+
+func startFeed(t *testing.T) (*publisher.NatsPublisher, *httptest.Server) {
+	t.Helper()
+
+	nc := natstest.Run(t)
+	if err := alerting.CreateStream(context.Background(), nc.JetStream()); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	New(nc, Options{Gates: []processor.Gate{validation.New(validation.Options{})}}).RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return nc, srv
+}
+
+func TestSSEFilters(t *testing.T) {
+	nc, srv := startFeed(t)
+	events := subscribe(t, srv, "zone=office&min_co=10")
+
+	// Only the last one matches. Readings arrive in order, so it is the
+	// first event if the others were filtered.
+	publishReading(t, nc, testReading("lab", "s3", 50))
+	publishReading(t, nc, testReading("office", "s2", 5))
+	invalid := testReading("office", "s4", 40)
+	invalid.Humidity = 150
+	publishReading(t, nc, invalid)
+	publishReading(t, nc, testReading("office", "s1", 20))
+
+	ev := next(t, events)
+	var reading struct {
+		SensorID string  `json:"sensor_id"`
+		CoLevel  float64 `json:"co_level"`
+	}
+	if err := json.Unmarshal([]byte(ev.data), &reading); err != nil {
+		t.Fatal(err)
+	}
+	if ev.typ != "reading" || reading.SensorID != "s1" || reading.CoLevel != 20 {
+		t.Fatalf("%s event %s, want the reading of s1", ev.typ, ev.data)
+	}
+
+	publishAlert(t, nc, alerting.Event{Scope: alerting.ScopeZone, Zone: "lab"})
+	publishAlert(t, nc, alerting.Event{Scope: alerting.ScopeZone, Zone: "office"})
+
+	ev = next(t, events)
+	var alert alerting.Event
+	if err := json.Unmarshal([]byte(ev.data), &alert); err != nil {
+		t.Fatal(err)
+	}
+	if ev.typ != "alert" || alert.Zone != "office" {
+		t.Fatalf("%s event %s, want the office alert", ev.typ, ev.data)
+	}
+}
+
+func TestSSESensorAlerts(t *testing.T) {
+	nc, srv := startFeed(t)
+	events := subscribe(t, srv, "sensor_id=s1")
+
+	// Tells the feed which zone s1 is in
+	publishReading(t, nc, testReading("office", "s1", 2))
+	if ev := next(t, events); ev.typ != "reading" {
+		t.Fatalf("%s event %s, want the reading", ev.typ, ev.data)
+	}
+
+	publishAlert(t, nc, alerting.Event{Scope: alerting.ScopeSensor, Zone: "office", SensorID: "s2"})
+	publishAlert(t, nc, alerting.Event{Scope: alerting.ScopeZone, Zone: "lab"})
+	publishAlert(t, nc, alerting.Event{Scope: alerting.ScopeZone, Zone: "office"})
+	publishAlert(t, nc, alerting.Event{Scope: alerting.ScopeSensor, Zone: "office", SensorID: "s1"})
+
+	for _, want := range []alerting.Event{{Scope: alerting.ScopeZone}, {Scope: alerting.ScopeSensor, SensorID: "s1"}} {
+		ev := next(t, events)
+		var alert alerting.Event
+		if err := json.Unmarshal([]byte(ev.data), &alert); err != nil {
+			t.Fatal(err)
+		}
+		if ev.typ != "alert" || alert.Zone != "office" || alert.Scope != want.Scope || alert.SensorID != want.SensorID {
+			t.Fatalf("%s event %s, want the office %s alert", ev.typ, ev.data, want.Scope)
+		}
+	}
+}
+
+func TestSlowConsumerDisconnected(t *testing.T) {
+	nc := natstest.Run(t)
+	f := New(nc, Options{})
+
+	c, err := f.connect(filter{zone: "office"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+
+	// Nothing takes frames off the queue until one more than it holds
+	// arrived
+	for range clientBuffer + 1 {
+		publishReading(t, nc, testReading("office", "s1", 2))
+	}
+	select {
+	case <-c.slow:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client not dropped after its queue overflowed")
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/live/sse?zone=office", nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		streamSSE(rec, rec, req, c)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream kept open for a slow consumer")
+	}
+	body := rec.Body.String()
+	if !strings.HasSuffix(body, "event: error\ndata: {\"error\":\"slow consumer\"}\n\n") {
+		t.Fatalf("stream did not end with the slow consumer error:\n%s", body[max(0, len(body)-200):])
+	}
+}
@@ -0,0 +1,75 @@
+package livefeed
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/knightfall22/Phylax/internals/httpx"
+	"github.com/knightfall22/Phylax/internals/metrics"
+)
+
+// Comment lines sent while idle so proxies keep the connection open
+const keepAliveInterval = 15 * time.Second
+
+func (f *Feed) handleSSE(w http.ResponseWriter, r *http.Request) {
+	flt, err := parseFilter(r)
+	if err != nil {
+		httpx.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpx.WriteError(w, http.StatusInternalServerError, fmt.Errorf("streaming unsupported"))
+		return
+	}
+
+	c, err := f.connect(flt)
+	if err != nil {
+		httpx.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer c.close()
+	defer f.track(r)()
+
+	streamSSE(w, flusher, r, c)
+}
+
+// Writes the client's frames as events until the request ends or the client
+// falls behind
+func streamSSE(w http.ResponseWriter, flusher http.Flusher, r *http.Request, c *client) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-c.slow:
+			metrics.LiveSlowConsumers.WithLabelValues("sse").Inc()
+			fmt.Fprintf(w, "event: error\ndata: {\"error\":%q}\n\n", errSlowConsumer.Error())
+			flusher.Flush()
+			return
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case fr := <-c.out:
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", fr.Type, fr.Data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
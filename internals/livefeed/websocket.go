@@ -0,0 +1,68 @@
+package livefeed
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/knightfall22/Phylax/internals/httpx"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/metrics"
+)
+
+// A client that cannot take a frame within this time is disconnected
+const writeTimeout = 5 * time.Second
+
+func (f *Feed) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	flt, err := parseFilter(r)
+	if err != nil {
+		httpx.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	c, err := f.connect(flt)
+	if err != nil {
+		httpx.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer c.close()
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		f.log.Warn("WebSocket handshake failed", "remote", r.RemoteAddr, logging.Err(err))
+		return
+	}
+	defer conn.CloseNow()
+	defer f.track(r)()
+
+	// The feed is one way. CloseRead handles control frames and cancels
+	// ctx once the client goes away.
+	ctx := conn.CloseRead(r.Context())
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-c.slow:
+			metrics.LiveSlowConsumers.WithLabelValues("websocket").Inc()
+			conn.Close(websocket.StatusPolicyViolation, errSlowConsumer.Error())
+			return
+
+		case fr := <-c.out:
+			data, err := json.Marshal(fr)
+			if err != nil {
+				continue
+			}
+
+			writeCtx, cancel := context.WithTimeout(ctx, writeTimeout)
+			err = conn.Write(writeCtx, websocket.MessageText, data)
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
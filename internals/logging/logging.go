@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Attribute keys shared by every component so logs can be filtered the
+// same way everywhere
+const (
+	KeyComponent = "component"
+	KeyWorker    = "worker"
+	KeyZone      = "zone"
+	KeySensor    = "sensor_id"
+	KeyBatchSize = "batch_size"
+	KeyDuration  = "duration"
+	KeyError     = "error"
+)
+
+type Options struct {
+	// text or json. Defaults to text.
+	Format string
+	Level  slog.Level
+	// Defaults to stderr
+	Output io.Writer
+	// Rate limit applied by Sampled loggers
+	Sampling SampleOptions
+}
+
+// Sampling used by Sampled. Set by Setup.
+var sampling = DefaultSampling
+
+// New builds a logger writing opts.Format records at opts.Level or above
+func New(opts Options) (*slog.Logger, error) {
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level}
+	switch strings.ToLower(opts.Format) {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(opts.Output, handlerOpts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(opts.Output, handlerOpts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, want text or json", opts.Format)
+}
+
+// Setup makes a logger built from opts the default. Output of the standard
+// log package is routed through it at info level.
+func Setup(opts Options) error {
+	logger, err := New(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Sampling.Tick > 0 {
+		sampling = opts.Sampling
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// ParseLevel accepts debug, info, warn or error. Empty means info.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return level, nil
+	}
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// Component returns the default logger tagged with the component name.
+// Call it after Setup, the logger does not follow later changes.
+func Component(name string) *slog.Logger {
+	return slog.Default().With(KeyComponent, name)
+}
+
+// Err is the attribute used for errors
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// Fatal logs at error level and exits
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// SampleOptions limits how often the same message is logged. Within each
+// Tick the first First records of a message are logged, then every
+// Thereafter-th.
+type SampleOptions struct {
+	Tick       time.Duration
+	First      int
+	Thereafter int
+}
+
+var DefaultSampling = SampleOptions{
+	Tick:       time.Second,
+	First:      10,
+	Thereafter: 100,
+}
+
+// Sampled wraps logger so that records are rate limited per level and
+// message. Use it for messages logged per reading or per batch.
+func Sampled(logger *slog.Logger) *slog.Logger {
+	return slog.New(&sampleHandler{
+		next: logger.Handler(),
+		s:    &sampler{opts: sampling, counts: make(map[sampleKey]int)},
+	})
+}
+
+type sampleKey struct {
+	level slog.Level
+	msg   string
+}
+
+// Shared by handlers derived with WithAttrs so that the limit applies to
+// the message rather than each attribute set
+type sampler struct {
+	opts SampleOptions
+
+	mu     sync.Mutex
+	window time.Time
+	counts map[sampleKey]int
+}
+
+func (s *sampler) allow(level slog.Level, msg string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.window) >= s.opts.Tick {
+		s.window = now
+		clear(s.counts)
+	}
+
+	key := sampleKey{level, msg}
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.opts.First {
+		return true
+	}
+	return s.opts.Thereafter > 0 && (n-s.opts.First)%s.opts.Thereafter == 0
+}
+
+type sampleHandler struct {
+	next slog.Handler
+	s    *sampler
+}
+
+func (h *sampleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *sampleHandler) Handle(ctx context.Context, r slog.Record) error {
+	if !h.s.allow(r.Level, r.Message, r.Time) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *sampleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sampleHandler{next: h.next.WithAttrs(attrs), s: h.s}
+}
+
+func (h *sampleHandler) WithGroup(name string) slog.Handler {
+	return &sampleHandler{next: h.next.WithGroup(name), s: h.s}
+}
@@ -74,13 +74,219 @@ var DataLag = promauto.NewHistogramVec(
 	[]string{"zone"},
 )
 
+var AlertsFiring = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "phylax_alerts_firing",
+		Help: "Number of alerts currently firing",
+	},
+	[]string{"rule", "zone"},
+)
+
+var AlertEvents = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "phylax_alert_events_total",
+		Help: "Alert state transitions published to NATS",
+	},
+	[]string{"rule", "state"},
+)
+
+var Notifications = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "phylax_notifications_total",
+		Help: "Alert notification deliveries by channel and status (sent, failed, retried, rate_limited, deferred)",
+	},
+	[]string{"channel", "status"},
+)
+
+var NotificationDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "phylax_notification_duration_seconds",
+		Help:    "Time taken by a single notification delivery attempt",
+		Buckets: []float64{0.05, 0.1, 0.5, 1, 5, 10},
+	},
+	[]string{"channel"},
+)
+
+var SensorsOffline = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "phylax_sensors_offline",
+		Help: "Sensors that missed their expected heartbeats",
+	},
+	[]string{"zone"},
+)
+
+var Anomalies = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "phylax_anomalies_total",
+		Help: "Readings whose z-score exceeded the anomaly limit",
+	},
+	[]string{"zone", "metric"},
+)
+
+var BatteryETA = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "phylax_sensor_battery_eta_hours",
+		Help: "Forecast hours until a sensor's battery is empty",
+	},
+	[]string{"zone", "sensor_id"},
+)
+
+var ReadingsRejected = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "phylax_readings_rejected_total",
+		Help: "Decoded readings rejected or quarantined before batching",
+	},
+	[]string{"reason"},
+)
+
+var SpillBacklogBytes = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "phylax_spill_backlog_bytes",
+		Help: "Bytes of spilled batches waiting to be replayed",
+	},
+)
+
+var SpillSegments = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "phylax_spill_segments",
+		Help: "Spill segment files waiting to be replayed",
+	},
+)
+
+var SpillBatches = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "phylax_spill_batches_total",
+		Help: "Batches spilled to disk and replayed from it",
+	},
+	[]string{"op"},
+)
+
+var SpillReadingsRejected = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "phylax_spill_readings_rejected_total",
+		Help: "Spilled readings the sink rejected permanently on replay, by whether they were dead-lettered or dropped",
+	},
+	[]string{"action"},
+)
+
+var SpillCorruptSegments = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "phylax_spill_corrupt_segments_total",
+		Help: "Spill segments abandoned because of a corrupt record",
+	},
+)
+
+var SinkBreakerState = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "phylax_sink_breaker_state",
+		Help: "Sink circuit breaker state: 0 closed, 1 half-open, 2 open",
+	},
+)
+
+var SinkBreakerTransitions = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "phylax_sink_breaker_transitions_total",
+		Help: "Sink circuit breaker state changes by new state",
+	},
+	[]string{"state"},
+)
+
+// Pipeline health, labeled by processor worker
+var FlushDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "phylax_flush_duration_seconds",
+		Help:    "Time taken to write a batch to the sink",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	},
+	[]string{"worker"},
+)
+
+var FlushFailures = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "phylax_flush_failures_total",
+		Help: "Failed sink writes by kind: transient (retried) or permanent (bad rows)",
+	},
+	[]string{"worker", "kind"},
+)
+
+var WorkerBatchDepth = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "phylax_worker_batch_depth",
+		Help: "Readings a worker holds in its batch waiting to be flushed",
+	},
+	[]string{"worker"},
+)
+
+var Redeliveries = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "phylax_redeliveries_total",
+		Help: "Messages fetched that had been delivered before",
+	},
+	[]string{"worker"},
+)
+
+var ProcessorInflight = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "phylax_processor_inflight",
+		Help: "Messages fetched by the processor and not yet acked or nak'd",
+	},
+)
+
+// JetStream consumer state, polled from consumer info
+var ConsumerPending = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "phylax_consumer_pending",
+		Help: "Messages in the stream not yet delivered to the consumer",
+	},
+	[]string{"consumer"},
+)
+
+var ConsumerAckPending = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "phylax_consumer_ack_pending",
+		Help: "Messages delivered to the consumer and not yet acked",
+	},
+	[]string{"consumer"},
+)
+
+var ConsumerRedelivered = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "phylax_consumer_redelivered",
+		Help: "Messages pending ack that have been delivered more than once",
+	},
+	[]string{"consumer"},
+)
+
+var ConsumerWaiting = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "phylax_consumer_waiting_pulls",
+		Help: "Pull requests waiting on the consumer",
+	},
+	[]string{"consumer"},
+)
+
+var LiveClients = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "phylax_live_clients",
+		Help: "Clients connected to the live feed",
+	},
+	[]string{"transport"},
+)
+
+var LiveSlowConsumers = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "phylax_live_slow_consumer_disconnects_total",
+		Help: "Live feed clients disconnected for falling behind",
+	},
+	[]string{"transport"},
+)
+
 func SetReadingsGauge(reading *pb.SensorReading) {
 	TempHistogram.WithLabelValues(reading.SensorZone).Observe(reading.Temperature)
 	COHistogram.WithLabelValues(reading.SensorZone).Observe(reading.CoLevel)
 	HumidityHistogram.WithLabelValues(reading.SensorZone).Observe(reading.Humidity)
 	BatteryHistogram.WithLabelValues(reading.SensorZone).Observe(reading.BatteryLevel)
 
-	creationTime := time.UnixMilli(reading.Timestamp)
-	lag := time.Since(creationTime).Seconds()
+	lag := time.Since(reading.ReadingTime()).Seconds()
 	DataLag.WithLabelValues(reading.SensorZone).Observe(lag)
 }
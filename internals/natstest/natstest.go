@@ -0,0 +1,41 @@
+// Package natstest runs an embedded NATS server with JetStream for tests
+package natstest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/knightfall22/Phylax/publisher"
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// Run starts a JetStream server for the duration of the test and connects
+// to it the way the app does
+func Run(tb testing.TB) *publisher.NatsPublisher {
+	tb.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  tb.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(10 * time.Second) {
+		tb.Fatal("nats-server not ready")
+	}
+	tb.Cleanup(srv.Shutdown)
+
+	nc, err := publisher.NATSConnect(context.Background(), publisher.NATSConnectionOptions{URL: srv.ClientURL()})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(nc.Close)
+	return nc
+}
@@ -0,0 +1,51 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Channel delivers a rendered message to a single destination
+type Channel interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Delivery failures that retrying will not fix, such as a 4xx response
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func newChannel(cfg ChannelConfig, client *http.Client) (Channel, error) {
+	switch cfg.Type {
+	case ChannelWebhook:
+		return &Webhook{url: cfg.URL, secret: []byte(cfg.Secret), client: client}, nil
+	case ChannelSlack:
+		return &Slack{url: cfg.URL, client: client}, nil
+	case ChannelSMTP:
+		return &SMTP{
+			addr:     cfg.SMTPAddr,
+			username: cfg.Username,
+			password: cfg.Password,
+			from:     cfg.From,
+			to:       cfg.To,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown channel type %q", cfg.Type)
+}
+
+// Treats 5xx and 429 as retryable and any other non-2xx as permanent
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err := fmt.Errorf("unexpected status %s", resp.Status)
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+	return &permanentError{err: err}
+}
@@ -0,0 +1,282 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knightfall22/Phylax/internals/alerting"
+)
+
+var testEvent = alerting.Event{
+	Kind:      alerting.KindRule,
+	Rule:      "co-high",
+	Severity:  "critical",
+	State:     alerting.StateFiring,
+	Scope:     alerting.ScopeZone,
+	Zone:      "office",
+	SensorID:  "sensor-1",
+	Metric:    "co_level",
+	Value:     42,
+	Threshold: 30,
+	StartedAt: time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC),
+	At:        time.Date(2026, 10, 18, 9, 1, 0, 0, time.UTC),
+}
+
+var testMessage = Message{
+	Subject: "[CRITICAL] co-high firing in office",
+	Body:    "Alert co-high is firing\nZone:     office",
+	Event:   testEvent,
+}
+
+type capturedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// Records every request and answers with status
+func captureServer(t *testing.T, status int) (*httptest.Server, <-chan capturedRequest) {
+	t.Helper()
+
+	requests := make(chan capturedRequest, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- capturedRequest{header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+func TestWebhookSignsPayload(t *testing.T) {
+	srv, requests := captureServer(t, http.StatusNoContent)
+	secret := []byte("change-me")
+
+	webhook := &Webhook{url: srv.URL, secret: secret, client: srv.Client()}
+	if err := webhook.Send(context.Background(), testMessage); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	req := <-requests
+	timestamp := req.header.Get(TimestampHeader)
+	if timestamp == "" {
+		t.Fatalf("missing %s header", TimestampHeader)
+	}
+	want := "sha256=" + Sign(secret, timestamp, req.body)
+	if got := req.header.Get(SignatureHeader); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Fatalf("signature = %q, want %q", got, want)
+	}
+	if got := req.header.Get("Content-Type"); got != "application/json" {
+		t.Fatalf("Content-Type = %q", got)
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.Subject != testMessage.Subject || payload.Text != testMessage.Body {
+		t.Fatalf("payload = %q/%q, want %q/%q", payload.Subject, payload.Text, testMessage.Subject, testMessage.Body)
+	}
+	if payload.Event.Rule != testEvent.Rule || payload.Event.State != testEvent.State || payload.Event.Value != testEvent.Value {
+		t.Fatalf("payload event = %+v, want %+v", payload.Event, testEvent)
+	}
+}
+
+func TestWebhookWithoutSecretIsUnsigned(t *testing.T) {
+	srv, requests := captureServer(t, http.StatusOK)
+
+	webhook := &Webhook{url: srv.URL, client: srv.Client()}
+	if err := webhook.Send(context.Background(), testMessage); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	req := <-requests
+	if got := req.header.Get(SignatureHeader); got != "" {
+		t.Fatalf("unexpected signature %q", got)
+	}
+}
+
+func TestWebhookStatusErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusBadGateway, false},
+	} {
+		srv, _ := captureServer(t, tc.status)
+		webhook := &Webhook{url: srv.URL, client: srv.Client()}
+
+		err := webhook.Send(context.Background(), testMessage)
+		if err == nil {
+			t.Fatalf("status %d: expected an error", tc.status)
+		}
+		if _, ok := err.(*permanentError); ok != tc.permanent {
+			t.Fatalf("status %d: permanent = %v, want %v", tc.status, ok, tc.permanent)
+		}
+	}
+}
+
+func TestSlackPayload(t *testing.T) {
+	srv, requests := captureServer(t, http.StatusOK)
+
+	slack := &Slack{url: srv.URL, client: srv.Client()}
+	if err := slack.Send(context.Background(), testMessage); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	req := <-requests
+	var payload map[string]string
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	want := "*" + testMessage.Subject + "*\n```" + testMessage.Body + "```"
+	if payload["text"] != want {
+		t.Fatalf("text = %q, want %q", payload["text"], want)
+	}
+	if len(payload) != 1 {
+		t.Fatalf("unexpected fields in %v", payload)
+	}
+}
+
+type mail struct {
+	from string
+	to   []string
+	data string
+}
+
+// Speaks just enough SMTP for net/smtp.SendMail and records each message
+func smtpStandIn(t *testing.T) (string, <-chan mail) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	mails := make(chan mail, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, mails)
+		}
+	}()
+	return ln.Addr().String(), mails
+}
+
+func serveSMTP(conn net.Conn, mails chan<- mail) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost ESMTP")
+	var m mail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			m = mail{from: strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")}
+			reply("250 OK")
+		case "RCPT":
+			m.to = append(m.to, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			m.data = data.String()
+			mails <- m
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPSendsMail(t *testing.T) {
+	addr, mails := smtpStandIn(t)
+
+	smtp := &SMTP{addr: addr, from: "phylax@example.com", to: []string{"oncall@example.com", "ops@example.com"}}
+	if err := smtp.Send(context.Background(), testMessage); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	m := <-mails
+	if m.from != "phylax@example.com" {
+		t.Fatalf("MAIL FROM = %q", m.from)
+	}
+	if strings.Join(m.to, ",") != "oncall@example.com,ops@example.com" {
+		t.Fatalf("RCPT TO = %v", m.to)
+	}
+	for _, want := range []string{
+		"From: phylax@example.com\r\n",
+		"To: oncall@example.com, ops@example.com\r\n",
+		"Subject: " + testMessage.Subject + "\r\n",
+		"Content-Type: text/plain; charset=UTF-8\r\n",
+		"\r\n\r\nAlert co-high is firing\r\nZone:     office\r\n",
+	} {
+		if !strings.Contains(m.data, want) {
+			t.Fatalf("message missing %q:\n%s", want, m.data)
+		}
+	}
+}
+
+func TestSMTPSubjectCannotAddHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		want    string
+	}{
+		{"plain", "co-high firing in office", "Subject: co-high firing in office\r\n"},
+		{"line breaks", "co-high in office\r\nBcc: victim@example.com", "Subject: co-high in office Bcc: victim@example.com\r\n"},
+		{"bare newline", "co-high in office\nBcc: victim@example.com", "Subject: co-high in office Bcc: victim@example.com\r\n"},
+		{"non-ascii", "co-high in büro", "Subject: =?utf-8?q?co-high_in_b=C3=BCro?=\r\n"},
+	}
+
+	c := &SMTP{from: "phylax@example.com", to: []string{"ops@example.com"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := string(c.message(Message{Subject: tt.subject, Body: "body"}, testEvent.At))
+			headers, _, _ := strings.Cut(msg, "\r\n\r\n")
+			if !strings.Contains(headers+"\r\n", tt.want) {
+				t.Fatalf("headers\n%s\nwant line %q", headers, tt.want)
+			}
+			if strings.Contains(headers, "\r\nBcc:") {
+				t.Fatalf("subject added a header:\n%s", headers)
+			}
+		})
+	}
+}
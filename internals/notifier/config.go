@@ -0,0 +1,115 @@
+package notifier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	ChannelWebhook = "webhook"
+	ChannelSlack   = "slack"
+	ChannelSMTP    = "smtp"
+)
+
+// ChannelConfig describes a single notification channel in the notifier file.
+//
+//	channels:
+//	  - name: ops-webhook
+//	    type: webhook
+//	    url: http://localhost:9000/alerts
+//	    secret: change-me
+//	    rate_per_minute: 30
+//	    severities: [critical]
+type ChannelConfig struct {
+	Name string `mapstructure:"name"`
+	Type string `mapstructure:"type"` // webhook, slack or smtp
+
+	// webhook and slack
+	URL    string `mapstructure:"url"`
+	Secret string `mapstructure:"secret" json:"-"` // HMAC-SHA256 signing key, webhook only
+
+	// smtp
+	SMTPAddr string   `mapstructure:"smtp_addr"` // host:port
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password" json:"-"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
+
+	// Only deliver events with these severities, all when empty
+	Severities []string `mapstructure:"severities"`
+
+	// Overrides the default message templates (text/template over Event)
+	SubjectTemplate string `mapstructure:"subject_template"`
+	BodyTemplate    string `mapstructure:"body_template"`
+
+	RatePerMinute float64       `mapstructure:"rate_per_minute"`
+	Burst         int           `mapstructure:"burst"`
+	Retries       *int          `mapstructure:"retries"` // 3 when unset, 0 disables retries
+	RetryBackoff  time.Duration `mapstructure:"retry_backoff"`
+	Timeout       time.Duration `mapstructure:"timeout"`
+}
+
+// Loads notification channels from a YAML file
+func LoadConfig(path string) ([]ChannelConfig, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var channels []ChannelConfig
+	if err := v.UnmarshalKey("channels", &channels); err != nil {
+		return nil, err
+	}
+
+	for i := range channels {
+		if err := channels[i].validate(); err != nil {
+			return nil, fmt.Errorf("channel %d (%q): %w", i, channels[i].Name, err)
+		}
+	}
+
+	return channels, nil
+}
+
+func (c *ChannelConfig) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	switch c.Type {
+	case ChannelWebhook, ChannelSlack:
+		if c.URL == "" {
+			return fmt.Errorf("url is required")
+		}
+	case ChannelSMTP:
+		if c.SMTPAddr == "" || c.From == "" || len(c.To) == 0 {
+			return fmt.Errorf("smtp_addr, from and to are required")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", c.Type)
+	}
+
+	if c.RatePerMinute <= 0 {
+		c.RatePerMinute = 60
+	}
+	if c.Burst <= 0 {
+		c.Burst = 5
+	}
+	if c.Retries == nil {
+		retries := 3
+		c.Retries = &retries
+	} else if *c.Retries < 0 {
+		return fmt.Errorf("retries must not be negative")
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = 2 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+
+	return nil
+}
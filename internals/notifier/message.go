@@ -0,0 +1,79 @@
+package notifier
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/knightfall22/Phylax/internals/alerting"
+)
+
+const (
+	defaultSubject = `[{{ .Severity | upper }}] {{ .Rule }} {{ .State }} in {{ .Zone }}`
+
+	defaultBody = `Alert {{ .Rule }} is {{ .State }}
+Zone:     {{ .Zone }}
+{{- if .SensorID }}
+Sensor:   {{ .SensorID }}
+{{- end }}
+{{- if .Metric }}
+Metric:   {{ .Metric }} = {{ printf "%.2f" .Value }} (threshold {{ printf "%.2f" .Threshold }})
+{{- end }}
+Since:    {{ .StartedAt.Format "2006-01-02 15:04:05 MST" }}
+{{- with .Reading }}
+Reading:  temperature {{ printf "%.2f" .Temperature }}C, humidity {{ printf "%.2f" .Humidity }}%, CO {{ printf "%.2f" .CoLevel }}ppm, battery {{ printf "%.2f" .BatteryLevel }}%
+{{- end }}`
+)
+
+var funcs = template.FuncMap{
+	"upper": strings.ToUpper,
+}
+
+// Message is a rendered event ready to be delivered
+type Message struct {
+	Subject string
+	Body    string
+	Event   alerting.Event
+}
+
+type templates struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func newTemplates(cfg ChannelConfig) (*templates, error) {
+	subject, body := defaultSubject, defaultBody
+	if cfg.SubjectTemplate != "" {
+		subject = cfg.SubjectTemplate
+	}
+	if cfg.BodyTemplate != "" {
+		body = cfg.BodyTemplate
+	}
+
+	subjectTmpl, err := template.New("subject").Funcs(funcs).Parse(subject)
+	if err != nil {
+		return nil, err
+	}
+	bodyTmpl, err := template.New("body").Funcs(funcs).Parse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &templates{subject: subjectTmpl, body: bodyTmpl}, nil
+}
+
+func (t *templates) render(event alerting.Event) (Message, error) {
+	var subject, body bytes.Buffer
+	if err := t.subject.Execute(&subject, event); err != nil {
+		return Message{}, err
+	}
+	if err := t.body.Execute(&body, event); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: subject.String(),
+		Body:    body.String(),
+		Event:   event,
+	}, nil
+}
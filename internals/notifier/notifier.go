@@ -0,0 +1,265 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/knightfall22/Phylax/internals/alerting"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/metrics"
+	"github.com/nats-io/nats.go/jetstream"
+	"golang.org/x/time/rate"
+)
+
+const ConsumerName = "NOTIFIER"
+
+// Messages that would wait longer than this for the rate limiter are dropped
+// rather than delivered late
+const maxRateLimitWait = time.Minute
+
+// Events waiting for delivery on a single channel. An event is only taken
+// from the stream once every channel it goes to has room for it.
+const channelQueueSize = 64
+
+// How long an event that could not be queued waits before it is redelivered
+const queueFullDelay = 5 * time.Second
+
+type channel struct {
+	cfg       ChannelConfig
+	channel   Channel
+	templates *templates
+	limiter   *rate.Limiter
+	queue     chan alerting.Event
+}
+
+// Notifier consumes alert events from the ALERTS stream and delivers them
+// to the configured channels
+type Notifier struct {
+	js       jetstream.JetStream
+	channels []*channel
+	consumer jetstream.ConsumeContext
+	log      *slog.Logger
+	wg       sync.WaitGroup
+	// Cancels deliveries still running when Stop gives up
+	cancel context.CancelFunc
+	// Set under mu once the queues are closed, so a handler still running
+	// after Stop gave up on the drain does not send on them
+	mu      sync.Mutex
+	stopped bool
+}
+
+func New(js jetstream.JetStream, configs []ChannelConfig) (*Notifier, error) {
+	client := &http.Client{}
+
+	n := &Notifier{js: js, log: logging.Component("notifier")}
+	for _, cfg := range configs {
+		ch, err := newChannel(cfg, client)
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl, err := newTemplates(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("channel %q: %w", cfg.Name, err)
+		}
+
+		n.channels = append(n.channels, &channel{
+			cfg:       cfg,
+			channel:   ch,
+			templates: tmpl,
+			limiter:   rate.NewLimiter(rate.Limit(cfg.RatePerMinute/60), cfg.Burst),
+			queue:     make(chan alerting.Event, channelQueueSize),
+		})
+	}
+
+	return n, nil
+}
+
+func (n *Notifier) Start(ctx context.Context) error {
+	n.startWorkers(ctx)
+
+	consumer, err := n.js.CreateOrUpdateConsumer(ctx, alerting.StreamName, jetstream.ConsumerConfig{
+		Name:          ConsumerName,
+		Durable:       ConsumerName,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		FilterSubject: alerting.SubjectPrefix + ">",
+		// Events are acked as soon as every channel has queued them, so this
+		// only needs to cover the handler
+		AckWait:       30 * time.Second,
+		MaxAckPending: 256,
+	})
+	if err != nil {
+		return err
+	}
+
+	n.consumer, err = consumer.Consume(func(msg jetstream.Msg) {
+		n.handle(ctx, msg)
+	})
+	return err
+}
+
+// Stop drains the consumer and waits for every channel to deliver what is
+// already queued. Once ctx expires, deliveries in progress are cancelled,
+// the rest of the queues is dropped and ctx's error is returned.
+func (n *Notifier) Stop(ctx context.Context) error {
+	if n.consumer != nil {
+		n.consumer.Drain()
+		select {
+		case <-n.consumer.Closed():
+		case <-ctx.Done():
+		}
+	}
+	return n.stopWorkers(ctx)
+}
+
+// Each channel delivers from its own queue, so one that is rate limited or
+// backing off does not hold up the others
+func (n *Notifier) startWorkers(ctx context.Context) {
+	ctx, n.cancel = context.WithCancel(ctx)
+	for _, ch := range n.channels {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			for event := range ch.queue {
+				status := n.deliver(ctx, ch, event)
+				metrics.Notifications.WithLabelValues(ch.cfg.Name, status).Inc()
+			}
+		}()
+	}
+}
+
+func (n *Notifier) stopWorkers(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.stopped {
+		n.stopped = true
+		for _, ch := range n.channels {
+			close(ch.queue)
+		}
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		n.cancel()
+		return nil
+	case <-ctx.Done():
+		// Deliveries give up on a cancelled context, so the workers run
+		// through what is left of their queues without waiting
+		n.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (n *Notifier) handle(ctx context.Context, msg jetstream.Msg) {
+	var event alerting.Event
+	if err := json.Unmarshal(msg.Data(), &event); err != nil {
+		n.log.Error("Invalid alert event", "subject", msg.Subject(), logging.Err(err))
+		msg.Term()
+		return
+	}
+
+	// Left on the stream until every channel has room for it
+	if !n.Notify(event) {
+		msg.NakWithDelay(queueFullDelay)
+		return
+	}
+
+	// Acked once queued. Failed deliveries are not redelivered, since that
+	// would repeat the notification on channels that already succeeded.
+	msg.Ack()
+}
+
+// Notify queues an event on every channel that accepts its severity. If one
+// of them has a full queue the event is queued on none and Notify returns
+// false, so it can be retried without notifying any channel twice. Once the
+// notifier is stopped every event is deferred.
+func (n *Notifier) Notify(event alerting.Event) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return false
+	}
+
+	targets := []*channel{}
+	for _, ch := range n.channels {
+		if len(ch.cfg.Severities) > 0 && !slices.Contains(ch.cfg.Severities, event.Severity) {
+			continue
+		}
+		if len(ch.queue) == cap(ch.queue) {
+			n.log.Warn("Channel queue full, deferring event", "channel", ch.cfg.Name, "rule", event.Rule, "state", event.State)
+			metrics.Notifications.WithLabelValues(ch.cfg.Name, "deferred").Inc()
+			return false
+		}
+		targets = append(targets, ch)
+	}
+
+	// Workers only ever take from the queues and other calls wait on mu, so
+	// there is room
+	for _, ch := range targets {
+		ch.queue <- event
+	}
+	return true
+}
+
+// Renders and sends the event with retries. Returns the delivery status.
+func (n *Notifier) deliver(ctx context.Context, ch *channel, event alerting.Event) string {
+	reservation := ch.limiter.Reserve()
+	if delay := reservation.Delay(); delay > maxRateLimitWait {
+		reservation.Cancel()
+		n.log.Warn("Rate limit exceeded, dropping event", "channel", ch.cfg.Name, "rule", event.Rule, "state", event.State)
+		return "rate_limited"
+	} else if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return "failed"
+		}
+	}
+
+	msg, err := ch.templates.render(event)
+	if err != nil {
+		n.log.Error("Failed to render message", "channel", ch.cfg.Name, logging.Err(err))
+		return "failed"
+	}
+
+	backoff := ch.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		sendCtx, cancel := context.WithTimeout(ctx, ch.cfg.Timeout)
+		err = ch.channel.Send(sendCtx, msg)
+		cancel()
+		metrics.NotificationDuration.WithLabelValues(ch.cfg.Name).Observe(time.Since(start).Seconds())
+
+		if err == nil {
+			return "sent"
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) || attempt >= *ch.cfg.Retries {
+			n.log.Error("Failed to notify channel", "channel", ch.cfg.Name, "attempts", attempt+1, logging.Err(err))
+			return "failed"
+		}
+
+		metrics.Notifications.WithLabelValues(ch.cfg.Name, "retried").Inc()
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return "failed"
+		}
+	}
+}
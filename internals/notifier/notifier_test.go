@@ -0,0 +1,240 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Builds a notifier with running channel workers but no NATS consumer
+func newTestNotifier(t *testing.T, configs ...ChannelConfig) *Notifier {
+	t.Helper()
+
+	for i := range configs {
+		if err := configs[i].validate(); err != nil {
+			t.Fatalf("config %q: %v", configs[i].Name, err)
+		}
+	}
+
+	n, err := New(nil, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n.startWorkers(ctx)
+	t.Cleanup(func() {
+		cancel()
+		n.stopWorkers(context.Background())
+	})
+	return n
+}
+
+func TestNotifySlowChannelDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+
+	fast, requests := captureServer(t, http.StatusOK)
+
+	n := newTestNotifier(t,
+		ChannelConfig{Name: "slow", Type: ChannelWebhook, URL: slow.URL},
+		ChannelConfig{Name: "fast", Type: ChannelWebhook, URL: fast.URL},
+	)
+
+	n.Notify(testEvent)
+	n.Notify(testEvent)
+
+	for range 2 {
+		select {
+		case <-requests:
+		case <-time.After(5 * time.Second):
+			t.Fatal("fast channel waited on the slow one")
+		}
+	}
+}
+
+func TestNotifyFiltersSeverity(t *testing.T) {
+	srv, requests := captureServer(t, http.StatusOK)
+
+	n := newTestNotifier(t, ChannelConfig{
+		Name: "warnings", Type: ChannelWebhook, URL: srv.URL, Severities: []string{"warning"},
+	})
+
+	n.Notify(testEvent) // critical
+	warning := testEvent
+	warning.Severity = "warning"
+	n.Notify(warning)
+
+	select {
+	case <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("warning was not delivered")
+	}
+	select {
+	case <-requests:
+		t.Fatal("critical event was delivered to a warning-only channel")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		retries  *int
+		attempts int32
+	}{
+		{"unset", nil, 4},
+		{"zero", new(int), 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			t.Cleanup(srv.Close)
+
+			cfg := ChannelConfig{
+				Name: "flaky", Type: ChannelWebhook, URL: srv.URL,
+				Retries: tc.retries, RetryBackoff: time.Millisecond,
+			}
+			if err := cfg.validate(); err != nil {
+				t.Fatal(err)
+			}
+			n, err := New(nil, []ChannelConfig{cfg})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if status := n.deliver(context.Background(), n.channels[0], testEvent); status != "failed" {
+				t.Fatalf("status = %q, want failed", status)
+			}
+			if got := attempts.Load(); got != tc.attempts {
+				t.Fatalf("attempts = %d, want %d", got, tc.attempts)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsNegativeRetries(t *testing.T) {
+	retries := -1
+	cfg := ChannelConfig{Name: "bad", Type: ChannelWebhook, URL: "http://localhost", Retries: &retries}
+	if err := cfg.validate(); err == nil {
+		t.Fatal("expected an error for negative retries")
+	}
+}
+
+func TestNotifyDefersWhenAnyQueueIsFull(t *testing.T) {
+	n, err := New(nil, []ChannelConfig{
+		{Name: "idle", Type: ChannelWebhook, URL: "http://localhost"},
+		{Name: "busy", Type: ChannelWebhook, URL: "http://localhost"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No workers, so nothing is taken off the queues
+	idle, busy := n.channels[0], n.channels[1]
+	for range cap(busy.queue) {
+		busy.queue <- testEvent
+	}
+
+	if n.Notify(testEvent) {
+		t.Fatal("Notify queued an event although a channel queue was full")
+	}
+	if len(idle.queue) != 0 {
+		t.Fatalf("event queued on %d other channels, want none so a retry does not repeat it", len(idle.queue))
+	}
+
+	<-busy.queue
+	if !n.Notify(testEvent) {
+		t.Fatal("Notify deferred an event although every queue had room")
+	}
+	if len(idle.queue) != 1 || len(busy.queue) != cap(busy.queue) {
+		t.Fatalf("queues hold %d and %d events, want 1 and %d", len(idle.queue), len(busy.queue), cap(busy.queue))
+	}
+}
+
+func TestStopGivesUpAtDeadline(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+
+	cfg := ChannelConfig{Name: "slow", Type: ChannelWebhook, URL: slow.URL, Timeout: time.Hour}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	n, err := New(nil, []ChannelConfig{cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.startWorkers(context.Background())
+	for range 3 {
+		n.Notify(testEvent)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if err := n.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Stop = %v, want the deadline error", err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("Stop took %s past a 100ms deadline", elapsed)
+	}
+}
+
+func TestStopWhileEventsArrive(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+
+	cfg := ChannelConfig{Name: "webhook", Type: ChannelWebhook, URL: srv.URL}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	n, err := New(nil, []ChannelConfig{cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.startWorkers(context.Background())
+
+	// Stands in for a consume handler the drain has not stopped yet
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				n.Notify(testEvent)
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := n.Stop(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatalf("Stop = %v", err)
+	}
+
+	if n.Notify(testEvent) {
+		t.Fatal("Notify queued an event after Stop")
+	}
+	close(stop)
+	<-done
+}
@@ -0,0 +1,40 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// Slack posts to an incoming webhook. The {"text": ...} payload is also
+// accepted by Microsoft Teams and Mattermost incoming webhooks.
+type Slack struct {
+	url    string
+	client *http.Client
+}
+
+func (c *Slack) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(map[string]string{
+		"text": "*" + msg.Subject + "*\n```" + msg.Body + "```",
+	})
+	if err != nil {
+		return &permanentError{err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return checkResponse(resp)
+}
@@ -0,0 +1,66 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP sends plain text email. Authentication is only used when a username
+// is configured, so a local stand-in such as MailHog works without it.
+type SMTP struct {
+	addr     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (c *SMTP) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if c.username != "" {
+		host, _, err := net.SplitHostPort(c.addr)
+		if err != nil {
+			return &permanentError{err: err}
+		}
+		auth = smtp.PlainAuth("", c.username, c.password, host)
+	}
+
+	body := c.message(msg, time.Now())
+
+	// net/smtp has no context support, run it aside so ctx still bounds the call
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(c.addr, auth, c.from, c.to, body)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Builds the email. The subject is rendered from zone and sensor ids taken
+// from reading payloads, so line breaks are removed to keep it from adding
+// headers, and anything outside printable ASCII is Q-encoded.
+func (c *SMTP) message(msg Message, now time.Time) []byte {
+	subject := strings.Join(strings.FieldsFunc(msg.Subject, func(r rune) bool { return r == '\r' || r == '\n' }), " ")
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", c.from)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(c.to, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&body, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	body.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	body.WriteString("\r\n")
+	return body.Bytes()
+}
@@ -0,0 +1,78 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/knightfall22/Phylax/internals/alerting"
+)
+
+const (
+	// Hex encoded HMAC-SHA256 of "<timestamp>.<body>", prefixed with "sha256="
+	SignatureHeader = "X-Phylax-Signature"
+	// Unix seconds at signing time, lets receivers reject replays
+	TimestampHeader = "X-Phylax-Timestamp"
+)
+
+type webhookPayload struct {
+	Subject string         `json:"subject"`
+	Text    string         `json:"text"`
+	Event   alerting.Event `json:"event"`
+}
+
+// Webhook POSTs the event as JSON to an arbitrary HTTP endpoint
+type Webhook struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func (c *Webhook) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(webhookPayload{
+		Subject: msg.Subject,
+		Text:    msg.Body,
+		Event:   msg.Event,
+	})
+	if err != nil {
+		return &permanentError{err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(c.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, "sha256="+Sign(c.secret, timestamp, body))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return checkResponse(resp)
+}
+
+// Sign computes the webhook signature. Receivers recompute it from the
+// X-Phylax-Timestamp header and raw body and compare with hmac.Equal.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
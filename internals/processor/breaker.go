@@ -0,0 +1,224 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/knightfall22/Phylax/internals/metrics"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerHalfOpen:
+		return "half_open"
+	case BreakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+type BreakerOptions struct {
+	// Consecutive transient sink failures that open the breaker
+	Threshold int
+	// How long the breaker stays open before a trial flush
+	Cooldown time.Duration
+	// Each failed trial doubles the cooldown up to this
+	MaxCooldown time.Duration
+}
+
+// Value of prober while no worker holds the probe
+const noProber = -1
+
+var DefaultBreakerOptions = BreakerOptions{
+	Threshold:   5,
+	Cooldown:    5 * time.Second,
+	MaxCooldown: time.Minute,
+}
+
+// Guards the sink. While open, workers stop fetching and keep batches they
+// still hold without touching the sink. Once the cooldown passes a
+// single worker becomes the prober: it fetches and flushes one batch, and
+// the outcome closes the breaker or opens it again for longer.
+type breaker struct {
+	opts BreakerOptions
+	log  *slog.Logger
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	cooldown  time.Duration
+	openUntil time.Time
+	prober    int
+}
+
+func newBreaker(opts BreakerOptions, logger *slog.Logger) *breaker {
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultBreakerOptions.Threshold
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = DefaultBreakerOptions.Cooldown
+	}
+	if opts.MaxCooldown < opts.Cooldown {
+		opts.MaxCooldown = max(opts.Cooldown, DefaultBreakerOptions.MaxCooldown)
+	}
+
+	metrics.SinkBreakerState.Set(float64(BreakerClosed))
+	return &breaker{opts: opts, log: logger, cooldown: opts.Cooldown, prober: noProber}
+}
+
+// Blocks while worker may not fetch: while the breaker is open, or half
+// open with another worker probing. Returns false if stop or ctx ended the
+// wait.
+func (b *breaker) wait(ctx context.Context, stop <-chan struct{}, worker int) bool {
+	for {
+		b.mu.Lock()
+		var delay time.Duration
+		switch b.state {
+		case BreakerClosed:
+			b.mu.Unlock()
+			return true
+
+		case BreakerOpen:
+			delay = time.Until(b.openUntil)
+			if delay <= 0 {
+				b.setState(BreakerHalfOpen)
+				b.prober = worker
+				b.mu.Unlock()
+				return true
+			}
+
+		case BreakerHalfOpen:
+			if b.prober == worker || b.prober == noProber {
+				b.prober = worker
+				b.mu.Unlock()
+				return true
+			}
+			delay = 100 * time.Millisecond
+		}
+		b.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return false
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+// How long until writes may go through again: the rest of the cooldown while
+// open, or the cooldown a failing probe would reopen for
+func (b *breaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if wait := time.Until(b.openUntil); b.state == BreakerOpen && wait > 0 {
+		return wait
+	}
+	return b.cooldown
+}
+
+// Frees the probe if worker holds it without having recorded an outcome,
+// so that a waiting worker takes it over
+func (b *breaker) release(worker int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen && b.prober == worker {
+		b.prober = noProber
+	}
+}
+
+// Reports whether worker may write to the sink
+func (b *breaker) allow(worker int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state == BreakerClosed || (b.state == BreakerHalfOpen && b.prober == worker)
+}
+
+// Records the outcome of a write. healthy is false for transient failures
+// only; a batch rejected for bad data still shows the sink is reachable.
+func (b *breaker) record(healthy bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		if healthy {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.opts.Threshold {
+			b.open(b.opts.Cooldown)
+		}
+
+	case BreakerHalfOpen:
+		if healthy {
+			b.failures = 0
+			b.cooldown = b.opts.Cooldown
+			b.setState(BreakerClosed)
+			return
+		}
+		b.open(min(b.cooldown*2, b.opts.MaxCooldown))
+	}
+}
+
+// Must be called with the lock held
+func (b *breaker) open(cooldown time.Duration) {
+	b.cooldown = cooldown
+	b.openUntil = time.Now().Add(cooldown)
+	b.setState(BreakerOpen)
+}
+
+// Must be called with the lock held
+func (b *breaker) setState(state BreakerState) {
+	if state != BreakerHalfOpen {
+		b.prober = noProber
+	}
+
+	switch state {
+	case BreakerOpen:
+		b.log.Warn("Sink circuit breaker open, pausing consumption", "cooldown", b.cooldown, "failures", b.failures)
+	case BreakerClosed:
+		b.log.Info("Sink circuit breaker closed, resuming consumption")
+	}
+
+	b.state = state
+	metrics.SinkBreakerState.Set(float64(state))
+	metrics.SinkBreakerTransitions.WithLabelValues(state.String()).Inc()
+}
+
+// BreakerStatus is a snapshot of the sink circuit breaker
+type BreakerStatus struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"consecutive_failures"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+}
+
+func (b *breaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := BreakerStatus{State: b.state.String(), Failures: b.failures}
+	if b.state == BreakerOpen {
+		until := b.openUntil
+		s.OpenUntil = &until
+	}
+	return s
+}
@@ -0,0 +1,238 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/sink"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Records how a message was settled
+type trackedMsg struct {
+	*fakeMsg
+
+	mu         sync.Mutex
+	acked      bool
+	naks       []time.Duration
+	inProgress int
+}
+
+func newTrackedMsg(numDelivered uint64) *trackedMsg {
+	return &trackedMsg{fakeMsg: &fakeMsg{
+		meta: &jetstream.MsgMetadata{NumDelivered: numDelivered, Timestamp: time.Now()},
+	}}
+}
+
+func (m *trackedMsg) Ack() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.acked = true
+	return nil
+}
+
+func (m *trackedMsg) Nak() error { return m.NakWithDelay(0) }
+
+func (m *trackedMsg) NakWithDelay(delay time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.naks = append(m.naks, delay)
+	return nil
+}
+
+func (m *trackedMsg) InProgress() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inProgress++
+	return nil
+}
+
+func (m *trackedMsg) settled() (acked bool, naks int, inProgress int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.acked, len(m.naks), m.inProgress
+}
+
+type recordingDeadLetter struct {
+	mu      sync.Mutex
+	reasons []string
+}
+
+func (d *recordingDeadLetter) Publish(ctx context.Context, msg jetstream.Msg, reason string, worker int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reasons = append(d.reasons, reason)
+	return nil
+}
+
+func (d *recordingDeadLetter) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.reasons)
+}
+
+// Fails every write with a transient error, after delay
+type downSink struct {
+	delay time.Duration
+
+	mu     sync.Mutex
+	writes int
+}
+
+func (s *downSink) Write(ctx context.Context, readings []*pb.SensorReading) error {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	return errors.New("connection refused")
+}
+
+func (s *downSink) Close() error { return nil }
+
+func (s *downSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writes
+}
+
+func TestBreakerRefusal(t *testing.T) {
+	const maxDeliver = 3
+	tests := []struct {
+		name         string
+		numDelivered uint64
+		deadLettered bool
+	}{
+		{"first delivery", 1, false},
+		{"before last delivery", maxDeliver - 1, false},
+		// The server would never deliver it again
+		{"last delivery", maxDeliver, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dlq := &recordingDeadLetter{}
+			down := &downSink{}
+			p := NewProcessor(context.Background(), Options{
+				Source:     &fakeSource{},
+				Sink:       down,
+				DeadLetter: dlq,
+				Retry:      RetryPolicy{MaxDeliver: maxDeliver, BaseDelay: time.Second, MaxDelay: time.Minute},
+				Breaker:    BreakerOptions{Threshold: 1, Cooldown: time.Hour, MaxCooldown: time.Hour},
+				Workers:    1,
+			})
+			p.breaker.record(false)
+
+			msg := newTrackedMsg(tt.numDelivered)
+			p.inflight.Add(1)
+			p.flushBatch(context.Background(), []*batchItem{{data: &pb.SensorReading{}, msg: msg}}, 0)
+
+			if n := down.count(); n != 0 {
+				t.Fatalf("sink written %d times while the breaker was open", n)
+			}
+			if tt.deadLettered {
+				if dlq.count() != 1 || !msg.acked || len(msg.naks) != 0 {
+					t.Fatalf("dead-lettered=%d acked=%v naks=%v, want dead-lettered and acked",
+						dlq.count(), msg.acked, msg.naks)
+				}
+				return
+			}
+			if dlq.count() != 0 || msg.acked || len(msg.naks) != 1 {
+				t.Fatalf("dead-lettered=%d acked=%v naks=%v, want a single nak",
+					dlq.count(), msg.acked, msg.naks)
+			}
+			if delay := msg.naks[0]; delay < 59*time.Minute {
+				t.Fatalf("nak'd with %s, want the remaining open time", delay)
+			}
+		})
+	}
+}
+
+func TestHeldBatchIsFlushedOnceBreakerCloses(t *testing.T) {
+	written := &countingSink{Memory: sink.NewMemory(), total: 1, done: make(chan struct{})}
+	p := NewProcessor(context.Background(), Options{
+		Source:        &fakeSource{},
+		Sink:          written,
+		DeadLetter:    &recordingDeadLetter{},
+		Breaker:       BreakerOptions{Threshold: 1, Cooldown: 200 * time.Millisecond, MaxCooldown: time.Second},
+		FlushInterval: 20 * time.Millisecond,
+		Workers:       1,
+	})
+	p.breaker.record(false)
+
+	msg := newTrackedMsg(1)
+	p.inflight.Add(1)
+	batch := []*batchItem{{data: &pb.SensorReading{}, msg: msg}}
+	if !p.holdBatch(context.Background(), batch, 0) {
+		t.Fatal("hold ended before the breaker let the worker through")
+	}
+	if _, naks, inProgress := msg.settled(); naks != 0 || inProgress == 0 {
+		t.Fatalf("held message nak'd %d times and marked in progress %d times, want only in progress", naks, inProgress)
+	}
+
+	// The held batch is the probe that closes the breaker
+	p.flushBatch(context.Background(), batch, 0)
+	if acked, _, _ := msg.settled(); !acked {
+		t.Fatal("held message not acked after the breaker let it through")
+	}
+	if state := p.breaker.status().State; state != BreakerClosed.String() {
+		t.Fatalf("breaker %s after a successful probe, want closed", state)
+	}
+}
+
+func TestHoldEndsOnStop(t *testing.T) {
+	p := NewProcessor(context.Background(), Options{
+		Source:        &fakeSource{},
+		Sink:          &downSink{},
+		Breaker:       BreakerOptions{Threshold: 1, Cooldown: time.Hour, MaxCooldown: time.Hour},
+		FlushInterval: 20 * time.Millisecond,
+		Workers:       1,
+	})
+	p.breaker.record(false)
+
+	time.AfterFunc(50*time.Millisecond, func() { close(p.stopping) })
+	batch := []*batchItem{{data: &pb.SensorReading{}, msg: newTrackedMsg(1)}}
+	if p.holdBatch(context.Background(), batch, 0) {
+		t.Fatal("hold let the worker through while the breaker was open")
+	}
+}
+
+func TestWorkerStopsFetchingWhileBreakerOpen(t *testing.T) {
+	// One full batch to open the breaker, and a partial one left behind
+	src := &fakeSource{payload: []byte{}, remaining: 150}
+	p := NewProcessor(context.Background(), Options{
+		Source: src,
+		// Slow to fail, so the other worker takes the partial batch before the breaker opens
+		Sink:                 &downSink{delay: 100 * time.Millisecond},
+		DeadLetter:           &recordingDeadLetter{},
+		Breaker:              BreakerOptions{Threshold: 1, Cooldown: time.Hour, MaxCooldown: time.Hour},
+		BatchSize:            100,
+		FlushInterval:        time.Second, // Partial batches are held past the check below
+		Workers:              2,
+		ConsumerPollInterval: time.Hour,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.Start(ctx)
+	defer p.Stop(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.breaker.status().State != BreakerOpen.String() {
+		if time.Now().After(deadline) {
+			t.Fatal("breaker did not open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Let workers holding a partial batch reach the breaker check
+	time.Sleep(100 * time.Millisecond)
+	before := src.fetches.Load()
+	time.Sleep(300 * time.Millisecond)
+	if after := src.fetches.Load(); after != before {
+		t.Fatalf("workers fetched %d times while the breaker was open", after-before)
+	}
+}
@@ -0,0 +1,106 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/sink"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Hands out the same payload until remaining runs out, then behaves like
+// an idle stream
+type fakeSource struct {
+	jetstream.Consumer
+
+	payload   []byte
+	remaining int64
+	fetches   atomic.Int64
+}
+
+func (s *fakeSource) take(n int) int {
+	for {
+		left := atomic.LoadInt64(&s.remaining)
+		if left <= 0 {
+			return 0
+		}
+		n := min(int64(n), left)
+		if atomic.CompareAndSwapInt64(&s.remaining, left, left-n) {
+			return int(n)
+		}
+	}
+}
+
+func (s *fakeSource) Fetch(batch int, opts ...jetstream.FetchOpt) (jetstream.MessageBatch, error) {
+	s.fetches.Add(1)
+	n := s.take(batch)
+	ch := make(chan jetstream.Msg, n)
+	for range n {
+		ch <- s.newMsg()
+	}
+	close(ch)
+
+	if n == 0 {
+		// An idle fetch waits on the server
+		time.Sleep(time.Millisecond)
+	}
+	return &fakeBatch{msgs: ch}, nil
+}
+
+func (s *fakeSource) Info(ctx context.Context) (*jetstream.ConsumerInfo, error) {
+	return &jetstream.ConsumerInfo{Name: "fake"}, nil
+}
+
+func (s *fakeSource) newMsg() *fakeMsg {
+	return &fakeMsg{
+		data: s.payload,
+		meta: &jetstream.MsgMetadata{NumDelivered: 1, Timestamp: time.Now()},
+	}
+}
+
+type fakeBatch struct {
+	msgs chan jetstream.Msg
+}
+
+func (b *fakeBatch) Messages() <-chan jetstream.Msg { return b.msgs }
+func (b *fakeBatch) Error() error                   { return nil }
+
+type fakeMsg struct {
+	jetstream.Msg
+
+	data []byte
+	meta *jetstream.MsgMetadata
+}
+
+func (m *fakeMsg) Data() []byte                              { return m.data }
+func (m *fakeMsg) Subject() string                           { return "sensors.office.sensor-1" }
+func (m *fakeMsg) Headers() nats.Header                      { return nil }
+func (m *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) { return m.meta, nil }
+func (m *fakeMsg) Ack() error                                { return nil }
+func (m *fakeMsg) Nak() error                                { return nil }
+func (m *fakeMsg) NakWithDelay(delay time.Duration) error    { return nil }
+func (m *fakeMsg) InProgress() error                         { return nil }
+
+// Counts readings written to the memory sink and signals once total arrived
+type countingSink struct {
+	*sink.Memory
+
+	total   int64
+	written atomic.Int64
+	once    sync.Once
+	done    chan struct{}
+}
+
+func (s *countingSink) Write(ctx context.Context, readings []*pb.SensorReading) error {
+	if err := s.Memory.Write(ctx, readings); err != nil {
+		return err
+	}
+	if s.written.Add(int64(len(readings))) >= s.total {
+		s.once.Do(func() { close(s.done) })
+	}
+	return nil
+}
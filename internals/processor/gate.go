@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/metrics"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// What happens to a reading that was decoded successfully
+type Verdict int
+
+const (
+	Accept Verdict = iota
+	// Acked and dropped
+	Reject
+	// Stored in quarantined_readings, or dead-lettered when no quarantine
+	// store is configured
+	Quarantine
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Reject:
+		return "reject"
+	case Quarantine:
+		return "quarantine"
+	}
+	return "accept"
+}
+
+// Gate decides whether a decoded reading enters the pipeline. Gates run
+// after transforms and before observers, in order, and the first verdict
+// other than Accept wins. The reason is recorded as the metric label and
+// quarantine reason, so it should be a short fixed code.
+// Admit is called concurrently from all workers and must not block.
+type Gate interface {
+	Admit(reading *pb.SensorReading) (Verdict, string)
+}
+
+// Preview calibrates reading in place and reports whether every gate
+// accepts it, without disposing of anything. Live streams use it to send
+// the readings the processor stores rather than the raw payloads.
+func Preview(reading *pb.SensorReading, transforms []Transformer, gates []Gate) bool {
+	for _, t := range transforms {
+		t.Transform(reading)
+	}
+	for _, g := range gates {
+		if verdict, _ := g.Admit(reading); verdict != Accept {
+			return false
+		}
+	}
+	return true
+}
+
+// Runs the gates and disposes of a refused message. Returns false if the
+// reading must not be batched.
+func (p *Processor) admit(ctx context.Context, msg jetstream.Msg, reading *pb.SensorReading, worker int) bool {
+	for _, g := range p.gates {
+		verdict, reason := g.Admit(reading)
+		switch verdict {
+		case Accept:
+			continue
+		case Reject:
+			metrics.ReadingsRejected.WithLabelValues(reason).Inc()
+			p.ack(msg)
+		case Quarantine:
+			metrics.ReadingsRejected.WithLabelValues(reason).Inc()
+			p.moveToQuarantine(ctx, msg, reading, reason, worker)
+		default:
+			p.hot.Error("Unknown verdict", "verdict", int(verdict), logging.KeySensor, reading.SensorId)
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// Stores a refused reading in quarantine and acks it. If the store is
+// unavailable the message is nak'd so it is validated again on redelivery.
+func (p *Processor) moveToQuarantine(ctx context.Context, msg jetstream.Msg, reading *pb.SensorReading, reason string, worker int) {
+	if p.quarantine == nil {
+		p.moveToDeadLetter(ctx, msg, reason, worker)
+		return
+	}
+
+	if err := p.quarantine.Add(ctx, msg.Subject(), msg.Data(), reading, reason); err != nil {
+		p.hot.Error("Failed to quarantine reading", logging.KeyWorker, worker,
+			logging.KeySensor, reading.SensorId, logging.KeyZone, reading.SensorZone, logging.Err(err))
+
+		var delay time.Duration
+		if meta, err := msg.Metadata(); err == nil {
+			delay = p.retry.delay(meta.NumDelivered)
+		}
+		p.nak(msg, delay)
+		return
+	}
+
+	p.ack(msg)
+}
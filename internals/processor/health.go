@@ -0,0 +1,67 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// Default for Options.StallTimeout
+	DefaultStallTimeout = 2 * time.Minute
+	// Share of MaxAckPending in flight at which the processor reports it is
+	// saturated
+	saturationRatio = 0.9
+)
+
+// CheckWorkers fails when a worker goroutine has exited or has been stuck,
+// e.g. in a sink write, for longer than the stall timeout. Workers waiting
+// on an open breaker are not stalled.
+func (p *Processor) CheckWorkers(ctx context.Context) error {
+	if n := p.running.Load(); n != int64(p.workerN) {
+		return fmt.Errorf("%d of %d workers running", n, p.workerN)
+	}
+
+	if p.breaker.status().State != BreakerClosed.String() {
+		return nil
+	}
+
+	var stalled []error
+	for i := range p.beats {
+		since := time.Since(time.Unix(0, p.beats[i].Load()))
+		if since > p.stallTimeout {
+			stalled = append(stalled, fmt.Errorf("worker %d stalled for %s", i, since.Round(time.Second)))
+		}
+	}
+	return errors.Join(stalled...)
+}
+
+// Stalled reports whether readings are held up before reaching the sink:
+// the breaker is not closed or the consumer is lagging
+func (p *Processor) Stalled() bool {
+	return p.breaker.status().State != BreakerClosed.String() || p.pending.Load() > p.lagThreshold
+}
+
+// CheckBreaker fails while the sink circuit breaker is not closed
+func (p *Processor) CheckBreaker(ctx context.Context) error {
+	if status := p.breaker.status(); status.State != BreakerClosed.String() {
+		return fmt.Errorf("sink circuit breaker %s", status.State)
+	}
+	return nil
+}
+
+// CheckBacklog fails when the consumer is close to MaxAckPending, at which
+// point the server stops handing out messages
+func (p *Processor) CheckBacklog(ctx context.Context) error {
+	info, err := p.source.Info(ctx)
+	if err != nil {
+		return err
+	}
+
+	limit := info.Config.MaxAckPending
+	if limit > 0 && float64(info.NumAckPending) >= saturationRatio*float64(limit) {
+		return fmt.Errorf("%d of %d messages pending ack", info.NumAckPending, limit)
+	}
+	return nil
+}
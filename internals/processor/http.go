@@ -0,0 +1,29 @@
+package processor
+
+import (
+	"net/http"
+
+	"github.com/knightfall22/Phylax/internals/httpx"
+)
+
+// Breaker reports the state of the sink circuit breaker
+func (p *Processor) Breaker() BreakerStatus {
+	return p.breaker.status()
+}
+
+// RegisterRoutes exposes processor health:
+//
+//	GET /health/sink   sink circuit breaker state, 503 while not closed
+func (p *Processor) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /health/sink", p.handleSinkHealth)
+}
+
+func (p *Processor) handleSinkHealth(w http.ResponseWriter, r *http.Request) {
+	status := p.Breaker()
+
+	code := http.StatusOK
+	if status.State != BreakerClosed.String() {
+		code = http.StatusServiceUnavailable
+	}
+	httpx.WriteJSON(w, code, status)
+}
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Defaults for Options.ConsumerPollInterval and Options.LagThreshold
+const (
+	DefaultConsumerPollInterval = 15 * time.Second
+	DefaultLagThreshold         = 50000
+)
+
+// Metrics of one worker with the worker label already applied
+type workerMetrics struct {
+	flushDuration prometheus.Observer
+	transient     prometheus.Counter
+	permanent     prometheus.Counter
+	depth         prometheus.Gauge
+	redeliveries  prometheus.Counter
+}
+
+func newWorkerMetrics(worker int) workerMetrics {
+	label := strconv.Itoa(worker)
+	return workerMetrics{
+		flushDuration: metrics.FlushDuration.WithLabelValues(label),
+		transient:     metrics.FlushFailures.WithLabelValues(label, "transient"),
+		permanent:     metrics.FlushFailures.WithLabelValues(label, "permanent"),
+		depth:         metrics.WorkerBatchDepth.WithLabelValues(label),
+		redeliveries:  metrics.Redeliveries.WithLabelValues(label),
+	}
+}
+
+// Polls the consumer info into the consumer gauges until Stop is called
+func (p *Processor) pollConsumer(ctx context.Context) {
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		p.collectConsumer(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-p.stopping:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Processor) collectConsumer(ctx context.Context) {
+	metrics.ProcessorInflight.Set(float64(p.inflight.Load()))
+
+	ctx, cancel := context.WithTimeout(ctx, p.pollInterval)
+	defer cancel()
+
+	info, err := p.source.Info(ctx)
+	if err != nil {
+		p.log.Error("Failed to fetch consumer info", logging.Err(err))
+		return
+	}
+
+	p.pending.Store(int64(info.NumPending))
+
+	name := info.Name
+	metrics.ConsumerPending.WithLabelValues(name).Set(float64(info.NumPending))
+	metrics.ConsumerAckPending.WithLabelValues(name).Set(float64(info.NumAckPending))
+	metrics.ConsumerRedelivered.WithLabelValues(name).Set(float64(info.NumRedelivered))
+	metrics.ConsumerWaiting.WithLabelValues(name).Set(float64(info.NumWaiting))
+}
@@ -2,148 +2,528 @@ package processor
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/logging"
 	"github.com/knightfall22/Phylax/internals/metrics"
+	"github.com/knightfall22/Phylax/internals/quarantine"
+	"github.com/knightfall22/Phylax/internals/sink"
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 )
 
+// Defaults for settings left zero in Options
 const (
-	BatchSize    = 1500
-	FlusInterval = 1
+	DefaultBatchSize     = 1500
+	DefaultFlushInterval = time.Second
 )
 
-var workerCount = runtime.NumCPU()
+const (
+	// A batch whose flush deadline is this close is flushed rather than
+	// waiting on another fetch
+	minFetchWait = 10 * time.Millisecond
+	// Pause before fetching again after a failed fetch
+	fetchRetryDelay = time.Second
+)
+
+var DefaultWorkers = runtime.NumCPU()
 
 type batchItem struct {
 	data *pb.SensorReading
 	msg  jetstream.Msg
+
+	// Residence span, ended when the batch is flushed
+	span        trace.Span
+	spanContext trace.SpanContext
 }
 type Processor struct {
-	input  chan jetstream.Msg
-	dbPool *pgxpool.Pool
+	source     jetstream.Consumer
+	sink       sink.Sink
+	deadLetter DeadLetter
+	quarantine *quarantine.Store
+	retry      RetryPolicy
+	observers  []Observer
+	gates      []Gate
+	transforms []Transformer
+	workerN    int
+	breaker    *breaker
+
+	// Adjustable at runtime with Tune
+	batchSize     atomic.Int64
+	flushInterval atomic.Int64
+
+	// Closed by Stop to tell workers to flush and exit
+	stopping chan struct{}
+	stopOnce sync.Once
+	// Deadline for the final flush. Written by Stop before stopping is closed
+	stopCtx context.Context
+	workers sync.WaitGroup
+
+	// Liveness of the workers, see CheckWorkers
+	running      atomic.Int64
+	beats        []atomic.Int64
+	stallTimeout time.Duration
+
+	workerMetrics []workerMetrics
+	pollInterval  time.Duration
+	lagThreshold  int64
+	// Messages not yet delivered, as of the last consumer poll
+	pending atomic.Int64
+
+	log *slog.Logger
+	// Rate limited logger for messages logged per reading or per batch
+	hot *slog.Logger
+
+	// Delivery accounting used to report shutdown progress
+	inflight atomic.Int64
+	acked    atomic.Int64
+	nacked   atomic.Int64
 }
 
-func NewProcessor(ctx context.Context, dbstring string) *Processor {
-	config, err := pgxpool.ParseConfig(dbstring)
-	if err != nil {
-		log.Fatal("Unable to parse DB config:", err)
+// Observer is handed every decoded reading before it is batched.
+// Observe is called concurrently from all workers and must not block.
+type Observer interface {
+	Observe(reading *pb.SensorReading)
+}
+
+// DeadLetter keeps messages that can never be processed, implemented by
+// deadletter.Queue
+type DeadLetter interface {
+	Publish(ctx context.Context, msg jetstream.Msg, reason string, worker int) error
+}
+
+// Transformer rewrites a decoded reading before gates and observers see it,
+// e.g. to apply calibration.
+// Transform is called concurrently from all workers and must not block.
+type Transformer interface {
+	Transform(reading *pb.SensorReading)
+}
+
+type Options struct {
+	// Pull consumer every worker fetches its batches from
+	Source jetstream.Consumer
+	// Where flushed batches are written. Use sink.Fanout to write to several.
+	Sink       sink.Sink
+	DeadLetter DeadLetter
+	// Where gates send quarantined readings. Falls back to DeadLetter.
+	Quarantine *quarantine.Store
+	Retry      RetryPolicy
+	Breaker    BreakerOptions
+	Observers  []Observer
+	Gates      []Gate
+	Transforms []Transformer
+
+	// Most readings a worker fetches and writes at once
+	BatchSize int
+	// Longest a reading waits in a partial batch
+	FlushInterval time.Duration
+	Workers       int
+
+	// A worker that has not gone round its loop for this long fails the
+	// liveness check
+	StallTimeout time.Duration
+	// How often consumer info is polled for the lag metrics
+	ConsumerPollInterval time.Duration
+	// Undelivered messages above which the consumer counts as lagging
+	LagThreshold int
+}
+
+func NewProcessor(ctx context.Context, opts Options) *Processor {
+	if opts.Retry.MaxDeliver == 0 {
+		opts.Retry = DefaultRetryPolicy
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.StallTimeout <= 0 {
+		opts.StallTimeout = DefaultStallTimeout
+	}
+	if opts.ConsumerPollInterval <= 0 {
+		opts.ConsumerPollInterval = DefaultConsumerPollInterval
+	}
+	if opts.LagThreshold <= 0 {
+		opts.LagThreshold = DefaultLagThreshold
 	}
 
-	config.MaxConns = int32(workerCount)
+	logger := logging.Component("processor")
+	p := &Processor{
+		source:     opts.Source,
+		stopping:   make(chan struct{}),
+		sink:       opts.Sink,
+		deadLetter: opts.DeadLetter,
+		quarantine: opts.Quarantine,
+		retry:      opts.Retry,
+		observers:  opts.Observers,
+		gates:      opts.Gates,
+		transforms: opts.Transforms,
+		workerN:    opts.Workers,
+		breaker:    newBreaker(opts.Breaker, logger),
+		log:        logger,
+		hot:        logging.Sampled(logger),
 
-	pool, err := pgxpool.NewWithConfig(ctx, config)
-	if err != nil {
-		log.Fatal("Unable to connect to DB:", err)
+		beats:        make([]atomic.Int64, opts.Workers),
+		stallTimeout: opts.StallTimeout,
+		pollInterval: opts.ConsumerPollInterval,
+		lagThreshold: int64(opts.LagThreshold),
 	}
-	return &Processor{
-		input:  make(chan jetstream.Msg, 50000),
-		dbPool: pool,
+	for i := range opts.Workers {
+		p.workerMetrics = append(p.workerMetrics, newWorkerMetrics(i))
 	}
+	p.Tune(opts.BatchSize, opts.FlushInterval)
+	return p
 }
 
 func (p *Processor) Start(ctx context.Context) {
-	for i := range workerCount {
+	p.workers.Add(p.workerN)
+	for i := range p.workerN {
 		go p.workerLoop(ctx, i)
 	}
+	go p.pollConsumer(ctx)
+}
+
+// Tune changes the batch size and flush interval of running workers. Each
+// worker picks the new values up at its next fetch.
+func (p *Processor) Tune(batchSize int, flushInterval time.Duration) {
+	if batchSize > 0 {
+		p.batchSize.Store(int64(batchSize))
+	}
+	if flushInterval > 0 {
+		p.flushInterval.Store(int64(flushInterval))
+	}
+}
+
+func (p *Processor) ack(msg jetstream.Msg) {
+	msg.Ack()
+	p.inflight.Add(-1)
+	p.acked.Add(1)
+}
+
+func (p *Processor) nak(msg jetstream.Msg, delay time.Duration) {
+	if delay > 0 {
+		msg.NakWithDelay(delay)
+	} else {
+		msg.Nak()
+	}
+	p.inflight.Add(-1)
+	p.nacked.Add(1)
+}
+
+// Moves a message to the dead-letter stream and acks the original.
+// If the dead-letter stream is unavailable the message is left unacked so
+// NATS redelivers it instead of it being lost.
+func (p *Processor) moveToDeadLetter(ctx context.Context, msg jetstream.Msg, reason string, worker int) {
+	if p.deadLetter == nil {
+		p.hot.Error("Dropping message, no dead-letter queue configured",
+			"subject", msg.Subject(), "reason", reason, logging.KeyWorker, worker)
+		p.ack(msg)
+		return
+	}
+
+	if err := p.deadLetter.Publish(ctx, msg, reason, worker); err != nil {
+		p.hot.Error("Failed to dead-letter message",
+			"subject", msg.Subject(), logging.KeyWorker, worker, logging.Err(err))
+		p.inflight.Add(-1)
+		p.nacked.Add(1)
+		return
+	}
+
+	p.ack(msg)
 }
 
-// Submits reading to queue
-func (p *Processor) Submit(data jetstream.Msg) {
-	p.input <- data
+func (p *Processor) persist(ctx context.Context, batch []*batchItem, worker int) error {
+	readings := make([]*pb.SensorReading, 0, len(batch))
+	for _, item := range batch {
+		readings = append(readings, item.data)
+	}
+
+	m := p.workerMetrics[worker]
+	ctx, span := startFlush(ctx, batch, worker)
+	started := time.Now()
+	err := p.sink.Write(ctx, readings)
+	m.flushDuration.Observe(time.Since(started).Seconds())
+	endFlush(span, err)
+
+	switch {
+	case err == nil:
+	case IsPermanent(err):
+		m.permanent.Inc()
+	default:
+		m.transient.Inc()
+	}
+	return err
 }
 
-func (p *Processor) flushBatch(ctx context.Context, batch []*batchItem) {
+func (p *Processor) flushBatch(ctx context.Context, batch []*batchItem, worker int) {
 	if len(batch) == 0 {
 		return
 	}
+	endResidence(batch)
 
-	rows := [][]interface{}{}
-	for _, reading := range batch {
-		rows = append(rows, []interface{}{
-			reading.data.Timestamp,
-			reading.data.SensorId,
-			reading.data.SensorZone,
-			reading.data.Temperature,
-			reading.data.Humidity,
-			reading.data.CoLevel,
-			reading.data.BatteryLevel,
-		})
-	}
-
-	_, err := p.dbPool.CopyFrom(
-		ctx,
-		pgx.Identifier{"sensor_readings"},
-		[]string{"time", "sensor_id", "zone", "temperature", "humidity", "co_level", "battery_level"},
-		pgx.CopyFromRows(rows),
-	)
-
-	//Message is not acknowledged when error exists.
-	//This forces the NATS server to retry the message
-	if err != nil {
-		log.Printf("ERROR: Failed to flush batch to DB: %v", err)
+	// The sink is known to be failing, hand the batch back without trying
+	if !p.breaker.allow(worker) {
+		p.refuseBatch(ctx, batch, worker)
+		return
+	}
+
+	err := p.persist(ctx, batch, worker)
+	p.breaker.record(err == nil || IsPermanent(err))
+	if err == nil {
+		for _, item := range batch {
+			p.ack(item.msg)
+		}
+		return
+	}
+
+	// A permanent error is caused by one or more bad rows. Split the batch
+	// until the offending readings are isolated and dead-letter only those.
+	if IsPermanent(err) {
+		if len(batch) == 1 {
+			p.hot.Error("Reading rejected by sink", logging.KeyWorker, worker,
+				logging.KeySensor, batch[0].data.SensorId, logging.Err(err))
+			p.moveToDeadLetter(ctx, batch[0].msg, fmt.Sprintf("persist: %v", err), worker)
+			return
+		}
+
+		mid := len(batch) / 2
+		p.flushBatch(ctx, batch[:mid], worker)
+		p.flushBatch(ctx, batch[mid:], worker)
 		return
 	}
 
+	//Transient errors are nak'd with a growing delay so the batch is retried
+	//without waiting for AckWait to expire and without a redelivery storm
+	p.hot.Error("Failed to flush batch to sink", logging.KeyWorker, worker,
+		logging.KeyBatchSize, len(batch), logging.Err(err))
+	p.nakBatch(ctx, batch, fmt.Sprintf("persist: %v", err), worker)
+}
+
+// Naks every message of a batch for redelivery with backoff, or
+// dead-letters those that have run out of deliveries
+func (p *Processor) nakBatch(ctx context.Context, batch []*batchItem, reason string, worker int) {
 	for _, item := range batch {
-		item.msg.Ack()
+		meta, metaErr := item.msg.Metadata()
+		if metaErr != nil {
+			p.nak(item.msg, 0)
+			continue
+		}
+
+		if p.retry.exhausted(meta.NumDelivered) {
+			p.moveToDeadLetter(ctx, item.msg, reason, worker)
+			continue
+		}
+
+		p.nak(item.msg, p.retry.delay(meta.NumDelivered))
+	}
+}
+
+// Hands back a batch the breaker kept from the sink when the worker cannot
+// hold on to it, i.e. while stopping. A nak counts as a delivery like any
+// other, so messages on their last delivery are dead-lettered rather than
+// left on the stream where the server would never deliver them again.
+func (p *Processor) refuseBatch(ctx context.Context, batch []*batchItem, worker int) {
+	delay := p.breaker.retryAfter()
+	for _, item := range batch {
+		meta, err := item.msg.Metadata()
+		if err == nil && p.retry.exhausted(meta.NumDelivered) {
+			p.moveToDeadLetter(ctx, item.msg, "persist: sink circuit breaker open", worker)
+			continue
+		}
+		p.nak(item.msg, delay)
+	}
+}
+
+// Keeps a batch the breaker will not let through until worker may write
+// again. Redeliveries count towards MaxDeliver, so instead of being nak'd
+// the messages are marked in progress every flush interval, well within the
+// ack deadline. Returns false if the processor is stopping or ctx is done.
+func (p *Processor) holdBatch(ctx context.Context, batch []*batchItem, worker int) bool {
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, time.Duration(p.flushInterval.Load()))
+		allowed := p.breaker.wait(waitCtx, p.stopping, worker)
+		cancel()
+		if allowed {
+			return true
+		}
+
+		select {
+		case <-p.stopping:
+			return false
+		case <-ctx.Done():
+			return false
+		default:
+		}
+
+		p.beats[worker].Store(time.Now().UnixNano())
+		for _, item := range batch {
+			item.msg.InProgress()
+		}
 	}
 }
 
-// Core of the processor. Fans in all readings from NATS.
-// Batches all readings in-memory then flush when interval elapses or the batch is full
+// Core of the processor. Each worker fetches up to a batch worth of
+// messages from the consumer and flushes when the batch is full or the flush
+// interval has passed since the batch was started. A worker does not fetch
+// while it is flushing, so a slow sink slows consumption instead of messages
+// piling up in memory.
 func (p *Processor) workerLoop(ctx context.Context, i int) {
-	batch := make([]*batchItem, 0, BatchSize)
-	ticker := time.NewTicker(FlusInterval * time.Second)
-	defer ticker.Stop()
+	defer p.workers.Done()
+
+	p.running.Add(1)
+	defer p.running.Add(-1)
+	// A worker exiting mid-probe must hand the probe to another worker
+	defer p.breaker.release(i)
+
+	batch := make([]*batchItem, 0, p.batchSize.Load())
+	deadline := time.Now().Add(time.Duration(p.flushInterval.Load()))
+	started := time.Now()
 
-	timeSince := time.Now()
 	for {
+		p.beats[i].Store(time.Now().UnixNano())
+
 		select {
-		case rawMsg := <-p.input:
-			var reading pb.SensorReading
-			if err := proto.Unmarshal(rawMsg.Data(), &reading); err != nil {
-				log.Printf("Invalid Protobuf: %v", err)
-				// If it's garbage, Ack it to remove from queue
-				rawMsg.Ack()
+		case <-p.stopping:
+			p.flushBatch(p.stopCtx, batch, i)
+			return
+
+		case <-ctx.Done():
+			//Cancelled without Stop. Give the partial batch a short grace
+			//period since ctx can no longer be used for the flush
+			flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), FinalFlushTimeout)
+			p.flushBatch(flushCtx, batch, i)
+			cancel()
+			return
+
+		default:
+		}
+
+		// Stop fetching while the sink is failing. A batch already held is
+		// kept until it can be flushed, or is handed back when stopping.
+		if len(batch) > 0 && !p.breaker.allow(i) {
+			if !p.holdBatch(ctx, batch, i) {
 				continue
 			}
+		} else if !p.breaker.wait(ctx, p.stopping, i) {
+			continue
+		}
 
-			batch = append(batch, &batchItem{data: &reading, msg: rawMsg})
-			metrics.SensorReadings.WithLabelValues(reading.SensorZone).Inc()
-			metrics.SetReadingsGauge(&reading)
-
-			if len(batch) >= BatchSize {
-				p.flushBatch(ctx, batch)
-				fmt.Printf("Worker %d: Batch Full! Flushing %d took: %s\n", i, len(batch), time.Since(timeSince))
-				metrics.BatchSize.Observe(float64(len(batch)))
-				//Reset batch buffer
-				timeSince = time.Now()
-				batch = batch[:0]
-				ticker.Reset(FlusInterval * time.Second)
+		batchSize := int(p.batchSize.Load())
+		if wait := time.Until(deadline); len(batch) < batchSize && wait >= minFetchWait {
+			if err := p.fetch(ctx, &batch, batchSize, wait, i); err != nil {
+				p.log.Error("Failed to fetch", logging.KeyWorker, i, logging.Err(err))
+				select {
+				case <-time.After(fetchRetryDelay):
+				case <-p.stopping:
+				case <-ctx.Done():
+				}
 			}
-
-		case <-ticker.C:
-			if len(batch) > 0 {
-				p.flushBatch(ctx, batch)
-				fmt.Printf("Worker %d: Flushed: %d\n", i, len(batch))
-				//Reset batch buffer
-				metrics.BatchSize.Observe(float64(len(batch)))
-				timeSince = time.Now()
-				batch = batch[:0]
+			if len(batch) < batchSize && time.Now().Before(deadline) {
+				continue
 			}
+		}
 
-		case <-ctx.Done():
-			if len(batch) > 0 {
-				p.flushBatch(ctx, batch)
-			}
+		// The breaker may have opened during the fetch
+		if len(batch) > 0 && !p.breaker.allow(i) {
+			continue
+		}
+
+		if len(batch) > 0 {
+			p.flushBatch(ctx, batch, i)
+			p.hot.Debug("Flushed batch", logging.KeyWorker, i,
+				logging.KeyBatchSize, len(batch), logging.KeyDuration, time.Since(started))
+			metrics.BatchSize.Observe(float64(len(batch)))
+			//Reset batch buffer
+			batch = batch[:0]
+			p.workerMetrics[i].depth.Set(0)
+		}
+		started = time.Now()
+		deadline = started.Add(time.Duration(p.flushInterval.Load()))
+	}
+}
+
+// Fetches up to the rest of the batch, waiting at most wait for messages
+func (p *Processor) fetch(ctx context.Context, batch *[]*batchItem, batchSize int, wait time.Duration, worker int) error {
+	msgs, err := p.source.Fetch(batchSize-len(*batch), jetstream.FetchMaxWait(wait))
+	if err != nil {
+		return err
+	}
+
+	m := p.workerMetrics[worker]
+	for msg := range msgs.Messages() {
+		p.inflight.Add(1)
+		meta, err := msg.Metadata()
+		redelivered := err == nil && meta.NumDelivered > 1
+		if redelivered {
+			m.redeliveries.Inc()
 		}
+		if item := p.decode(traceQueueWait(ctx, msg, meta), msg, redelivered, worker); item != nil {
+			*batch = append(*batch, item)
+		}
+	}
+
+	m.depth.Set(float64(len(*batch)))
+
+	if err := msgs.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+		return err
+	}
+	return nil
+}
+
+// Decodes, transforms and admits a message. Returns nil if the message was
+// disposed of instead of batched.
+//
+// Observers only see a reading on its first delivery. They saw it already
+// when it was nak'd or its ack was lost, and folding it in again would
+// double count it in baselines and rate windows.
+func (p *Processor) decode(ctx context.Context, msg jetstream.Msg, redelivered bool, worker int) *batchItem {
+	msgCtx := ctx
+	ctx, span := tracer.Start(ctx, "phylax.decode")
+	defer span.End()
+
+	var reading pb.SensorReading
+	if err := proto.Unmarshal(msg.Data(), &reading); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		p.hot.Warn("Invalid protobuf", logging.KeyWorker, worker, "subject", msg.Subject(), logging.Err(err))
+		// If it's garbage, move it out of the way
+		p.moveToDeadLetter(ctx, msg, fmt.Sprintf("decode: %v", err), worker)
+		return nil
 	}
+
+	for _, t := range p.transforms {
+		t.Transform(&reading)
+	}
+	span.SetAttributes(attribute.String("sensor.id", reading.SensorId), attribute.String("sensor.zone", reading.SensorZone))
+	if !p.admit(ctx, msg, &reading, worker) {
+		span.SetAttributes(attribute.Bool("admitted", false))
+		return nil
+	}
+
+	// A redelivered reading was already counted and observed when it first
+	// arrived
+	if !redelivered {
+		metrics.SensorReadings.WithLabelValues(reading.SensorZone).Inc()
+		metrics.SetReadingsGauge(&reading)
+		for _, o := range p.observers {
+			o.Observe(&reading)
+		}
+	}
+
+	residence := startResidence(msgCtx, &reading, worker)
+	return &batchItem{data: &reading, msg: msg, span: residence, spanContext: residence.SpanContext()}
 }
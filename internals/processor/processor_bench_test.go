@@ -0,0 +1,164 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/natstest"
+	"github.com/knightfall22/Phylax/internals/sink"
+	"github.com/knightfall22/Phylax/publisher"
+	"github.com/nats-io/nats.go/jetstream"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	benchWorkers       = 4
+	benchFlushInterval = 50 * time.Millisecond
+	// Size of the input channel in the fan-in design it replaced
+	benchInputBuffer = 50000
+)
+
+// BenchmarkWorkerLoop compares the per-worker Fetch loop against the
+// Consume -> channel fan-in design it replaced. Both pull from the processor
+// consumer of an embedded JetStream server and decode, flush and ack through
+// the same Processor into the in-memory sink, so only the dispatch differs.
+// Publishing the readings is not timed.
+func BenchmarkWorkerLoop(b *testing.B) {
+	payload, err := proto.Marshal(&pb.SensorReading{
+		SensorId:     "sensor-1",
+		SensorZone:   "office",
+		Timestamp:    time.Now().UnixMilli(),
+		Temperature:  21.5,
+		Humidity:     45,
+		CoLevel:      2,
+		BatteryLevel: 90,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	nc := natstest.Run(b)
+
+	for _, batchSize := range []int{100, 500, 1500} {
+		b.Run(fmt.Sprintf("fetch/batch=%d", batchSize), func(b *testing.B) {
+			runBench(b, nc, payload, batchSize, runFetch)
+		})
+		b.Run(fmt.Sprintf("fanin/batch=%d", batchSize), func(b *testing.B) {
+			runBench(b, nc, payload, batchSize, runFanIn)
+		})
+	}
+}
+
+func runBench(b *testing.B, nc *publisher.NatsPublisher, payload []byte, batchSize int, run func(context.Context, *Processor, jetstream.Consumer) (stop func())) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Start every run from an empty stream and a fresh consumer
+	js := nc.JetStream()
+	js.DeleteConsumer(ctx, "SENSORS_READINGS", "PROCESSOR_WORKERS")
+	if err := nc.Stream.Purge(ctx); err != nil {
+		b.Fatal(err)
+	}
+	consumer, err := nc.ProcessorConsumer(ctx, publisher.ConsumerOptions{MaxDeliver: 5})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for range b.N {
+		if err := nc.PublishAsync("sensors.office.sensor-1", payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+	select {
+	case <-js.PublishAsyncComplete():
+	case <-time.After(time.Minute):
+		b.Fatal("publishes not acknowledged")
+	}
+
+	out := &countingSink{Memory: sink.NewMemory(), total: int64(b.N), done: make(chan struct{})}
+	p := NewProcessor(ctx, Options{
+		Source:               consumer,
+		Sink:                 out,
+		BatchSize:            batchSize,
+		FlushInterval:        benchFlushInterval,
+		Workers:              benchWorkers,
+		ConsumerPollInterval: time.Hour,
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	stop := run(ctx, p, consumer)
+	<-out.done
+	b.StopTimer()
+	// Nothing may fetch once the next run deletes the consumer
+	cancel()
+	stop()
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+}
+
+func runFetch(ctx context.Context, p *Processor, consumer jetstream.Consumer) func() {
+	p.Start(ctx)
+	return func() { p.Stop(context.Background()) }
+}
+
+// The replaced design: a single Consume callback feeding a shared channel
+// that every worker reads from
+func runFanIn(ctx context.Context, p *Processor, consumer jetstream.Consumer) func() {
+	input := make(chan jetstream.Msg, benchInputBuffer)
+	cc, err := consumer.Consume(func(msg jetstream.Msg) {
+		p.inflight.Add(1)
+		select {
+		case input <- msg:
+		case <-ctx.Done():
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	var workers sync.WaitGroup
+	for i := range p.workerN {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			fanInWorker(ctx, p, input, i)
+		}()
+	}
+	return func() {
+		cc.Stop()
+		<-cc.Closed()
+		workers.Wait()
+	}
+}
+
+func fanInWorker(ctx context.Context, p *Processor, input <-chan jetstream.Msg, i int) {
+	batchSize := int(p.batchSize.Load())
+	batch := make([]*batchItem, 0, batchSize)
+	ticker := time.NewTicker(time.Duration(p.flushInterval.Load()))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg := <-input:
+			meta, _ := msg.Metadata()
+			if item := p.decode(traceQueueWait(ctx, msg, meta), msg, meta.NumDelivered > 1, i); item != nil {
+				batch = append(batch, item)
+			}
+			if len(batch) >= batchSize {
+				p.flushBatch(ctx, batch, i)
+				batch = batch[:0]
+			}
+
+		case <-ticker.C:
+			p.flushBatch(ctx, batch, i)
+			batch = batch[:0]
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
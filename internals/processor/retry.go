@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Redelivery policy for batches that could not be flushed
+type RetryPolicy struct {
+	// Must match MaxDeliver on the consumer. Messages on their last delivery
+	// are dead-lettered instead of being nak'd.
+	MaxDeliver int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxDeliver: 10,
+	BaseDelay:  time.Second,
+	MaxDelay:   time.Minute,
+}
+
+// Exponential backoff based on how many times the message was delivered.
+// 1st delivery waits BaseDelay, 2nd waits 2*BaseDelay and so on up to MaxDelay
+func (r RetryPolicy) delay(numDelivered uint64) time.Duration {
+	delay := r.BaseDelay
+	for i := uint64(1); i < numDelivered; i++ {
+		delay *= 2
+		if delay >= r.MaxDelay {
+			return r.MaxDelay
+		}
+	}
+	return delay
+}
+
+// Whether this is the last delivery the consumer will make for a message
+func (r RetryPolicy) exhausted(numDelivered uint64) bool {
+	return r.MaxDeliver > 0 && numDelivered >= uint64(r.MaxDeliver)
+}
+
+// IsPermanent reports whether a sink error can never succeed no matter how
+// often it is retried, such as constraint violations or malformed data.
+// Connection failures, timeouts and resource exhaustion are considered transient.
+func IsPermanent(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || len(pgErr.Code) < 2 {
+		return false
+	}
+
+	switch pgErr.Code[:2] {
+	case "22", // data exception
+		"23", // integrity constraint violation
+		"42": // syntax error or access rule violation
+		return true
+	}
+
+	return false
+}
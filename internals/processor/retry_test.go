@@ -0,0 +1,86 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{MaxDeliver: 10, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		numDelivered uint64
+		want         time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{1000, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := policy.delay(tt.numDelivered); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.numDelivered, got, tt.want)
+		}
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	tests := []struct {
+		maxDeliver   int
+		numDelivered uint64
+		want         bool
+	}{
+		{3, 1, false},
+		{3, 2, false},
+		{3, 3, true},
+		{3, 4, true},
+		// Unlimited redeliveries
+		{0, 1000, false},
+		{-1, 1000, false},
+	}
+
+	for _, tt := range tests {
+		policy := RetryPolicy{MaxDeliver: tt.maxDeliver}
+		if got := policy.exhausted(tt.numDelivered); got != tt.want {
+			t.Errorf("MaxDeliver %d: exhausted(%d) = %t, want %t", tt.maxDeliver, tt.numDelivered, got, tt.want)
+		}
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unique violation", &pgconn.PgError{Code: "23505"}, true},
+		{"check violation", &pgconn.PgError{Code: "23514"}, true},
+		{"invalid text representation", &pgconn.PgError{Code: "22P02"}, true},
+		{"undefined column", &pgconn.PgError{Code: "42703"}, true},
+		{"wrapped", fmt.Errorf("flush: %w", &pgconn.PgError{Code: "23502"}), true},
+		{"too many connections", &pgconn.PgError{Code: "53300"}, false},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, false},
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, false},
+		{"malformed code", &pgconn.PgError{Code: "2"}, false},
+		{"canceled", context.Canceled, false},
+		{"deadline", fmt.Errorf("flush: %w", context.DeadlineExceeded), false},
+		{"connection refused", errors.New("dial tcp: connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPermanent(tt.err); got != tt.want {
+				t.Fatalf("IsPermanent(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package processor
+
+import (
+	"context"
+	"time"
+)
+
+// Grace period for flushing partial batches when the worker context is
+// cancelled without calling Stop
+const FinalFlushTimeout = 5 * time.Second
+
+type StopResult struct {
+	// Messages persisted (or dead-lettered) and acked while stopping
+	Flushed int64
+	// Messages handed to the processor that were not acked. NATS will
+	// redeliver these once AckWait expires.
+	Unacked int64
+}
+
+// Stop tells the workers to stop fetching, flushes every worker's partial
+// batch, including messages from a fetch that was in progress, and waits
+// for the workers to exit.
+//
+// If ctx expires before the workers finish, Stop returns what has been
+// flushed so far along with ctx's error.
+func (p *Processor) Stop(ctx context.Context) (StopResult, error) {
+	ackedBefore := p.acked.Load()
+	nackedBefore := p.nacked.Load()
+
+	p.stopOnce.Do(func() {
+		p.stopCtx = ctx
+		close(p.stopping)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return StopResult{
+		Flushed: p.acked.Load() - ackedBefore,
+		Unacked: p.inflight.Load() + p.nacked.Load() - nackedBefore,
+	}, err
+}
+
+// Close releases the sink. Call after Stop.
+func (p *Processor) Close() error {
+	return p.sink.Close()
+}
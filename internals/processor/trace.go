@@ -0,0 +1,108 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/tracing"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Each message continues the trace started by its publisher with
+//
+//	nats.queue_wait   stored in the stream until fetched
+//	phylax.decode     unmarshal, transforms and gates
+//	phylax.batch      held in a worker's batch until the flush starts
+//
+// A flush writes many messages at once, so phylax.flush is a child of the
+// first sampled message and links to the rest.
+var tracer = otel.Tracer("github.com/knightfall22/Phylax/internals/processor")
+
+// Returns ctx carrying the message's trace, after recording how long the
+// message waited in the stream
+func traceQueueWait(ctx context.Context, msg jetstream.Msg, meta *jetstream.MsgMetadata) context.Context {
+	ctx = tracing.Extract(ctx, msg.Headers())
+	if meta == nil {
+		return ctx
+	}
+
+	_, span := tracer.Start(ctx, "nats.queue_wait",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithTimestamp(meta.Timestamp),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "nats"),
+			attribute.String("messaging.destination.name", msg.Subject()),
+			attribute.Int64("messaging.nats.stream_sequence", int64(meta.Sequence.Stream)),
+			attribute.Int64("messaging.nats.num_delivered", int64(meta.NumDelivered)),
+		),
+	)
+	span.End()
+	return ctx
+}
+
+// Starts the span covering the reading's time in a batch
+func startResidence(ctx context.Context, reading *pb.SensorReading, worker int) trace.Span {
+	_, span := tracer.Start(ctx, "phylax.batch", trace.WithAttributes(
+		attribute.String("sensor.id", reading.SensorId),
+		attribute.String("sensor.zone", reading.SensorZone),
+		attribute.Int("worker", worker),
+	))
+	return span
+}
+
+func endResidence(batch []*batchItem) {
+	now := time.Now()
+	for _, item := range batch {
+		if item.span != nil {
+			item.span.End(trace.WithTimestamp(now))
+			item.span = nil
+		}
+	}
+}
+
+// Starts the span of a sink write, linked to every sampled message in the
+// batch
+func startFlush(ctx context.Context, batch []*batchItem, worker int) (context.Context, trace.Span) {
+	var parent trace.SpanContext
+	links := make([]trace.Link, 0, len(batch))
+	for _, item := range batch {
+		sc := item.spanContext
+		if !sc.IsSampled() {
+			continue
+		}
+		if !parent.IsValid() {
+			parent = sc
+			continue
+		}
+		links = append(links, trace.Link{SpanContext: sc})
+	}
+	// With no sampled message the flush follows the first message's
+	// decision, which keeps unsampled batches unrecorded
+	if !parent.IsValid() && len(batch) > 0 {
+		parent = batch[0].spanContext
+	}
+	if parent.IsValid() {
+		ctx = trace.ContextWithSpanContext(ctx, parent)
+	}
+
+	return tracer.Start(ctx, "phylax.flush",
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.Int("batch.size", len(batch)),
+			attribute.Int("worker", worker),
+		),
+	)
+}
+
+func endFlush(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,48 @@
+package quarantine
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/knightfall22/Phylax/internals/httpx"
+)
+
+// RegisterRoutes exposes quarantined readings:
+//
+//	GET /api/v1/quarantine?reason=&sensor_id=&before=&limit=   newest first
+func (s *Store) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/quarantine", s.handleList)
+}
+
+func (s *Store) handleList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit := DefaultLimit
+	if raw := q.Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 || v > MaxLimit {
+			httpx.WriteError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", MaxLimit))
+			return
+		}
+		limit = v
+	}
+
+	var before int64
+	if raw := q.Get("before"); raw != "" {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || v <= 0 {
+			httpx.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid before %q", raw))
+			return
+		}
+		before = v
+	}
+
+	entries, err := s.List(r.Context(), q.Get("reason"), q.Get("sensor_id"), before, limit)
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, entries)
+}
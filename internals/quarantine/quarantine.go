@@ -0,0 +1,91 @@
+package quarantine
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	pb "github.com/knightfall22/Phylax/api/v1"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+const entryColumns = `id, quarantined_at, reason, subject, sensor_id, zone, time,
+	temperature, humidity, co_level, battery_level`
+
+// Entry is a quarantined reading
+type Entry struct {
+	ID            int64      `json:"id"`
+	QuarantinedAt time.Time  `json:"quarantined_at"`
+	Reason        string     `json:"reason"`
+	Subject       string     `json:"subject"`
+	SensorID      string     `json:"sensor_id"`
+	Zone          string     `json:"zone"`
+	Time          *time.Time `json:"time,omitempty"`
+	Temperature   *float64   `json:"temperature"`
+	Humidity      *float64   `json:"humidity"`
+	CoLevel       *float64   `json:"co_level"`
+	BatteryLevel  *float64   `json:"battery_level"`
+}
+
+// Store keeps readings that must not reach sensor_readings in the
+// quarantined_readings table. The pool is owned by the caller.
+type Store struct {
+	pool *pgxpool.Pool
+}
+
+func NewStore(pool *pgxpool.Pool) *Store {
+	return &Store{pool: pool}
+}
+
+// Add quarantines a reading along with the message it arrived in
+func (s *Store) Add(ctx context.Context, subject string, payload []byte, reading *pb.SensorReading, reason string) error {
+	var at *time.Time
+	if t := reading.ReadingTime(); !t.IsZero() && t.Unix() != 0 {
+		at = &t
+	}
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO quarantined_readings (reason, subject, sensor_id, zone, time,
+			temperature, humidity, co_level, battery_level, payload)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		reason, subject, reading.SensorId, reading.SensorZone, at,
+		finite(reading.Temperature), finite(reading.Humidity),
+		finite(reading.CoLevel), finite(reading.BatteryLevel), payload)
+	return err
+}
+
+// List returns quarantined readings, newest first, optionally filtered by
+// reason and sensor. Pass the last ID seen as before to page back in time.
+func (s *Store) List(ctx context.Context, reason, sensorID string, before int64, limit int) ([]Entry, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+entryColumns+` FROM quarantined_readings
+		WHERE ($1 = '' OR reason = $1)
+		  AND ($2 = '' OR sensor_id = $2)
+		  AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4`, reason, sensorID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Entry, error) {
+		var e Entry
+		err := row.Scan(&e.ID, &e.QuarantinedAt, &e.Reason, &e.Subject, &e.SensorID, &e.Zone, &e.Time,
+			&e.Temperature, &e.Humidity, &e.CoLevel, &e.BatteryLevel)
+		return e, err
+	})
+}
+
+// NaN and infinities are stored as NULL so the row can be read back as JSON
+func finite(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,149 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/knightfall22/Phylax/internals/httpx"
+)
+
+// Used when a range or series query does not specify from
+const defaultWindow = time.Hour
+
+// RegisterRoutes exposes the read API:
+//
+//	GET /api/v1/readings/latest?zone=                          latest reading per sensor
+//	GET /api/v1/sensors/{id}/latest                            latest reading of one sensor
+//	GET /api/v1/readings?sensor_id=|zone=&from=&to=&limit=&cursor=   readings in a time range
+//	GET /api/v1/readings/series?sensor_id=|zone=&from=&to=&bucket=   avg/min/max per bucket
+//
+// from and to are RFC 3339 timestamps. to defaults to now and from to an
+// hour before to.
+func (s *Store) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/readings/latest", s.handleLatest)
+	mux.HandleFunc("GET /api/v1/sensors/{id}/latest", s.handleSensorLatest)
+	mux.HandleFunc("GET /api/v1/readings", s.handleRange)
+	mux.HandleFunc("GET /api/v1/readings/series", s.handleSeries)
+}
+
+func (s *Store) handleLatest(w http.ResponseWriter, r *http.Request) {
+	readings, err := s.Latest(r.Context(), r.URL.Query().Get("zone"))
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, readings)
+}
+
+func (s *Store) handleSensorLatest(w http.ResponseWriter, r *http.Request) {
+	reading, err := s.LatestForSensor(r.Context(), r.PathValue("id"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		httpx.WriteError(w, http.StatusNotFound, fmt.Errorf("no readings for sensor %q", r.PathValue("id")))
+		return
+	}
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, reading)
+}
+
+func (s *Store) handleRange(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		httpx.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	limit := DefaultLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > MaxLimit {
+			httpx.WriteError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", MaxLimit))
+			return
+		}
+	}
+
+	page, err := s.Range(r.Context(), filter, limit, r.URL.Query().Get("cursor"))
+	if errors.Is(err, ErrInvalidCursor) {
+		httpx.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, page)
+}
+
+func (s *Store) handleSeries(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		httpx.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	bucket := time.Minute
+	if raw := r.URL.Query().Get("bucket"); raw != "" {
+		bucket, err = time.ParseDuration(raw)
+		if err != nil || bucket < time.Second {
+			httpx.WriteError(w, http.StatusBadRequest, fmt.Errorf("bucket must be a duration of at least 1s"))
+			return
+		}
+	}
+
+	buckets, err := s.Series(r.Context(), filter, bucket)
+	if errors.Is(err, ErrTooManyBuckets) {
+		httpx.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, buckets)
+}
+
+// Range and series queries must name a sensor or a zone
+func parseFilter(r *http.Request) (Filter, error) {
+	q := r.URL.Query()
+
+	f := Filter{
+		SensorID: q.Get("sensor_id"),
+		Zone:     q.Get("zone"),
+		To:       time.Now().UTC(),
+	}
+	if f.SensorID == "" && f.Zone == "" {
+		return f, fmt.Errorf("sensor_id or zone is required")
+	}
+
+	if raw := q.Get("to"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return f, fmt.Errorf("invalid to: %w", err)
+		}
+		f.To = t
+	}
+
+	f.From = f.To.Add(-defaultWindow)
+	if raw := q.Get("from"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return f, fmt.Errorf("invalid from: %w", err)
+		}
+		f.From = t
+	}
+
+	if !f.From.Before(f.To) {
+		return f, fmt.Errorf("from must be before to")
+	}
+	return f, nil
+}
@@ -0,0 +1,270 @@
+package query
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	DefaultLimit = 500
+	MaxLimit     = 5000
+	// Upper bound on buckets returned by a single series query
+	MaxBuckets = 10000
+)
+
+var (
+	ErrInvalidCursor  = errors.New("invalid cursor")
+	ErrTooManyBuckets = errors.New("too many buckets")
+)
+
+type Reading struct {
+	Time         time.Time `json:"time"`
+	SensorID     string    `json:"sensor_id"`
+	Zone         string    `json:"zone"`
+	Temperature  float64   `json:"temperature"`
+	Humidity     float64   `json:"humidity"`
+	CoLevel      float64   `json:"co_level"`
+	BatteryLevel float64   `json:"battery_level"`
+	// Values as reported by the sensor, when a calibration was applied
+	Raw *RawValues `json:"raw,omitempty"`
+}
+
+type RawValues struct {
+	Temperature  float64 `json:"temperature"`
+	Humidity     float64 `json:"humidity"`
+	CoLevel      float64 `json:"co_level"`
+	BatteryLevel float64 `json:"battery_level"`
+}
+
+// Filter selects readings of a single sensor or a whole zone. Each is
+// served by its own (sensor_id, time DESC) or (zone, time DESC) index.
+type Filter struct {
+	SensorID string
+	Zone     string
+	From     time.Time
+	To       time.Time
+}
+
+// Returns the conditions of the filters that are set, numbering their
+// parameters after args. Unset filters are left out rather than matched by
+// an "$1 is empty OR" branch, which generic plans cannot serve from an index.
+func (f Filter) where(args ...any) (string, []any) {
+	var conds []string
+	add := func(cond string, v any) {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.SensorID != "" {
+		add("sensor_id = $%d", f.SensorID)
+	}
+	if f.Zone != "" {
+		add("zone = $%d", f.Zone)
+	}
+	add("time >= $%d", f.From)
+	add("time < $%d", f.To)
+	return strings.Join(conds, " AND "), args
+}
+
+type Page struct {
+	Readings []Reading `json:"readings"`
+	// Pass back as cursor to fetch the next page. Empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type Stats struct {
+	Avg float64 `json:"avg"`
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+type Bucket struct {
+	Start        time.Time `json:"start"`
+	Count        int64     `json:"count"`
+	Temperature  Stats     `json:"temperature"`
+	Humidity     Stats     `json:"humidity"`
+	CoLevel      Stats     `json:"co_level"`
+	BatteryLevel Stats     `json:"battery_level"`
+}
+
+// Store answers read queries against sensor_readings
+type Store struct {
+	pool *pgxpool.Pool
+}
+
+func NewStore(pool *pgxpool.Pool) *Store {
+	return &Store{pool: pool}
+}
+
+const readingColumns = "time, sensor_id, zone, temperature, humidity, co_level, battery_level, " +
+	"raw_temperature, raw_humidity, raw_co_level, raw_battery_level"
+
+// Latest returns the most recent reading of every sensor, optionally limited
+// to one zone. Sensors are enumerated with a loose index scan over the
+// (sensor_id, time DESC) index so this never scans the whole table.
+func (s *Store) Latest(ctx context.Context, zone string) ([]Reading, error) {
+	rows, err := s.pool.Query(ctx, `
+		WITH RECURSIVE sensors AS (
+			(SELECT sensor_id FROM sensor_readings ORDER BY sensor_id LIMIT 1)
+			UNION ALL
+			SELECT (
+				SELECT r.sensor_id FROM sensor_readings r
+				WHERE r.sensor_id > sensors.sensor_id
+				ORDER BY r.sensor_id LIMIT 1
+			)
+			FROM sensors WHERE sensors.sensor_id IS NOT NULL
+		)
+		SELECT latest.* FROM sensors
+		CROSS JOIN LATERAL (
+			SELECT `+readingColumns+` FROM sensor_readings r
+			WHERE r.sensor_id = sensors.sensor_id
+			ORDER BY r.time DESC LIMIT 1
+		) latest
+		WHERE $1 = '' OR latest.zone = $1
+		ORDER BY latest.sensor_id`, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, scanReading)
+}
+
+// LatestForSensor returns the most recent reading of one sensor, or
+// pgx.ErrNoRows if it never reported
+func (s *Store) LatestForSensor(ctx context.Context, sensorID string) (*Reading, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+readingColumns+` FROM sensor_readings
+		WHERE sensor_id = $1
+		ORDER BY time DESC LIMIT 1`, sensorID)
+	if err != nil {
+		return nil, err
+	}
+
+	reading, err := pgx.CollectExactlyOneRow(rows, scanReading)
+	if err != nil {
+		return nil, err
+	}
+	return &reading, nil
+}
+
+// Range returns readings in [From, To), newest first. Pages are keyed on
+// (time, sensor_id) so results stay stable while new readings arrive.
+func (s *Store) Range(ctx context.Context, f Filter, limit int, cursor string) (*Page, error) {
+	where, args := f.where()
+	if cursor != "" {
+		t, sensorID, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, t, sensorID)
+		where += fmt.Sprintf(" AND (time, sensor_id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+	args = append(args, limit+1)
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+readingColumns+` FROM sensor_readings
+		WHERE `+where+`
+		ORDER BY time DESC, sensor_id DESC
+		LIMIT $`+strconv.Itoa(len(args)),
+		args...)
+	if err != nil {
+		return nil, err
+	}
+
+	readings, err := pgx.CollectRows(rows, scanReading)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Readings: readings}
+	if len(readings) > limit {
+		page.Readings = readings[:limit]
+		last := page.Readings[limit-1]
+		page.NextCursor = encodeCursor(last.Time, last.SensorID)
+	}
+	return page, nil
+}
+
+// Series downsamples readings in [From, To) into fixed-width buckets
+func (s *Store) Series(ctx context.Context, f Filter, bucket time.Duration) ([]Bucket, error) {
+	if bucket <= 0 {
+		return nil, fmt.Errorf("bucket must be positive")
+	}
+	if f.To.Sub(f.From)/bucket > MaxBuckets {
+		return nil, fmt.Errorf("%w, use a wider bucket or a shorter range (max %d)", ErrTooManyBuckets, MaxBuckets)
+	}
+
+	where, args := f.where(bucket)
+	rows, err := s.pool.Query(ctx, `
+		SELECT
+			date_bin($1::interval, time, TIMESTAMPTZ 'epoch') AS bucket,
+			count(*),
+			avg(temperature), min(temperature), max(temperature),
+			avg(humidity), min(humidity), max(humidity),
+			avg(co_level), min(co_level), max(co_level),
+			avg(battery_level), min(battery_level), max(battery_level)
+		FROM sensor_readings
+		WHERE `+where+`
+		GROUP BY bucket
+		ORDER BY bucket`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Bucket, error) {
+		var b Bucket
+		err := row.Scan(
+			&b.Start, &b.Count,
+			&b.Temperature.Avg, &b.Temperature.Min, &b.Temperature.Max,
+			&b.Humidity.Avg, &b.Humidity.Min, &b.Humidity.Max,
+			&b.CoLevel.Avg, &b.CoLevel.Min, &b.CoLevel.Max,
+			&b.BatteryLevel.Avg, &b.BatteryLevel.Min, &b.BatteryLevel.Max,
+		)
+		return b, err
+	})
+}
+
+func scanReading(row pgx.CollectableRow) (Reading, error) {
+	var (
+		r   Reading
+		raw [4]*float64
+	)
+	err := row.Scan(&r.Time, &r.SensorID, &r.Zone, &r.Temperature, &r.Humidity, &r.CoLevel, &r.BatteryLevel,
+		&raw[0], &raw[1], &raw[2], &raw[3])
+	if err == nil && raw[0] != nil && raw[1] != nil && raw[2] != nil && raw[3] != nil {
+		r.Raw = &RawValues{Temperature: *raw[0], Humidity: *raw[1], CoLevel: *raw[2], BatteryLevel: *raw[3]}
+	}
+	return r, err
+}
+
+func encodeCursor(t time.Time, sensorID string) string {
+	raw := strconv.FormatInt(t.UnixNano(), 10) + "|" + sensorID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	nanos, sensorID, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return time.Unix(0, n).UTC(), sensorID, nil
+}
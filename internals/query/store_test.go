@@ -0,0 +1,81 @@
+package query
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		at       time.Time
+		sensorID string
+	}{
+		{"nanoseconds kept", time.Date(2026, 10, 18, 9, 0, 0, 123456789, time.UTC), "sensor-1"},
+		{"zone offset normalised", time.Date(2026, 10, 18, 11, 0, 0, 0, time.FixedZone("CEST", 2*3600)), "s1"},
+		{"separator in sensor id", time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC), "a|b"},
+		{"empty sensor id", time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, sensorID, err := decodeCursor(encodeCursor(tt.at, tt.sensorID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !at.Equal(tt.at) || at.Location() != time.UTC || sensorID != tt.sensorID {
+				t.Fatalf("decoded %s, %q, want %s, %q", at, sensorID, tt.at.UTC(), tt.sensorID)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	for _, cursor := range []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("1760778000000000000")),
+		base64.RawURLEncoding.EncodeToString([]byte("yesterday|s1")),
+		base64.StdEncoding.EncodeToString([]byte("1760778000000000000|s1")),
+	} {
+		if _, _, err := decodeCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decodeCursor(%q): %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestFilterWhere(t *testing.T) {
+	from := time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		leading  []any
+		want     string
+		wantArgs []any
+	}{
+		{"sensor", Filter{SensorID: "s1", From: from, To: to}, nil,
+			"sensor_id = $1 AND time >= $2 AND time < $3", []any{"s1", from, to}},
+		{"zone", Filter{Zone: "office", From: from, To: to}, nil,
+			"zone = $1 AND time >= $2 AND time < $3", []any{"office", from, to}},
+		{"both", Filter{SensorID: "s1", Zone: "office", From: from, To: to}, nil,
+			"sensor_id = $1 AND zone = $2 AND time >= $3 AND time < $4", []any{"s1", "office", from, to}},
+		{"numbered after leading args", Filter{Zone: "office", From: from, To: to}, []any{time.Minute},
+			"zone = $2 AND time >= $3 AND time < $4", []any{time.Minute, "office", from, to}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := tt.filter.where(tt.leading...)
+			if where != tt.want {
+				t.Fatalf("where %q, want %q", where, tt.want)
+			}
+			if !slices.Equal(args, tt.wantArgs) {
+				t.Fatalf("args %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/knightfall22/Phylax/internals/httpx"
+)
+
+// RegisterRoutes exposes the sensor registry:
+//
+//	GET  /api/v1/sensors?zone=&status=         list registered sensors
+//	GET  /api/v1/sensors/{id}                  one sensor
+func (r *Registry) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/sensors", r.handleList)
+	mux.HandleFunc("GET /api/v1/sensors/{id}", r.handleGet)
+}
+
+// RegisterAdminRoutes exposes provisioning, which belongs on the
+// authenticated admin listener:
+//
+//	POST /api/v1/sensors                       provision a sensor
+//	PUT  /api/v1/sensors/{id}                  replace a sensor's metadata
+//	POST /api/v1/sensors/{id}/decommission     retire a sensor
+func (r *Registry) RegisterAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/sensors", r.handleCreate)
+	mux.HandleFunc("PUT /api/v1/sensors/{id}", r.handleUpdate)
+	mux.HandleFunc("POST /api/v1/sensors/{id}/decommission", r.handleDecommission)
+}
+
+func (r *Registry) handleList(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	sensors, err := r.List(req.Context(), q.Get("zone"), Status(q.Get("status")))
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, sensors)
+}
+
+func (r *Registry) handleGet(w http.ResponseWriter, req *http.Request) {
+	s, err := r.Get(req.Context(), req.PathValue("id"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, s)
+}
+
+func (r *Registry) handleCreate(w http.ResponseWriter, req *http.Request) {
+	var s Sensor
+	if err := json.NewDecoder(req.Body).Decode(&s); err != nil {
+		httpx.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid sensor: %w", err))
+		return
+	}
+
+	created, err := r.Create(req.Context(), &s)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusCreated, created)
+}
+
+func (r *Registry) handleUpdate(w http.ResponseWriter, req *http.Request) {
+	var s Sensor
+	if err := json.NewDecoder(req.Body).Decode(&s); err != nil {
+		httpx.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid sensor: %w", err))
+		return
+	}
+	s.ID = req.PathValue("id")
+
+	updated, err := r.Update(req.Context(), &s)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, updated)
+}
+
+func (r *Registry) handleDecommission(w http.ResponseWriter, req *http.Request) {
+	s, err := r.Decommission(req.Context(), req.PathValue("id"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, s)
+}
+
+// Maps registry errors to status codes
+func writeRegistryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		httpx.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrExists):
+		httpx.WriteError(w, http.StatusConflict, err)
+	case errors.Is(err, ErrInvalid):
+		httpx.WriteError(w, http.StatusBadRequest, err)
+	default:
+		httpx.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
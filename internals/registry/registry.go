@@ -0,0 +1,371 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/processor"
+)
+
+type Status string
+
+const (
+	StatusActive         Status = "active"
+	StatusDecommissioned Status = "decommissioned"
+)
+
+// Reasons recorded when a reading is refused in strict mode
+const (
+	ReasonUnknown        = "unknown_sensor"
+	ReasonDecommissioned = "decommissioned_sensor"
+)
+
+var (
+	ErrNotFound = errors.New("sensor not found")
+	ErrExists   = errors.New("sensor already registered")
+	ErrInvalid  = errors.New("invalid sensor")
+)
+
+const sensorColumns = `id, zone, location, model, installed_at, status, auto_registered, created_at, updated_at`
+
+// Sensor is a provisioned device and its metadata
+type Sensor struct {
+	ID             string     `json:"id"`
+	Zone           string     `json:"zone"`
+	Location       string     `json:"location"`
+	Model          string     `json:"model"`
+	InstalledAt    *time.Time `json:"installed_at,omitempty"`
+	Status         Status     `json:"status"`
+	AutoRegistered bool       `json:"auto_registered"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type Options struct {
+	// Refuse readings from unknown or decommissioned sensors instead of
+	// auto-registering them
+	Strict bool
+	// What happens to refused readings, processor.Reject or
+	// processor.Quarantine
+	StrictAction processor.Verdict
+	// How often the cache is reloaded to pick up changes made by other
+	// replicas
+	ReloadInterval time.Duration
+}
+
+// Registry is the set of known sensors. Every sensor is cached in memory so
+// the processor can check readings without a database round trip.
+type Registry struct {
+	pool *pgxpool.Pool
+	opts Options
+	log  *slog.Logger
+
+	mu      sync.RWMutex
+	sensors map[string]*Sensor
+
+	// Unknown sensors waiting to be auto-registered
+	pending chan *pb.SensorReading
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func New(ctx context.Context, pool *pgxpool.Pool, opts Options) (*Registry, error) {
+	if opts.StrictAction == processor.Accept {
+		opts.StrictAction = processor.Quarantine
+	}
+	if opts.ReloadInterval <= 0 {
+		opts.ReloadInterval = time.Minute
+	}
+
+	r := &Registry{
+		pool:    pool,
+		opts:    opts,
+		log:     logging.Component("registry"),
+		pending: make(chan *pb.SensorReading, 1024),
+		done:    make(chan struct{}),
+	}
+	if err := r.reload(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Admit implements processor.Gate. Outside strict mode every reading is
+// accepted and unknown sensors are queued for auto-registration.
+func (r *Registry) Admit(reading *pb.SensorReading) (processor.Verdict, string) {
+	verdict, reason, known := r.check(reading)
+	if !known && verdict == processor.Accept {
+		r.autoRegister(reading)
+	}
+	return verdict, reason
+}
+
+// Lookup returns a gate that admits readings like Admit but never registers
+// unknown sensors, for readings that are only previewed and not stored
+func (r *Registry) Lookup() processor.Gate {
+	return lookupGate{r}
+}
+
+type lookupGate struct {
+	r *Registry
+}
+
+func (g lookupGate) Admit(reading *pb.SensorReading) (processor.Verdict, string) {
+	verdict, reason, _ := g.r.check(reading)
+	return verdict, reason
+}
+
+func (r *Registry) check(reading *pb.SensorReading) (verdict processor.Verdict, reason string, known bool) {
+	r.mu.RLock()
+	s, ok := r.sensors[reading.SensorId]
+	r.mu.RUnlock()
+
+	switch {
+	case !ok && r.opts.Strict:
+		return r.opts.StrictAction, ReasonUnknown, false
+	case ok && s.Status == StatusDecommissioned && r.opts.Strict:
+		return r.opts.StrictAction, ReasonDecommissioned, true
+	}
+	return processor.Accept, "", ok
+}
+
+// Caches a placeholder so the sensor is only queued once
+func (r *Registry) autoRegister(reading *pb.SensorReading) {
+	r.mu.Lock()
+	if _, ok := r.sensors[reading.SensorId]; ok {
+		r.mu.Unlock()
+		return
+	}
+	r.sensors[reading.SensorId] = &Sensor{
+		ID:             reading.SensorId,
+		Zone:           reading.SensorZone,
+		Status:         StatusActive,
+		AutoRegistered: true,
+	}
+	r.mu.Unlock()
+
+	select {
+	case r.pending <- reading:
+	default:
+		// Dropped from the cache so the next reading retries
+		r.mu.Lock()
+		delete(r.sensors, reading.SensorId)
+		r.mu.Unlock()
+	}
+}
+
+// Start registers unknown sensors in the background and periodically
+// reloads the cache
+func (r *Registry) Start(ctx context.Context) {
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.opts.ReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case reading := <-r.pending:
+				r.register(ctx, reading)
+			case <-ticker.C:
+				if err := r.reload(ctx); err != nil {
+					r.log.Error("Failed to reload sensor registry", logging.Err(err))
+				}
+			case <-r.done:
+				return
+			}
+		}
+	}()
+}
+
+func (r *Registry) Stop() {
+	close(r.done)
+	r.wg.Wait()
+}
+
+func (r *Registry) register(ctx context.Context, reading *pb.SensorReading) {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO sensors (id, zone, auto_registered) VALUES ($1, $2, TRUE)
+		ON CONFLICT (id) DO NOTHING`, reading.SensorId, reading.SensorZone)
+	if err != nil {
+		r.log.Error("Failed to auto-register sensor", logging.KeySensor, reading.SensorId, logging.Err(err))
+		r.mu.Lock()
+		delete(r.sensors, reading.SensorId)
+		r.mu.Unlock()
+		return
+	}
+	r.log.Info("Auto-registered sensor", logging.KeySensor, reading.SensorId, logging.KeyZone, reading.SensorZone)
+}
+
+func (r *Registry) reload(ctx context.Context) error {
+	sensors, err := r.List(ctx, "", "")
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]*Sensor, len(sensors))
+	for i := range sensors {
+		byID[sensors[i].ID] = &sensors[i]
+	}
+
+	r.mu.Lock()
+	// The list may predate writes cached while it was read. Sensors are
+	// never deleted, so cached ones it misses are kept, as are cached
+	// changes newer than the listed row.
+	for id, s := range r.sensors {
+		if listed, ok := byID[id]; !ok || s.UpdatedAt.After(listed.UpdatedAt) {
+			byID[id] = s
+		}
+	}
+	r.sensors = byID
+	r.mu.Unlock()
+	return nil
+}
+
+// List returns registered sensors, optionally filtered by zone and status
+func (r *Registry) List(ctx context.Context, zone string, status Status) ([]Sensor, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+sensorColumns+` FROM sensors
+		WHERE ($1 = '' OR zone = $1) AND ($2 = '' OR status = $2)
+		ORDER BY id`, zone, string(status))
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanSensor)
+}
+
+func (r *Registry) Get(ctx context.Context, id string) (*Sensor, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+sensorColumns+` FROM sensors WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := pgx.CollectExactlyOneRow(rows, scanSensor)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Create provisions a new sensor, active unless a status is given
+func (r *Registry) Create(ctx context.Context, s *Sensor) (*Sensor, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	if s.Status == "" {
+		s.Status = StatusActive
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		INSERT INTO sensors (id, zone, location, model, installed_at, status)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING `+sensorColumns,
+		s.ID, s.Zone, s.Location, s.Model, s.InstalledAt, s.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := pgx.CollectExactlyOneRow(rows, scanSensor)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.cache(&created)
+	return &created, nil
+}
+
+// Update replaces the metadata of a registered sensor. Its status is kept
+// when none is given.
+func (r *Registry) Update(ctx context.Context, s *Sensor) (*Sensor, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		UPDATE sensors SET zone = $2, location = $3, model = $4, installed_at = $5,
+			status = coalesce(nullif($6, ''), status), auto_registered = FALSE, updated_at = now()
+		WHERE id = $1
+		RETURNING `+sensorColumns,
+		s.ID, s.Zone, s.Location, s.Model, s.InstalledAt, s.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := pgx.CollectExactlyOneRow(rows, scanSensor)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.cache(&updated)
+	return &updated, nil
+}
+
+// Decommission marks a sensor as retired. Its history is kept.
+func (r *Registry) Decommission(ctx context.Context, id string) (*Sensor, error) {
+	rows, err := r.pool.Query(ctx, `
+		UPDATE sensors SET status = $2, updated_at = now()
+		WHERE id = $1
+		RETURNING `+sensorColumns, id, StatusDecommissioned)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := pgx.CollectExactlyOneRow(rows, scanSensor)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.cache(&s)
+	return &s, nil
+}
+
+func (r *Registry) cache(s *Sensor) {
+	r.mu.Lock()
+	r.sensors[s.ID] = s
+	r.mu.Unlock()
+}
+
+// Checks required fields. Status may be left empty.
+func (s *Sensor) validate() error {
+	if s.ID == "" {
+		return fmt.Errorf("%w: id is required", ErrInvalid)
+	}
+	if s.Zone == "" {
+		return fmt.Errorf("%w: zone is required", ErrInvalid)
+	}
+	switch s.Status {
+	case "", StatusActive, StatusDecommissioned:
+	default:
+		return fmt.Errorf("%w: unknown status %q", ErrInvalid, s.Status)
+	}
+	return nil
+}
+
+func scanSensor(row pgx.CollectableRow) (Sensor, error) {
+	var s Sensor
+	err := row.Scan(&s.ID, &s.Zone, &s.Location, &s.Model, &s.InstalledAt,
+		&s.Status, &s.AutoRegistered, &s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
@@ -0,0 +1,75 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/logging"
+)
+
+type Target struct {
+	Name string
+	Sink Sink
+	// A batch is only acked once every required target has stored it.
+	// Failures of optional targets are logged and otherwise ignored.
+	Required bool
+}
+
+// Fanout writes each batch to several sinks concurrently
+type Fanout struct {
+	targets []Target
+	log     *slog.Logger
+}
+
+func NewFanout(targets ...Target) *Fanout {
+	return &Fanout{targets: targets, log: logging.Component("sink")}
+}
+
+func (f *Fanout) Write(ctx context.Context, readings []*pb.SensorReading) error {
+	// Skip the goroutines for the common single sink case
+	if len(f.targets) == 1 {
+		return f.write(ctx, f.targets[0], readings)
+	}
+
+	errs := make([]error, len(f.targets))
+
+	var wg sync.WaitGroup
+	for i, target := range f.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f.write(ctx, target, readings)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (f *Fanout) write(ctx context.Context, target Target, readings []*pb.SensorReading) error {
+	err := target.Sink.Write(ctx, readings)
+	if err == nil {
+		return nil
+	}
+
+	if !target.Required {
+		f.log.Warn("Optional sink failed", "sink", target.Name, logging.Err(err))
+		return nil
+	}
+
+	return fmt.Errorf("sink %q: %w", target.Name, err)
+}
+
+func (f *Fanout) Close() error {
+	errs := []error{}
+	for _, target := range f.targets {
+		if err := target.Sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("sink %q: %w", target.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,83 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+)
+
+func TestFanoutWrite(t *testing.T) {
+	errDown := errors.New("down")
+
+	tests := []struct {
+		name string
+		// Whether each target is required and whether it fails
+		required []bool
+		failing  []bool
+		wantErr  string
+		// Targets whose failure is logged
+		wantLogged []string
+	}{
+		{"single required", []bool{true}, []bool{false}, "", nil},
+		{"single required fails", []bool{true}, []bool{true}, `sink "t0": down`, nil},
+		{"single optional fails", []bool{false}, []bool{true}, "", []string{"t0"}},
+		{"required fails beside a healthy one", []bool{true, true}, []bool{false, true}, `sink "t1": down`, nil},
+		{"optional fails beside a required one", []bool{true, false}, []bool{false, true}, "", []string{"t1"}},
+		{"both kinds fail", []bool{true, false}, []bool{true, true}, `sink "t0": down`, []string{"t1"}},
+	}
+
+	names := []string{"t0", "t1"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var targets []Target
+			var sinks []*Memory
+			for i, required := range tt.required {
+				m := NewMemory()
+				if tt.failing[i] {
+					m.Err = errDown
+				}
+				sinks = append(sinks, m)
+				targets = append(targets, Target{Name: names[i], Sink: m, Required: required})
+			}
+
+			var logs bytes.Buffer
+			f := NewFanout(targets...)
+			f.log = slog.New(slog.NewTextHandler(&logs, nil))
+
+			readings := []*pb.SensorReading{{SensorId: "s1"}, {SensorId: "s2"}}
+			err := f.Write(context.Background(), readings)
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Write: %v", err)
+			case tt.wantErr != "" && (err == nil || !errors.Is(err, errDown) || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("Write: %v, want %q", err, tt.wantErr)
+			}
+
+			for i, m := range sinks {
+				want := len(readings)
+				if tt.failing[i] {
+					want = 0
+				}
+				if got := len(m.Readings()); got != want {
+					t.Fatalf("%s stored %d readings, want %d", names[i], got, want)
+				}
+			}
+
+			logged := strings.Count(logs.String(), "Optional sink failed")
+			if logged != len(tt.wantLogged) {
+				t.Fatalf("%d failures logged, want %d:\n%s", logged, len(tt.wantLogged), logs.String())
+			}
+			for _, name := range tt.wantLogged {
+				if !strings.Contains(logs.String(), "sink="+name) {
+					t.Fatalf("failure of %s not logged:\n%s", name, logs.String())
+				}
+			}
+		})
+	}
+}
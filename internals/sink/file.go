@@ -0,0 +1,68 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"sync"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// File appends readings to a local newline-delimited JSON file.
+// Each Write is fsynced before returning.
+type File struct {
+	mu   sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+}
+
+var jsonOpts = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+func NewFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		file: f,
+		buf:  bufio.NewWriter(f),
+	}, nil
+}
+
+func (s *File) Write(ctx context.Context, readings []*pb.SensorReading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, reading := range readings {
+		line, err := jsonOpts.Marshal(reading)
+		if err != nil {
+			return err
+		}
+
+		if _, err := s.buf.Write(line); err != nil {
+			return err
+		}
+		if err := s.buf.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *File) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,46 @@
+package sink
+
+import (
+	"context"
+	"sync"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+)
+
+// Memory keeps readings in memory. Intended for tests and local runs.
+// Setting Err makes every subsequent Write fail with it.
+type Memory struct {
+	mu       sync.Mutex
+	readings []*pb.SensorReading
+	Err      error
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (s *Memory) Write(ctx context.Context, readings []*pb.SensorReading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Err != nil {
+		return s.Err
+	}
+
+	s.readings = append(s.readings, readings...)
+	return nil
+}
+
+// Readings returns a copy of everything written so far
+func (s *Memory) Readings() []*pb.SensorReading {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]*pb.SensorReading, len(s.readings))
+	copy(out, s.readings)
+	return out
+}
+
+func (s *Memory) Close() error {
+	return nil
+}
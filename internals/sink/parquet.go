@@ -0,0 +1,109 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Row layout of the Parquet files
+type parquetReading struct {
+	Time         int64   `parquet:"time,timestamp(millisecond)"`
+	SensorID     string  `parquet:"sensor_id,dict"`
+	Zone         string  `parquet:"zone,dict"`
+	Temperature  float64 `parquet:"temperature"`
+	Humidity     float64 `parquet:"humidity"`
+	CoLevel      float64 `parquet:"co_level"`
+	BatteryLevel float64 `parquet:"battery_level"`
+
+	// Null for uncalibrated readings
+	RawTemperature  *float64 `parquet:"raw_temperature,optional"`
+	RawHumidity     *float64 `parquet:"raw_humidity,optional"`
+	RawCoLevel      *float64 `parquet:"raw_co_level,optional"`
+	RawBatteryLevel *float64 `parquet:"raw_battery_level,optional"`
+}
+
+// Parquet writes every batch to its own Parquet file, partitioned by day:
+//
+//	<dir>/date=2026-02-11/part-<unix nano>-<seq>.parquet
+//
+// A Parquet file is only readable once its footer is written, so a file per
+// batch is what allows the batch to be acked as soon as Write returns.
+// Files are written under a temporary name and renamed once synced.
+type Parquet struct {
+	dir string
+	seq atomic.Uint64
+}
+
+func NewParquet(dir string) (*Parquet, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Parquet{dir: dir}, nil
+}
+
+func (s *Parquet) Write(ctx context.Context, readings []*pb.SensorReading) error {
+	if len(readings) == 0 {
+		return nil
+	}
+
+	rows := make([]parquetReading, 0, len(readings))
+	for _, reading := range readings {
+		row := parquetReading{
+			Time:         reading.ReadingTime().UnixMilli(),
+			SensorID:     reading.SensorId,
+			Zone:         reading.SensorZone,
+			Temperature:  reading.Temperature,
+			Humidity:     reading.Humidity,
+			CoLevel:      reading.CoLevel,
+			BatteryLevel: reading.BatteryLevel,
+		}
+		if raw := reading.GetRaw(); raw != nil {
+			row.RawTemperature, row.RawHumidity = &raw.Temperature, &raw.Humidity
+			row.RawCoLevel, row.RawBatteryLevel = &raw.CoLevel, &raw.BatteryLevel
+		}
+		rows = append(rows, row)
+	}
+
+	now := time.Now().UTC()
+	partition := filepath.Join(s.dir, "date="+now.Format(time.DateOnly))
+	if err := os.MkdirAll(partition, 0o755); err != nil {
+		return err
+	}
+
+	name := filepath.Join(partition, fmt.Sprintf("part-%d-%d.parquet", now.UnixNano(), s.seq.Add(1)))
+	tmp := name + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := parquet.Write(f, rows); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
+
+func (s *Parquet) Close() error {
+	return nil
+}
@@ -0,0 +1,110 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var readingColumns = []string{
+	"time", "sensor_id", "zone", "temperature", "humidity", "co_level", "battery_level",
+	"raw_temperature", "raw_humidity", "raw_co_level", "raw_battery_level",
+}
+
+// Readings are first copied into a per-connection staging table and then
+// moved into sensor_readings, skipping rows already stored. This makes
+// replaying a batch (e.g. after a lost ack) safe.
+const (
+	createStagingSQL = `CREATE TEMP TABLE IF NOT EXISTS sensor_readings_staging
+		(LIKE sensor_readings INCLUDING DEFAULTS) ON COMMIT DELETE ROWS`
+
+	mergeStagingSQL = `INSERT INTO sensor_readings (time, sensor_id, zone, temperature, humidity, co_level, battery_level,
+			raw_temperature, raw_humidity, raw_co_level, raw_battery_level)
+		SELECT time, sensor_id, zone, temperature, humidity, co_level, battery_level,
+			raw_temperature, raw_humidity, raw_co_level, raw_battery_level
+		FROM sensor_readings_staging
+		ON CONFLICT (sensor_id, time) DO NOTHING`
+)
+
+var tracer = otel.Tracer("github.com/knightfall22/Phylax/internals/sink")
+
+// Postgres writes readings into the sensor_readings table.
+// The pool is owned by the caller and is not closed by Close.
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgres(pool *pgxpool.Pool) *Postgres {
+	return &Postgres{pool: pool}
+}
+
+func (s *Postgres) Write(ctx context.Context, readings []*pb.SensorReading) error {
+	rows := make([][]interface{}, 0, len(readings))
+	for _, reading := range readings {
+		// Raw columns stay NULL for uncalibrated readings
+		var rawTemperature, rawHumidity, rawCoLevel, rawBatteryLevel *float64
+		if raw := reading.GetRaw(); raw != nil {
+			rawTemperature, rawHumidity = &raw.Temperature, &raw.Humidity
+			rawCoLevel, rawBatteryLevel = &raw.CoLevel, &raw.BatteryLevel
+		}
+
+		rows = append(rows, []interface{}{
+			reading.ReadingTime(),
+			reading.SensorId,
+			reading.SensorZone,
+			reading.Temperature,
+			reading.Humidity,
+			reading.CoLevel,
+			reading.BatteryLevel,
+			rawTemperature,
+			rawHumidity,
+			rawCoLevel,
+			rawBatteryLevel,
+		})
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, createStagingSQL); err != nil {
+		return err
+	}
+
+	copyCtx, span := tracer.Start(ctx, "postgres.copy_from", trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.collection.name", "sensor_readings_staging"),
+		attribute.Int("db.rows", len(rows)),
+	))
+	_, err = tx.CopyFrom(
+		copyCtx,
+		pgx.Identifier{"sensor_readings_staging"},
+		readingColumns,
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return err
+	}
+	span.End()
+
+	if _, err := tx.Exec(ctx, mergeStagingSQL); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *Postgres) Close() error {
+	return nil
+}
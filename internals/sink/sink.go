@@ -0,0 +1,15 @@
+package sink
+
+import (
+	"context"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+)
+
+// Sink is a destination for decoded sensor readings.
+// Write must only return nil once the readings are durably stored, since
+// the processor acknowledges the batch to NATS on success.
+type Sink interface {
+	Write(ctx context.Context, readings []*pb.SensorReading) error
+	Close() error
+}
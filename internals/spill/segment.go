@@ -0,0 +1,177 @@
+package spill
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Each batch is one record:
+//
+//	uint32 length | uint32 CRC-32C of payload | payload
+//
+// and the payload is the number of readings followed by each reading as a
+// length-prefixed protobuf, all lengths as uvarints.
+const (
+	headerSize    = 8
+	segmentPrefix = "segment-"
+	segmentSuffix = ".spill"
+	// Upper bound on a record, guards against reading a corrupt length
+	maxRecordSize = 256 << 20
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// A record was cut short or does not match its checksum, usually a
+	// write interrupted by a crash
+	errCorrupt = errors.New("corrupt spill record")
+)
+
+func segmentName(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d%s", segmentPrefix, id, segmentSuffix))
+}
+
+// Lists segment IDs in dir, oldest first
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []uint64{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func encodeBatch(readings []*pb.SensorReading) ([]byte, error) {
+	buf := make([]byte, headerSize, headerSize+len(readings)*64)
+	buf = binary.AppendUvarint(buf, uint64(len(readings)))
+
+	for _, r := range readings {
+		data, err := proto.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(data)))
+		buf = append(buf, data...)
+	}
+
+	payload := buf[headerSize:]
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	return buf, nil
+}
+
+func decodeBatch(payload []byte) ([]*pb.SensorReading, error) {
+	count, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return nil, errCorrupt
+	}
+	payload = payload[n:]
+
+	readings := make([]*pb.SensorReading, 0, count)
+	for range count {
+		size, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < size {
+			return nil, errCorrupt
+		}
+		payload = payload[n:]
+
+		var r pb.SensorReading
+		if err := proto.Unmarshal(payload[:size], &r); err != nil {
+			return nil, fmt.Errorf("%w: %v", errCorrupt, err)
+		}
+		readings = append(readings, &r)
+		payload = payload[size:]
+	}
+	return readings, nil
+}
+
+// Reads records of one segment in order
+type segmentReader struct {
+	f      *os.File
+	r      *bufio.Reader
+	offset int64
+}
+
+func openSegment(path string) (*segmentReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &segmentReader{f: f, r: bufio.NewReader(f)}, nil
+}
+
+// Returns the next batch and the offset just past it. io.EOF means the end
+// of what has been written so far.
+func (s *segmentReader) next() ([]*pb.SensorReading, int64, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(s.r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, s.offset, io.EOF
+		}
+		return nil, s.offset, err
+	}
+
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return nil, s.offset, errCorrupt
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(s.r, payload); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return nil, s.offset, io.EOF
+		}
+		return nil, s.offset, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, s.offset, errCorrupt
+	}
+
+	readings, err := decodeBatch(payload)
+	if err != nil {
+		return nil, s.offset, err
+	}
+	s.offset += headerSize + int64(size)
+	return readings, s.offset, nil
+}
+
+// Rewinds to offset, e.g. after a partial record was read
+func (s *segmentReader) seek(offset int64) error {
+	if _, err := s.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	s.r.Reset(s.f)
+	s.offset = offset
+	return nil
+}
+
+func (s *segmentReader) close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,176 @@
+package spill
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func testReadings(ids ...string) []*pb.SensorReading {
+	readings := make([]*pb.SensorReading, 0, len(ids))
+	for i, id := range ids {
+		readings = append(readings, &pb.SensorReading{SensorId: id, SensorZone: "office", Temperature: float64(20 + i)})
+	}
+	return readings
+}
+
+// Writes records to a segment file and returns its path
+func writeSegment(t *testing.T, records ...[]byte) string {
+	t.Helper()
+
+	path := segmentName(t.TempDir(), 0)
+	if err := os.WriteFile(path, slices.Concat(records...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func mustEncode(t *testing.T, readings []*pb.SensorReading) []byte {
+	t.Helper()
+
+	record, err := encodeBatch(readings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestSegmentRoundTrip(t *testing.T) {
+	batches := [][]*pb.SensorReading{
+		testReadings("a"),
+		testReadings("b", "c", "d"),
+		{},
+	}
+
+	var records [][]byte
+	for _, b := range batches {
+		records = append(records, mustEncode(t, b))
+	}
+	r, err := openSegment(writeSegment(t, records...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+
+	var offset int64
+	for i, want := range batches {
+		got, end, err := r.next()
+		if err != nil {
+			t.Fatalf("batch %d: %v", i, err)
+		}
+		offset += int64(len(records[i]))
+		if end != offset {
+			t.Fatalf("batch %d ends at %d, want %d", i, end, offset)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("batch %d has %d readings, want %d", i, len(got), len(want))
+		}
+		for j := range want {
+			if !proto.Equal(got[j], want[j]) {
+				t.Fatalf("batch %d reading %d = %v, want %v", i, j, got[j], want[j])
+			}
+		}
+	}
+
+	if _, _, err := r.next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("read past the last record: %v, want io.EOF", err)
+	}
+}
+
+func TestSegmentReaderDamagedTail(t *testing.T) {
+	good := mustEncode(t, testReadings("a"))
+	last := mustEncode(t, testReadings("b", "c"))
+
+	flipped := slices.Clone(last)
+	flipped[len(flipped)-1] ^= 0xff
+
+	oversized := slices.Clone(last)
+	oversized[3] = 0xff
+
+	tests := []struct {
+		name string
+		tail []byte
+		want error
+	}{
+		{"torn header", last[:headerSize-3], io.EOF},
+		{"torn payload", last[:len(last)-2], io.EOF},
+		{"checksum mismatch", flipped, errCorrupt},
+		{"length beyond limit", oversized, errCorrupt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := openSegment(writeSegment(t, good, tt.tail))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.close()
+
+			if _, _, err := r.next(); err != nil {
+				t.Fatalf("intact record: %v", err)
+			}
+			readings, end, err := r.next()
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("damaged record: %v, want %v", err, tt.want)
+			}
+			if readings != nil || end != int64(len(good)) {
+				t.Fatalf("damaged record returned %d readings ending at %d, want none ending at %d",
+					len(readings), end, len(good))
+			}
+		})
+	}
+}
+
+// A torn tail is read again once the writer has finished the record
+func TestSegmentReaderResumesAfterTornTail(t *testing.T) {
+	record := mustEncode(t, testReadings("a"))
+	path := writeSegment(t, record[:len(record)-1])
+
+	r, err := openSegment(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+
+	if _, _, err := r.next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("torn record: %v, want io.EOF", err)
+	}
+	if err := os.WriteFile(path, record, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.seek(0); err != nil {
+		t.Fatal(err)
+	}
+	if readings, _, err := r.next(); err != nil || len(readings) != 1 {
+		t.Fatalf("completed record: %d readings, %v", len(readings), err)
+	}
+}
+
+func TestListSegments(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		filepath.Base(segmentName(dir, 10)),
+		filepath.Base(segmentName(dir, 2)),
+		filepath.Base(segmentName(dir, 7)),
+		"segment-abc.spill",
+		"notes.txt",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, err := listSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{2, 7, 10}; !slices.Equal(ids, want) {
+		t.Fatalf("segments %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,473 @@
+package spill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/metrics"
+	"github.com/knightfall22/Phylax/internals/sink"
+)
+
+// When spilled batches are flushed to disk
+type SyncPolicy string
+
+const (
+	// fsync before every Write returns. Nothing acked is lost on a crash.
+	SyncAlways SyncPolicy = "always"
+	// fsync every SyncInterval. A crash can lose the last interval.
+	SyncInterval SyncPolicy = "interval"
+	// Leave flushing to the OS
+	SyncNever SyncPolicy = "never"
+)
+
+// Returned when the spill log has reached MaxBytes. The batch is left to
+// NATS redelivery instead.
+var ErrFull = errors.New("spill log full")
+
+type Options struct {
+	// Directory holding the segment files
+	Dir string
+	// Consecutive failed writes before batches are spilled
+	FailureThreshold int
+	// Segments are rotated once they reach this size
+	SegmentSize int64
+	// Cap on the total size of unreplayed segments
+	MaxBytes int64
+	Sync     SyncPolicy
+	// Used with SyncInterval
+	SyncInterval time.Duration
+	// Pause between replay attempts while the sink is still failing
+	ReplayInterval time.Duration
+	// Reports errors that retrying cannot fix. These are returned to the
+	// caller instead of being spilled.
+	Permanent func(error) bool
+	// Takes readings the sink rejects permanently during replay, e.g. to
+	// dead-letter them. If it fails the batch is replayed again later.
+	// Without it such readings are logged and dropped.
+	Reject func(ctx context.Context, reading *pb.SensorReading, err error) error
+}
+
+// Spill wraps a sink with a write-ahead log on local disk. Once writes to
+// the sink fail FailureThreshold times in a row, batches are appended to
+// segment files and reported as written so they can be acked. Segments are
+// replayed into the sink in order once it recovers, and new batches keep
+// going to the log until it is drained so ordering is preserved.
+//
+// Replayed batches may be written twice after a crash, so the sink must be
+// idempotent, as the Postgres sink is.
+//
+// Spilled batches count as written, so a circuit breaker in front of the
+// spill only sees failures before spilling starts or once it fails, e.g.
+// with ErrFull.
+type Spill struct {
+	inner sink.Sink
+	opts  Options
+	log   *slog.Logger
+
+	mu       sync.Mutex
+	failures int
+	// Unreplayed segments, oldest first, and their sizes
+	segments []uint64
+	sizes    map[uint64]int64
+	backlog  int64
+	nextID   uint64
+	// Segment being appended to
+	active   *os.File
+	activeID uint64
+	dirty    bool
+
+	// Owned by the replay goroutine
+	reader   *segmentReader
+	readerID uint64
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New opens the spill log in opts.Dir and starts replaying any segments
+// left by a previous run
+func New(inner sink.Sink, opts Options) (*Spill, error) {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 3
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = 64 << 20
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 1 << 30
+	}
+	if opts.Sync == "" {
+		opts.Sync = SyncAlways
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = time.Second
+	}
+	if opts.ReplayInterval <= 0 {
+		opts.ReplayInterval = time.Second
+	}
+	if opts.Permanent == nil {
+		opts.Permanent = func(error) bool { return false }
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	ids, err := listSegments(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spill{
+		inner: inner,
+		opts:  opts,
+		log:   logging.Component("spill"),
+		sizes: make(map[uint64]int64),
+		wake:  make(chan struct{}, 1),
+	}
+	for _, id := range ids {
+		info, err := os.Stat(segmentName(opts.Dir, id))
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, id)
+		s.sizes[id] = info.Size()
+		s.backlog += info.Size()
+		s.nextID = id + 1
+	}
+	s.updateMetrics()
+	if len(ids) > 0 {
+		s.log.Info("Replaying spilled segments", "segments", len(ids), "bytes", s.backlog, "dir", opts.Dir)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wg.Add(1)
+	go s.run(ctx)
+
+	return s, nil
+}
+
+func (s *Spill) Write(ctx context.Context, readings []*pb.SensorReading) error {
+	s.mu.Lock()
+	if len(s.segments) > 0 {
+		defer s.mu.Unlock()
+		return s.append(readings)
+	}
+	s.mu.Unlock()
+
+	err := s.inner.Write(ctx, readings)
+	if err == nil || s.opts.Permanent(err) {
+		if err == nil {
+			s.mu.Lock()
+			s.failures = 0
+			s.mu.Unlock()
+		}
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures++
+	if s.failures < s.opts.FailureThreshold {
+		return err
+	}
+
+	if spillErr := s.append(readings); spillErr != nil {
+		return errors.Join(err, spillErr)
+	}
+	s.log.Warn("Sink keeps failing, spilling batches to disk", "failures", s.failures, "dir", s.opts.Dir, logging.Err(err))
+	return nil
+}
+
+// Appends a batch to the active segment. Must be called with the lock held.
+func (s *Spill) append(readings []*pb.SensorReading) error {
+	record, err := encodeBatch(readings)
+	if err != nil {
+		return err
+	}
+
+	if s.backlog+int64(len(record)) > s.opts.MaxBytes {
+		return fmt.Errorf("%w: %d bytes pending", ErrFull, s.backlog)
+	}
+
+	if s.active == nil || (s.sizes[s.activeID] > 0 && s.sizes[s.activeID]+int64(len(record)) > s.opts.SegmentSize) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.active.Write(record); err != nil {
+		// The segment may now end in a partial record, which replay skips.
+		// Start a fresh one for the next batch.
+		s.closeActive()
+		return err
+	}
+	if s.opts.Sync == SyncAlways {
+		if err := s.active.Sync(); err != nil {
+			s.closeActive()
+			return err
+		}
+	} else {
+		s.dirty = true
+	}
+
+	s.sizes[s.activeID] += int64(len(record))
+	s.backlog += int64(len(record))
+	metrics.SpillBatches.WithLabelValues("spilled").Inc()
+	s.updateMetrics()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Seals the active segment and starts a new one. Must be called with the
+// lock held.
+func (s *Spill) rotate() error {
+	s.closeActive()
+
+	id := s.nextID
+	f, err := os.OpenFile(segmentName(s.opts.Dir, id), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	s.nextID++
+	s.active, s.activeID = f, id
+	s.segments = append(s.segments, id)
+	s.sizes[id] = 0
+	s.updateMetrics()
+	return nil
+}
+
+func (s *Spill) closeActive() {
+	if s.active == nil {
+		return
+	}
+	if err := s.active.Sync(); err != nil {
+		s.log.Error("Failed to sync spill segment", "segment", s.activeID, logging.Err(err))
+	}
+	s.active.Close()
+	s.active = nil
+	s.dirty = false
+}
+
+// Replays the backlog whenever a batch is spilled and on every tick, and
+// syncs the active segment under SyncInterval
+func (s *Spill) run(ctx context.Context) {
+	defer s.wg.Done()
+
+	replay := time.NewTicker(s.opts.ReplayInterval)
+	defer replay.Stop()
+
+	var syncC <-chan time.Time
+	if s.opts.Sync == SyncInterval {
+		ticker := time.NewTicker(s.opts.SyncInterval)
+		defer ticker.Stop()
+		syncC = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-syncC:
+			s.sync()
+		case <-s.wake:
+			s.replay(ctx)
+		case <-replay.C:
+			s.replay(ctx)
+		}
+	}
+}
+
+func (s *Spill) sync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil && s.dirty {
+		if err := s.active.Sync(); err != nil {
+			s.log.Error("Failed to sync spill segment", "segment", s.activeID, logging.Err(err))
+			return
+		}
+		s.dirty = false
+	}
+}
+
+// Writes spilled batches to the sink, oldest first, until the backlog is
+// empty or the sink fails
+func (s *Spill) replay(ctx context.Context) {
+	for ctx.Err() == nil {
+		s.mu.Lock()
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		id := s.segments[0]
+		s.mu.Unlock()
+
+		if s.reader == nil || s.readerID != id {
+			if s.reader != nil {
+				s.reader.close()
+			}
+			r, err := openSegment(segmentName(s.opts.Dir, id))
+			if err != nil {
+				s.log.Error("Failed to open spill segment", "segment", id, logging.Err(err))
+				return
+			}
+			s.reader, s.readerID = r, id
+		}
+
+		start := s.reader.offset
+		readings, end, err := s.reader.next()
+		switch {
+		case errors.Is(err, io.EOF):
+			s.reader.seek(start)
+			if !s.finishSegment(id, end) {
+				// Caught up with the writer
+				return
+			}
+			continue
+
+		case err != nil:
+			s.log.Error("Discarding rest of spill segment", "segment", id, "offset", start, logging.Err(err))
+			metrics.SpillCorruptSegments.Inc()
+			s.discardSegment(id)
+			continue
+		}
+
+		writeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err = s.inner.Write(writeCtx, readings)
+		if err != nil && s.opts.Permanent(err) {
+			err = s.writeEach(writeCtx, readings)
+		}
+		cancel()
+
+		if err != nil {
+			// Sink still down, retry the same batch on the next tick
+			s.reader.seek(start)
+			return
+		}
+
+		metrics.SpillBatches.WithLabelValues("replayed").Inc()
+		s.mu.Lock()
+		s.backlog -= end - start
+		s.failures = 0
+		s.updateMetrics()
+		s.mu.Unlock()
+	}
+}
+
+// Writes readings one at a time, handing those the sink will never accept
+// to Reject
+func (s *Spill) writeEach(ctx context.Context, readings []*pb.SensorReading) error {
+	for _, r := range readings {
+		err := s.inner.Write(ctx, []*pb.SensorReading{r})
+		if err == nil {
+			continue
+		}
+		if !s.opts.Permanent(err) {
+			return err
+		}
+
+		if s.opts.Reject == nil {
+			s.log.Error("Dropping spilled reading rejected by sink", logging.KeySensor, r.SensorId, logging.Err(err))
+			metrics.SpillReadingsRejected.WithLabelValues("dropped").Inc()
+			continue
+		}
+		if rejectErr := s.opts.Reject(ctx, r, err); rejectErr != nil {
+			return fmt.Errorf("reject reading of %s: %w", r.SensorId, rejectErr)
+		}
+		s.log.Warn("Spilled reading rejected by sink", logging.KeySensor, r.SensorId, logging.Err(err))
+		metrics.SpillReadingsRejected.WithLabelValues("dead_lettered").Inc()
+	}
+	return nil
+}
+
+// Removes a segment once everything in it has been replayed. Returns false
+// if the segment is still being written to and has nothing more to replay
+// yet.
+func (s *Spill) finishSegment(id uint64, replayed int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := s.sizes[id]
+	if s.active != nil && s.activeID == id {
+		// The writer is mid-record, wait for it to finish
+		if replayed < size {
+			return false
+		}
+		// Caught up. Seal it so the next batch goes straight to the sink.
+		s.closeActive()
+	} else if replayed < size {
+		s.log.Warn("Discarding trailing bytes of spill segment", "segment", id, "bytes", size-replayed)
+	}
+
+	s.removeSegment(id, size-replayed)
+	return true
+}
+
+func (s *Spill) discardSegment(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil && s.activeID == id {
+		s.closeActive()
+	}
+	s.removeSegment(id, s.sizes[id]-s.reader.offset)
+}
+
+// Deletes the oldest segment, of which remaining bytes were never replayed.
+// Must be called with the lock held.
+func (s *Spill) removeSegment(id uint64, remaining int64) {
+	if s.reader != nil && s.readerID == id {
+		s.reader.close()
+		s.reader = nil
+	}
+	if err := os.Remove(segmentName(s.opts.Dir, id)); err != nil {
+		s.log.Error("Failed to remove spill segment", "segment", id, logging.Err(err))
+	}
+
+	delete(s.sizes, id)
+	s.segments = s.segments[1:]
+	s.backlog -= remaining
+	if len(s.segments) == 0 {
+		s.log.Info("Spill log drained, writing to sink directly")
+	}
+	s.updateMetrics()
+}
+
+func (s *Spill) updateMetrics() {
+	metrics.SpillBacklogBytes.Set(float64(s.backlog))
+	metrics.SpillSegments.Set(float64(len(s.segments)))
+}
+
+// Close stops replaying, syncs the active segment and closes the sink.
+// Unreplayed segments are picked up on the next start.
+func (s *Spill) Close() error {
+	s.cancel()
+	s.wg.Wait()
+
+	s.mu.Lock()
+	s.closeActive()
+	if s.reader != nil {
+		s.reader.close()
+		s.reader = nil
+	}
+	s.mu.Unlock()
+
+	return s.inner.Close()
+}
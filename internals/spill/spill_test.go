@@ -0,0 +1,243 @@
+package spill
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+)
+
+var (
+	errDown     = errors.New("connection refused")
+	errRejected = errors.New("violates check constraint")
+)
+
+// Sink that can be taken down, and that rejects readings of sensor "bad"
+type testSink struct {
+	mu       sync.Mutex
+	down     bool
+	readings []string
+}
+
+func (s *testSink) Write(ctx context.Context, readings []*pb.SensorReading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.down {
+		return errDown
+	}
+	for _, r := range readings {
+		if r.SensorId == "bad" {
+			return errRejected
+		}
+	}
+	for _, r := range readings {
+		s.readings = append(s.readings, r.SensorId)
+	}
+	return nil
+}
+
+func (s *testSink) Close() error { return nil }
+
+func (s *testSink) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *testSink) written() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.readings)
+}
+
+func newTestSpill(t *testing.T, inner *testSink, opts Options) *Spill {
+	t.Helper()
+
+	if opts.Dir == "" {
+		opts.Dir = t.TempDir()
+	}
+	opts.FailureThreshold = 2
+	opts.ReplayInterval = 10 * time.Millisecond
+	opts.Permanent = func(err error) bool { return errors.Is(err, errRejected) }
+
+	s, err := New(inner, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func (s *Spill) pending() (segments int, backlog int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments), s.backlog
+}
+
+func waitDrained(t *testing.T, s *Spill) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		segments, backlog := s.pending()
+		if segments == 0 && backlog == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("spill not drained: %d segments, %d bytes", segments, backlog)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSpillAfterThresholdAndReplayInOrder(t *testing.T) {
+	inner := &testSink{down: true}
+	s := newTestSpill(t, inner, Options{})
+	ctx := context.Background()
+
+	if err := s.Write(ctx, testReadings("1")); !errors.Is(err, errDown) {
+		t.Fatalf("write below the threshold: %v, want the sink error", err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if err := s.Write(ctx, testReadings(id)); err != nil {
+			t.Fatalf("write %s once spilling: %v", id, err)
+		}
+	}
+	if segments, _ := s.pending(); segments == 0 {
+		t.Fatal("nothing spilled")
+	}
+
+	inner.setDown(false)
+	waitDrained(t, s)
+
+	// Written straight to the sink again
+	if err := s.Write(ctx, testReadings("4")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inner.written(), []string{"1", "2", "3", "4"}; !slices.Equal(got, want) {
+		t.Fatalf("sink got %v, want %v", got, want)
+	}
+}
+
+func TestSpillRotatesSegments(t *testing.T) {
+	inner := &testSink{down: true}
+	record := mustEncode(t, testReadings("x"))
+	// Two records per segment
+	s := newTestSpill(t, inner, Options{SegmentSize: int64(2 * len(record))})
+	ctx := context.Background()
+
+	s.Write(ctx, testReadings("x"))
+	for range 5 {
+		if err := s.Write(ctx, testReadings("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if segments, backlog := s.pending(); segments != 3 || backlog != int64(5*len(record)) {
+		t.Fatalf("%d segments holding %d bytes, want 3 holding %d", segments, backlog, 5*len(record))
+	}
+
+	inner.setDown(false)
+	waitDrained(t, s)
+	if got := len(inner.written()); got != 5 {
+		t.Fatalf("replayed %d readings, want 5", got)
+	}
+	if ids, err := listSegments(s.opts.Dir); err != nil || len(ids) != 0 {
+		t.Fatalf("segments left on disk: %v, %v", ids, err)
+	}
+}
+
+func TestSpillFull(t *testing.T) {
+	inner := &testSink{down: true}
+	record := mustEncode(t, testReadings("x"))
+	s := newTestSpill(t, inner, Options{MaxBytes: int64(len(record))})
+	ctx := context.Background()
+
+	s.Write(ctx, testReadings("x"))
+	if err := s.Write(ctx, testReadings("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(ctx, testReadings("x")); !errors.Is(err, ErrFull) {
+		t.Fatalf("write past MaxBytes: %v, want ErrFull", err)
+	}
+}
+
+func TestSpillReplaysSegmentsOfPreviousRun(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	first, err := New(&testSink{down: true}, Options{Dir: dir, FailureThreshold: 1, ReplayInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Write(ctx, testReadings("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	inner := &testSink{}
+	s := newTestSpill(t, inner, Options{Dir: dir})
+	waitDrained(t, s)
+	if got, want := inner.written(), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Fatalf("sink got %v, want %v", got, want)
+	}
+}
+
+func TestReplayRejectedReadings(t *testing.T) {
+	tests := []struct {
+		name      string
+		rejectErr error
+		// Whether the batch is written and removed from the log
+		drained bool
+	}{
+		{"dead-lettered", nil, true},
+		{"dead-letter failing", errors.New("no responders"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &testSink{down: true}
+			var (
+				mu       sync.Mutex
+				rejected []string
+			)
+			s := newTestSpill(t, inner, Options{
+				Reject: func(ctx context.Context, reading *pb.SensorReading, err error) error {
+					mu.Lock()
+					defer mu.Unlock()
+					rejected = append(rejected, reading.SensorId)
+					return tt.rejectErr
+				},
+			})
+			ctx := context.Background()
+
+			s.Write(ctx, testReadings("a"))
+			if err := s.Write(ctx, testReadings("a", "bad", "b")); err != nil {
+				t.Fatal(err)
+			}
+			inner.setDown(false)
+
+			if tt.drained {
+				waitDrained(t, s)
+				if got, want := inner.written(), []string{"a", "b"}; !slices.Equal(got, want) {
+					t.Fatalf("sink got %v, want %v", got, want)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if !slices.Equal(rejected, []string{"bad"}) {
+					t.Fatalf("rejected %v, want [bad]", rejected)
+				}
+				return
+			}
+
+			time.Sleep(100 * time.Millisecond)
+			if segments, _ := s.pending(); segments == 0 {
+				t.Fatal("batch removed from the log although the rejected reading was not dead-lettered")
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	// Tracing disabled. Spans are still created but never recorded.
+	ExporterNone = "none"
+	// OTLP over gRPC, configured by the standard OTEL_EXPORTER_OTLP_*
+	// variables
+	ExporterOTLP = "otlp"
+	// One JSON document per span on stdout
+	ExporterStdout = "stdout"
+	// Like stdout but appended to Options.File, for offline testing
+	ExporterFile = "file"
+)
+
+type Options struct {
+	ServiceName string
+	Exporter    string
+	// Output of the file exporter
+	File string
+	// Share of traces started here that are recorded, 0 to 1. Traces
+	// continued from a message header follow the publisher's decision.
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator. The returned function flushes buffered spans and must be
+// called on shutdown.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var closer io.Closer
+	switch opts.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+
+	case ExporterOTLP:
+		exp, err := otlptracegrpc.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("otlp exporter: %w", err)
+		}
+		exporter = exp
+
+	case ExporterStdout:
+		exp, err := stdouttrace.New()
+		if err != nil {
+			return nil, err
+		}
+		exporter = exp
+
+	case ExporterFile:
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("trace file: %w", err)
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		exporter, closer = exp, f
+
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, want none, otlp, stdout or file", opts.Exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", opts.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+// Inject writes the trace context of ctx into NATS message headers
+func Inject(ctx context.Context, header nats.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(header))
+}
+
+// Extract returns ctx carrying the trace context found in NATS message
+// headers, if any
+func Extract(ctx context.Context, header nats.Header) context.Context {
+	if header == nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier(header))
+}
+
+// Adapts nats.Header to propagation.TextMapCarrier
+type headerCarrier nats.Header
+
+func (c headerCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+func (c headerCarrier) Set(key, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,98 @@
+package validation
+
+import (
+	"math"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/processor"
+)
+
+// Reason codes recorded with quarantined readings. Out of range readings
+// use "<metric>_out_of_range", e.g. humidity_out_of_range.
+const (
+	ReasonMissingSensorID  = "missing_sensor_id"
+	ReasonMissingZone      = "missing_zone"
+	ReasonMissingTimestamp = "missing_timestamp"
+	ReasonFutureTimestamp  = "future_timestamp"
+	ReasonNotFinite        = "not_finite"
+	ReasonOutOfRangeSuffix = "_out_of_range"
+)
+
+// Inclusive range of plausible values for a metric
+type Bounds struct {
+	Min float64
+	Max float64
+}
+
+// DefaultBounds are physical limits of the metrics, not alert thresholds
+var DefaultBounds = map[string]Bounds{
+	"temperature":   {Min: -40, Max: 85},
+	"humidity":      {Min: 0, Max: 100},
+	"co_level":      {Min: 0, Max: 1000},
+	"battery_level": {Min: 0, Max: 100},
+}
+
+type Options struct {
+	// Per metric bounds. Metrics not listed are only checked for NaN and
+	// infinity.
+	Bounds map[string]Bounds
+	// How far in the future a reading's timestamp may be
+	MaxClockSkew time.Duration
+}
+
+// Validator quarantines readings that are implausible: missing identity,
+// values out of range, or timestamps too far in the future
+type Validator struct {
+	opts Options
+}
+
+func New(opts Options) *Validator {
+	if opts.Bounds == nil {
+		opts.Bounds = DefaultBounds
+	}
+	if opts.MaxClockSkew <= 0 {
+		opts.MaxClockSkew = 5 * time.Minute
+	}
+
+	return &Validator{opts: opts}
+}
+
+// Admit implements processor.Gate
+func (v *Validator) Admit(reading *pb.SensorReading) (processor.Verdict, string) {
+	if reason := v.check(reading); reason != "" {
+		return processor.Quarantine, reason
+	}
+	return processor.Accept, ""
+}
+
+// Returns the first problem found, or "" for a valid reading
+func (v *Validator) check(reading *pb.SensorReading) string {
+	if reading.SensorId == "" {
+		return ReasonMissingSensorID
+	}
+	if reading.SensorZone == "" {
+		return ReasonMissingZone
+	}
+
+	at := reading.ReadingTime()
+	if at.Unix() <= 0 {
+		return ReasonMissingTimestamp
+	}
+	if time.Until(at) > v.opts.MaxClockSkew {
+		return ReasonFutureTimestamp
+	}
+
+	for _, metric := range []string{"temperature", "humidity", "co_level", "battery_level"} {
+		value, _ := pb.MetricValue(reading, metric)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return ReasonNotFinite
+		}
+
+		b, ok := v.opts.Bounds[metric]
+		if ok && (value < b.Min || value > b.Max) {
+			return metric + ReasonOutOfRangeSuffix
+		}
+	}
+	return ""
+}
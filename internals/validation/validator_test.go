@@ -0,0 +1,83 @@
+package validation
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	pb "github.com/knightfall22/Phylax/api/v1"
+	"github.com/knightfall22/Phylax/internals/processor"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestAdmit(t *testing.T) {
+	now := time.Now()
+	valid := func() *pb.SensorReading {
+		return &pb.SensorReading{
+			SensorId:     "s1",
+			SensorZone:   "office",
+			Timestamp:    now.UnixMilli(),
+			Temperature:  21,
+			Humidity:     45,
+			CoLevel:      2,
+			BatteryLevel: 90,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *pb.SensorReading)
+		want   string
+	}{
+		{"valid", func(r *pb.SensorReading) {}, ""},
+		{"bounds are inclusive", func(r *pb.SensorReading) { r.Temperature, r.Humidity = -40, 100 }, ""},
+		{"timestamp in seconds", func(r *pb.SensorReading) { r.Timestamp = now.Unix() }, ""},
+		{"skew within the limit", func(r *pb.SensorReading) { r.Timestamp = now.Add(time.Minute).UnixMilli() }, ""},
+		{"missing sensor", func(r *pb.SensorReading) { r.SensorId = "" }, ReasonMissingSensorID},
+		{"missing zone", func(r *pb.SensorReading) { r.SensorZone = "" }, ReasonMissingZone},
+		{"missing timestamp", func(r *pb.SensorReading) { r.Timestamp = 0 }, ReasonMissingTimestamp},
+		{"future timestamp", func(r *pb.SensorReading) { r.Timestamp = now.Add(time.Hour).UnixMilli() }, ReasonFutureTimestamp},
+		{"future observed_at", func(r *pb.SensorReading) {
+			r.ObservedAt = timestamppb.New(now.Add(time.Hour))
+		}, ReasonFutureTimestamp},
+		{"NaN", func(r *pb.SensorReading) { r.Humidity = math.NaN() }, ReasonNotFinite},
+		{"infinity", func(r *pb.SensorReading) { r.CoLevel = math.Inf(1) }, ReasonNotFinite},
+		{"too cold", func(r *pb.SensorReading) { r.Temperature = -41 }, "temperature_out_of_range"},
+		{"humidity over 100", func(r *pb.SensorReading) { r.Humidity = 100.5 }, "humidity_out_of_range"},
+		{"negative CO", func(r *pb.SensorReading) { r.CoLevel = -1 }, "co_level_out_of_range"},
+		{"battery over 100", func(r *pb.SensorReading) { r.BatteryLevel = 101 }, "battery_level_out_of_range"},
+		{"first problem wins", func(r *pb.SensorReading) { r.SensorZone, r.Temperature = "", 1000 }, ReasonMissingZone},
+	}
+
+	v := New(Options{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reading := valid()
+			tt.modify(reading)
+
+			verdict, reason := v.Admit(reading)
+			wantVerdict := processor.Quarantine
+			if tt.want == "" {
+				wantVerdict = processor.Accept
+			}
+			if verdict != wantVerdict || reason != tt.want {
+				t.Fatalf("Admit = %v, %q, want %v, %q", verdict, reason, wantVerdict, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomBounds(t *testing.T) {
+	v := New(Options{Bounds: map[string]Bounds{"co_level": {Min: 0, Max: 50}}})
+	reading := &pb.SensorReading{SensorId: "s1", SensorZone: "office", Timestamp: time.Now().UnixMilli(), CoLevel: 60}
+
+	if _, reason := v.Admit(reading); reason != "co_level_out_of_range" {
+		t.Fatalf("reason %q, want co_level_out_of_range", reason)
+	}
+
+	// Metrics without bounds are only checked for being finite
+	reading.CoLevel, reading.Temperature = 10, 500
+	if verdict, reason := v.Admit(reading); verdict != processor.Accept {
+		t.Fatalf("Admit = %v, %q, want accepted", verdict, reason)
+	}
+}
@@ -2,22 +2,48 @@ package main
 
 import (
 	"context"
-	"os"
+	"log"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/knightfall22/Phylax/config"
+	"github.com/knightfall22/Phylax/internals/logging"
 )
 
+// How long in-flight batches get to reach Postgres once a signal arrives
+const ShutdownTimeout = 20 * time.Second
+
 func main() {
 	conf := config.LoadConfigurations()
-	ctx := context.Background()
+
+	err := logging.Setup(logging.Options{
+		Format: conf.LogFormat,
+		Level:  conf.LogLevel,
+		Sampling: logging.SampleOptions{
+			Tick:       time.Second,
+			First:      conf.LogSampleFirst,
+			Thereafter: conf.LogSampleThereafter,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// Cancelled only after the orderly shutdown completes, acting as a
+	// backstop for anything Close did not stop
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	app := Run(ctx, conf)
-	defer app.Close()
 
-	var sigs chan os.Signal = make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCtx.Done()
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancelShutdown()
 
-	<-sigs
+	app.Close(shutdownCtx)
 }
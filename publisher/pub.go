@@ -2,13 +2,46 @@ package publisher
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	globalConfig "github.com/knightfall22/Phylax/config"
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/tracing"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Readings are published to sensors.<zone>.<sensor id>
+const ReadingSubjectPrefix = "sensors."
+
+// ReadingSubject returns the subject matching readings of a zone and/or
+// sensor. Empty values match any zone or sensor.
+func ReadingSubject(zone, sensorID string) (string, error) {
+	tokens := []string{"*", "*"}
+	for i, v := range []string{zone, sensorID} {
+		if v == "" {
+			continue
+		}
+		if strings.ContainsAny(v, ".*> \t") {
+			return "", errors.New("zone and sensor_id must not contain '.', '*', '>' or whitespace")
+		}
+		tokens[i] = v
+	}
+	return ReadingSubjectPrefix + strings.Join(tokens, "."), nil
+}
+
+var tracer = otel.Tracer("github.com/knightfall22/Phylax/publisher")
+
+// How long the stream remembers message ids for deduplication
+const DuplicateWindow = 2 * time.Minute
+
 type NatsPublisher struct {
 	nc     *nats.Conn
 	js     jetstream.JetStream
@@ -23,12 +56,43 @@ type NATSConnectionOptions struct {
 	URL        string
 }
 
+// Redelivery policy and flow control applied to the processor consumer
+type ConsumerOptions struct {
+	// Maximum number of delivery attempts, after which the server stops
+	// redelivering a message
+	MaxDeliver int
+	// Redelivery intervals for messages that were not acknowledged in time.
+	// Overrides AckWait when set.
+	BackOff []time.Duration
+	// Defaults to 30s. Replaced by BackOff[0] when BackOff is set.
+	AckWait time.Duration
+	// Defaults to 32000
+	MaxAckPending int
+}
+
 func NATSConnect(ctx context.Context, cfg NATSConnectionOptions) (*NatsPublisher, error) {
 	if cfg.URL == "" {
 		cfg.URL = nats.DefaultURL
 	}
 
-	var opts []nats.Option
+	logger := logging.Component("publisher")
+	opts := []nats.Option{
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			// err is nil when the connection is closed on purpose
+			if err == nil {
+				return
+			}
+			logger.Warn("Disconnected from NATS", "url", nc.ConnectedUrlRedacted(), logging.Err(err))
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			logger.Info("Reconnected to NATS", "url", nc.ConnectedUrlRedacted())
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			if err := nc.LastError(); err != nil {
+				logger.Error("NATS connection closed", logging.Err(err))
+			}
+		}),
+	}
 	if cfg.TLSEnabled {
 		tlsConfig, err := globalConfig.SetupTLSConfig(globalConfig.TLSConfig{
 			CertFile: cfg.ClientCert,
@@ -54,7 +118,9 @@ func NATSConnect(ctx context.Context, cfg NATSConnectionOptions) (*NatsPublisher
 	jsConf := jetstream.StreamConfig{
 		Name:      "SENSORS_READINGS",
 		Retention: jetstream.WorkQueuePolicy,
-		Subjects:  []string{"sensors.>"},
+		Subjects:  []string{ReadingSubjectPrefix + ">"},
+		// Publishes carrying a Nats-Msg-Id seen within this window are dropped
+		Duplicates: DuplicateWindow,
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
@@ -72,43 +138,130 @@ func NATSConnect(ctx context.Context, cfg NATSConnectionOptions) (*NatsPublisher
 	}, nil
 }
 
+// JetStream exposes the underlying JetStream context for subsystems that
+// manage their own streams, such as the dead-letter queue.
+func (p *NatsPublisher) JetStream() jetstream.JetStream {
+	return p.js
+}
+
+// Subscribe registers a core NATS subscription. Unlike Consume it does not
+// take part in the work queue, so every subscriber sees every message.
+func (p *NatsPublisher) Subscribe(subject string, ch chan *nats.Msg) (*nats.Subscription, error) {
+	return p.nc.ChanSubscribe(subject, ch)
+}
+
+// Close waits for pending async publishes and acks to reach the server
+// before closing the connection
 func (p *NatsPublisher) Close() {
-	p.nc.Drain()
+	select {
+	case <-p.js.PublishAsyncComplete():
+	case <-time.After(5 * time.Second):
+	}
+
+	p.nc.FlushTimeout(5 * time.Second)
 	p.nc.Close()
 }
 
-func (p *NatsPublisher) Publish(ctx context.Context, subject string, payload []byte) error {
+// Publish sends payload to the stream. Pass jetstream.WithMsgID to let the
+// stream drop duplicates of the same message.
+//
+// The trace context of the publish span is carried in the message headers
+// so the processor can continue the trace.
+func (p *NatsPublisher) Publish(ctx context.Context, subject string, payload []byte, opts ...jetstream.PublishOpt) error {
+	ctx, span := tracer.Start(ctx, "nats.publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "nats"),
+			attribute.String("messaging.destination.name", subject),
+			attribute.Int("messaging.message.body.size", len(payload)),
+		),
+	)
+	defer span.End()
+
+	msg := nats.NewMsg(subject)
+	msg.Data = payload
+	tracing.Inject(ctx, msg.Header)
+
 	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
-	_, err := p.js.Publish(ctx, subject, payload)
+	_, err := p.js.PublishMsg(ctx, msg, opts...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
 	return err
 }
 
-func (p *NatsPublisher) PublishAsync(subject string, payload []byte) error {
-	_, err := p.js.PublishAsync(subject, payload)
+func (p *NatsPublisher) PublishAsync(subject string, payload []byte, opts ...jetstream.PublishOpt) error {
+	_, err := p.js.PublishAsync(subject, payload, opts...)
 	return err
 }
 
-func (p *NatsPublisher) Consume(ctx context.Context, handler func(jetstream.Msg)) (jetstream.ConsumeContext, error) {
-	config := jetstream.ConsumerConfig{
+func processorConsumerConfig(opts ConsumerOptions) jetstream.ConsumerConfig {
+	if len(opts.BackOff) > 0 {
+		// The server waits BackOff[0] for the first ack regardless, so the
+		// consumer info should say so
+		opts.AckWait = opts.BackOff[0]
+	} else if opts.AckWait <= 0 {
+		opts.AckWait = 30 * time.Second
+	}
+	if opts.MaxAckPending <= 0 {
+		// (WorkerCount * BatchSize) * 2
+		// 16 * 1000 * 2 = 32000
+		opts.MaxAckPending = 32000
+	}
+
+	return jetstream.ConsumerConfig{
 		Name:          "PROCESSOR_WORKERS",
 		Durable:       "PROCESSOR_WORKERS",
 		AckPolicy:     jetstream.AckExplicitPolicy,
-		FilterSubject: "sensors.>",
-		AckWait:       30 * time.Second,
-		// MaxAckPending: (WorkerCount * BatchSize) * 2
-		// 16 * 1000 * 2 = 32000
-		MaxAckPending: 32000,
+		FilterSubject: ReadingSubjectPrefix + ">",
+		AckWait:       opts.AckWait,
+		MaxDeliver:    opts.MaxDeliver,
+		BackOff:       opts.BackOff,
+		MaxAckPending: opts.MaxAckPending,
 	}
+}
 
-	consumer, err := p.js.CreateOrUpdateConsumer(ctx, "SENSORS_READINGS", config)
-	if err != nil {
-		return nil, err
+// ProcessorConsumer creates or updates the PROCESSOR_WORKERS pull consumer.
+// Processor workers fetch from it directly.
+func (p *NatsPublisher) ProcessorConsumer(ctx context.Context, opts ConsumerOptions) (jetstream.Consumer, error) {
+	return p.js.CreateOrUpdateConsumer(ctx, "SENSORS_READINGS", processorConsumerConfig(opts))
+}
+
+// UpdateConsumer applies new options to the processor consumer. Fetches in
+// progress are not interrupted.
+func (p *NatsPublisher) UpdateConsumer(ctx context.Context, opts ConsumerOptions) error {
+	_, err := p.js.UpdateConsumer(ctx, "SENSORS_READINGS", processorConsumerConfig(opts))
+	return err
+}
+
+// CheckConnection fails unless the connection to the server is up
+func (p *NatsPublisher) CheckConnection(ctx context.Context) error {
+	if status := p.nc.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("nats connection %s", status)
 	}
+	return nil
+}
 
-	consumerCxt, err := consumer.Consume(func(msg jetstream.Msg) {
-		handler(msg)
-	})
+// CheckClosed fails once the client has given up reconnecting. Only a
+// restart recovers from that.
+func (p *NatsPublisher) CheckClosed(ctx context.Context) error {
+	if p.nc.IsClosed() {
+		return errors.New("nats connection closed")
+	}
+	return nil
+}
 
-	return consumerCxt, err
+// CheckConsumer fails unless the SENSORS_READINGS stream and the
+// PROCESSOR_WORKERS consumer exist
+func (p *NatsPublisher) CheckConsumer(ctx context.Context) error {
+	stream, err := p.js.Stream(ctx, "SENSORS_READINGS")
+	if err != nil {
+		return fmt.Errorf("stream SENSORS_READINGS: %w", err)
+	}
+	if _, err := stream.Consumer(ctx, "PROCESSOR_WORKERS"); err != nil {
+		return fmt.Errorf("consumer PROCESSOR_WORKERS: %w", err)
+	}
+	return nil
 }
@@ -1,11 +1,12 @@
 package config
 
 import (
-	"log"
+	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/knightfall22/Phylax/internals/logging"
 	"github.com/spf13/viper"
 )
 
@@ -39,6 +40,13 @@ type SimulationConfig struct {
 	RootCA     string `mapstructure:"root_ca"`
 
 	NATSURL string `mapstructure:"nats_url"`
+
+	LogLevel  string `mapstructure:"log_level"`  // debug, info, warn or error
+	LogFormat string `mapstructure:"log_format"` // text or json
+
+	TracingExporter    string  `mapstructure:"tracing_exporter"` // none, otlp, stdout or file
+	TracingFile        string  `mapstructure:"tracing_file"`
+	TracingSampleRatio float64 `mapstructure:"tracing_sample_ratio"` // Share of readings traced, 0 to 1
 }
 
 type EditableConfig struct {
@@ -55,7 +63,7 @@ func LoadConguration() *EditableConfig {
 	var cfg EditableConfig
 
 	if err := viper.ReadInConfig(); err != nil {
-		log.Panicf("Faild to read configuration. %v", err)
+		logging.Fatal(slog.Default(), "Failed to read configuration", logging.Err(err))
 	}
 
 	cfg.mu.Lock()
@@ -63,7 +71,7 @@ func LoadConguration() *EditableConfig {
 	cfg.mu.Unlock()
 
 	viper.OnConfigChange(func(e fsnotify.Event) {
-		log.Println("Config file changed:", e.Name)
+		slog.Info("Config file changed", "file", e.Name)
 
 		cfg.mu.Lock()
 		defer cfg.mu.Unlock()
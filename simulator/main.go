@@ -3,11 +3,13 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"sync"
 	"sync/atomic"
 
+	"github.com/knightfall22/Phylax/internals/logging"
+	"github.com/knightfall22/Phylax/internals/tracing"
 	"github.com/knightfall22/Phylax/publisher"
 	"github.com/knightfall22/Phylax/simulator/config"
 )
@@ -21,7 +23,27 @@ func main() {
 	cfg := config.LoadConguration()
 	sensorsCounts := cfg.Config.SensorCount
 
-	fmt.Println(cfg.Config.NATSURL)
+	level, err := logging.ParseLevel(cfg.Config.LogLevel)
+	if err == nil {
+		err = logging.Setup(logging.Options{Format: cfg.Config.LogFormat, Level: level})
+	}
+	if err != nil {
+		logging.Fatal(slog.Default(), "Failed to set up logging", logging.Err(err))
+	}
+	logger := logging.Component("simulator")
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Options{
+		ServiceName: "phylax-simulator",
+		Exporter:    cfg.Config.TracingExporter,
+		File:        cfg.Config.TracingFile,
+		SampleRatio: cfg.Config.TracingSampleRatio,
+	})
+	if err != nil {
+		logging.Fatal(logger, "Failed to set up tracing", logging.Err(err))
+	}
+	defer shutdownTracing(context.Background())
+
+	logger.Info("Connecting to NATS", "url", cfg.Config.NATSURL)
 
 	natsConn, err := publisher.NATSConnect(ctx, publisher.NATSConnectionOptions{
 		TLSEnabled: cfg.Config.TLSEnabled,
@@ -31,7 +53,7 @@ func main() {
 		URL:        cfg.Config.NATSURL,
 	})
 	if err != nil {
-		panic(err)
+		logging.Fatal(logger, "Cannot connect to NATS server", logging.Err(err))
 	}
 
 	defer natsConn.Close()
@@ -43,15 +65,18 @@ func main() {
 	var errorCount atomic.Uint64
 	maxErrCount := uint64(float64(sensorsCounts) * 0.25)
 
+	// Every sensor reports through here, so a NATS outage would otherwise
+	// log thousands of lines a second
+	sampled := logging.Sampled(logger)
 	errorHandler := func(err error) {
 		if err == nil {
 			return
 		}
 
 		current := errorCount.Add(1)
-		log.Printf("[ERROR] %v (Total: %d/%d)", err, current, maxErrCount)
+		sampled.Error("Sensor error", logging.Err(err), "errors", current, "max_errors", maxErrCount)
 		if current == maxErrCount {
-			log.Fatal("[ERROR] Too many errors. Exiting...")
+			logging.Fatal(logger, "Too many errors, exiting", "errors", current)
 			cancel()
 		}
 	}
@@ -63,14 +88,14 @@ func main() {
 		// e.g. 1000 * 0.05 = 50 sensors
 		count := int(zone.Percent * float64(sensorsCounts))
 
-		fmt.Printf("Creating Zone '%s': %d sensors\n", zone.Name, count)
+		logger.Info("Creating zone", logging.KeyZone, zone.Name, "sensors", count)
 		for range count {
 			// Safety check to prevent index out of bounds if config ratios > 1.0
 			if currentSensorIdx >= sensorsCounts {
 				break
 			}
 
-			spawnSensorReaders(ctx, currentSensorIdx, &cfg.Config, zone, errorHandler, natsConn, &wg)
+			spawnSensorReaders(ctx, currentSensorIdx, &cfg.Config, zone, errorHandler, natsConn, sampled, &wg)
 			currentSensorIdx++
 		}
 	}
@@ -79,14 +104,14 @@ func main() {
 	// e.g. Sensors 150 to 999 become "Office"
 	remaining := sensorsCounts - currentSensorIdx
 	if remaining > 0 {
-		fmt.Printf("Creating Default Zone '%s': %d sensors\n", cfg.Config.DefaultZone.Name, remaining)
+		logger.Info("Creating default zone", logging.KeyZone, cfg.Config.DefaultZone.Name, "sensors", remaining)
 		for range remaining {
 			spawnSensorReaders(
 				ctx,
 				currentSensorIdx,
 				&cfg.Config,
 				cfg.Config.DefaultZone,
-				errorHandler, natsConn, &wg)
+				errorHandler, natsConn, sampled, &wg)
 
 			currentSensorIdx++
 		}
@@ -102,6 +127,7 @@ func spawnSensorReaders(
 	zone config.ZoneConfig,
 	onError func(err error),
 	publisher *publisher.NatsPublisher,
+	logger *slog.Logger,
 	wg *sync.WaitGroup,
 ) {
 	id := fmt.Sprintf("sensor-%d", index)
@@ -120,5 +146,5 @@ func spawnSensorReaders(
 		},
 	)
 
-	go StartSimulator(ctx, sensor, cfg, publisher, onError, wg)
+	go StartSimulator(ctx, sensor, cfg, publisher, onError, logger.With(logging.KeySensor, id, logging.KeyZone, zone.Name), wg)
 }
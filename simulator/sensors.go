@@ -3,7 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"math/rand"
 	"sync"
@@ -12,7 +12,9 @@ import (
 	pb "github.com/knightfall22/Phylax/api/v1"
 	"github.com/knightfall22/Phylax/publisher"
 	"github.com/knightfall22/Phylax/simulator/config"
+	"github.com/nats-io/nats.go/jetstream"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // Sensor reading to be sent to NATS
@@ -27,10 +29,15 @@ type SensorReading struct {
 	BatteryLevel float64 `json:"battery_level"`
 }
 
-// TODO: Add proper logger
-func (sr *SensorReading) logReading() {
-	log.Printf("Sensor(%q) Temp: %.2f, Humidity: %.2f, CO: %.2f, BatteryLevel: %.2f, Zone: %q, Time: %d\n",
-		sr.SensorID, sr.Temperature, sr.Humidity, sr.CO, sr.BatteryLevel, sr.SensorZone, sr.Timestamp,
+// Logs a published reading at debug level. logger already carries the
+// sensor and zone.
+func logReading(logger *slog.Logger, r *pb.SensorReading) {
+	logger.Debug("Published reading",
+		"temperature", r.Temperature,
+		"humidity", r.Humidity,
+		"co_level", r.CoLevel,
+		"battery_level", r.BatteryLevel,
+		"timestamp", r.Timestamp,
 	)
 }
 
@@ -160,10 +167,12 @@ func (s *SensorState) Tick() *pb.SensorReading {
 	}
 
 	// Return the View (DTO)
+	now := time.Now().UTC()
 	return &pb.SensorReading{
 		SensorId:     s.ID,
 		SensorZone:   s.ZoneID,
-		Timestamp:    time.Now().UTC().UnixMilli(),
+		ObservedAt:   timestamppb.New(now),
+		Timestamp:    now.UnixMilli(),
 		Temperature:  round(s.Temperature),
 		Humidity:     round(s.Humidity),
 		CoLevel:      round(s.CO),
@@ -179,8 +188,9 @@ func StartSimulator(
 	ctx context.Context,
 	sensor *SensorState,
 	cfg *config.SimulationConfig,
-	publisher *publisher.NatsPublisher,
+	nc *publisher.NatsPublisher,
 	onError func(err error),
+	logger *slog.Logger,
 	wg *sync.WaitGroup,
 ) {
 
@@ -190,11 +200,11 @@ func StartSimulator(
 	ticker := time.NewTicker(cfg.TickRate)
 	defer func() {
 		ticker.Stop()
-		fmt.Println(sensor.ID)
+		logger.Debug("Sensor stopped")
 		wg.Done()
 	}()
 
-	topic := fmt.Sprintf("sensors.%s.%s", sensor.ZoneID, sensor.ID)
+	topic := fmt.Sprintf("%s%s.%s", publisher.ReadingSubjectPrefix, sensor.ZoneID, sensor.ID)
 	for {
 		select {
 		case <-ctx.Done():
@@ -207,10 +217,15 @@ func StartSimulator(
 				if err != nil {
 					onError(err)
 				}
-				err = publisher.Publish(ctx, topic, byt)
+				// Sensor id + timestamp uniquely identifies a reading, letting
+				// the stream drop duplicates if a publish is retried
+				msgID := fmt.Sprintf("%s-%d", data.SensorId, data.Timestamp)
+				err = nc.Publish(ctx, topic, byt, jetstream.WithMsgID(msgID))
 				if err != nil {
 					onError(err)
+					continue
 				}
+				logReading(logger, data)
 
 			}
 		}